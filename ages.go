@@ -0,0 +1,63 @@
+package main
+
+import "fmt"
+
+// UTXO Ages (-tip-height)
+// ---------
+// Puts each utxo in to a bucket by how long ago it was created (the "HODL waves" view of the utxo set), and adds up the
+// count and amount in each one. Blocks are converted to time at the target of one block every 10 minutes, so the
+// buckets are approximate:
+//
+//   144 blocks    = 1 day
+//   1008 blocks   = 1 week
+//   4383 blocks   = 1 month (30.44 days)
+//   52596 blocks  = 1 year (365.25 days)
+type ageBucket struct {
+    name      string
+    maxBlocks int // ages below this go in this bucket (the last bucket has no maximum)
+}
+
+var ageBuckets = []ageBucket{
+    {"<1 day", 144},
+    {"1-7 days", 1008},
+    {"1-4 weeks", 4032},
+    {"1-6 months", 6 * 4383},
+    {"6-12 months", 52596},
+    {"1-2 years", 2 * 52596},
+    {"2+ years", 0},
+}
+
+type ageHistogram struct {
+    counts  []int
+    amounts []int // satoshis
+}
+
+func newAgeHistogram() *ageHistogram {
+    return &ageHistogram{counts: make([]int, len(ageBuckets)), amounts: make([]int, len(ageBuckets))}
+}
+
+// add a utxo that is age blocks old (tip height - height), anything above the tip goes in the first bucket
+func (h *ageHistogram) add(age int, amount int) {
+    b := len(ageBuckets) - 1
+    for i, bucket := range ageBuckets[:b] {
+        if age < bucket.maxBlocks {
+            b = i
+            break
+        }
+    }
+    h.counts[b]++
+    h.amounts[b] += amount
+}
+
+// print the buckets as a table, with the share of the total amount in each one (and thousands separators with -human)
+func (h *ageHistogram) print(totalAmount int, human humanFormat) {
+    fmt.Println("UTXO Ages:")
+    for i, bucket := range ageBuckets {
+        share := 0.0
+        if totalAmount > 0 {
+            share = float64(h.amounts[i]) / float64(totalAmount) * 100
+        }
+        fmt.Printf(" %-12s %10s utxos %20s BTC (%5.2f%%)\n", bucket.name, human.count(h.counts[i]), human.number(formatBTC(h.amounts[i])), share)
+    }
+}
+
@@ -0,0 +1,50 @@
+package main
+
+import "bufio"
+import "fmt"
+import "io"
+import "os"
+import "strings"
+
+// Append (-append)
+// ----------------
+// Adds the rows on to the end of an existing csv file instead of starting a new one (e.g. carrying on from a dump that
+// got stopped part way through, or putting a few dumps in to one file). The header is already in the file, so it
+// doesn't get written again, but it has to match the -f fields, otherwise the new rows would end up in the wrong
+// columns. The file also has to end with a newline, or the first new row would get stuck on to the end of a row that
+// was cut short.
+//
+// existingHeader returns the header (first line) of the file and its size, or a size of 0 if it doesn't exist yet.
+func existingHeader(path string) (string, int64, error) {
+    f, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return "", 0, nil // (a new file gets a header as normal)
+    }
+    if err != nil {
+        return "", 0, err
+    }
+    defer f.Close()
+
+    info, err := f.Stat()
+    if err != nil {
+        return "", 0, err
+    }
+    if info.Size() == 0 {
+        return "", 0, nil
+    }
+
+    // the last byte has to be a newline
+    last := make([]byte, 1)
+    if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+        return "", 0, err
+    }
+    if last[0] != '\n' {
+        return "", 0, fmt.Errorf("%s doesn't end with a newline (the last row might have been cut short), so it can't be appended to", path)
+    }
+
+    header, err := bufio.NewReader(f).ReadString('\n')
+    if err != nil && err != io.EOF {
+        return "", 0, err
+    }
+    return strings.TrimRight(header, "\r\n"), info.Size(), nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import "bufio"
+import "encoding/binary" // little-endian integers for the arrow buffers and flatbuffers
+import "strconv"         // parse integer fields from the output map
+
+// Arrow IPC Stream
+// ----------------
+// Writes the utxos as an Arrow IPC stream (https://arrow.apache.org/docs/format/Columnar.html#ipc-streaming-format)
+// so that it can be read straight in to pandas/polars with pyarrow.ipc.open_stream() without parsing any csv.
+//
+//   <continuation 0xFFFFFFFF><metadata size int32><Schema message flatbuffer><padding>
+//   <continuation 0xFFFFFFFF><metadata size int32><RecordBatch message flatbuffer><padding><body>
+//   ...
+//   <continuation 0xFFFFFFFF><00000000> <- end of stream
+//
+// Integer fields (see fieldTypes) are stored as int64 columns, everything else as utf8 columns.
+// Rows are collected in to a record batch of -batch-size rows before being written out.
+//
+// The int columns are nullable, as some of them are empty for some rows (e.g. hash_leading_zeros for a p2pk, or
+// tx_output_count when the rows for a txid have been filtered out). An empty value is stored as a null (with a bit in the
+// validity bitmap for each row: 1 = has a value, 0 = null), so it doesn't come out as a 0. The strings just stay empty.
+type arrowWriter struct {
+    w         *bufio.Writer
+    batchSize int
+    fields    []string
+    ints      [][]int64  // column values for int fields
+    nulls     [][]bool   // which of the int values are empty
+    strs      [][]string // column values for string fields
+    rows      int        // number of rows in the current batch
+}
+
+// arrow flatbuffer constants (from Schema.fbs and Message.fbs)
+const (
+    arrowMetadataV5   = 4 // MetadataVersion.V5
+    arrowSchema       = 1 // MessageHeader.Schema
+    arrowRecordBatch  = 3 // MessageHeader.RecordBatch
+    arrowTypeInt      = 2 // Type.Int
+    arrowTypeUtf8     = 5 // Type.Utf8
+)
+
+func (a *arrowWriter) Header(fields []string) error {
+    a.fields = fields
+    a.ints = make([][]int64, len(fields))
+    a.nulls = make([][]bool, len(fields))
+    a.strs = make([][]string, len(fields))
+    if a.batchSize <= 0 {
+        a.batchSize = 65536
+    }
+
+    // Schema message
+    var fbFields []fbObject
+    for _, name := range fields {
+        field := &fbTable{}
+        field.offset(0, fbString(name))
+        if fieldTypes[name] == "int" {
+            field.scalar(1, 1, 1) // nullable = true
+            field.scalar(2, 1, arrowTypeInt)
+            intType := &fbTable{}
+            intType.scalar(0, 4, 64) // bitWidth
+            intType.scalar(1, 1, 1)  // is_signed
+            field.offset(3, intType)
+        } else {
+            field.scalar(1, 1, 0) // nullable = false
+            field.scalar(2, 1, arrowTypeUtf8)
+            field.offset(3, &fbTable{}) // Utf8 table has no fields
+        }
+        field.offset(5, fbTables{}) // children (empty, but readers expect the vector to be there)
+        fbFields = append(fbFields, field)
+    }
+    schema := &fbTable{}
+    schema.offset(1, fbTables(fbFields))
+
+    return a.writeMessage(arrowSchema, schema, nil)
+}
+
+func (a *arrowWriter) Row(output map[string]string) error {
+    for i, name := range a.fields {
+        if fieldTypes[name] == "int" {
+            n, err := strconv.ParseInt(output[name], 10, 64) // (an error means it's empty, so it's a null)
+            a.ints[i] = append(a.ints[i], n)
+            a.nulls[i] = append(a.nulls[i], err != nil)
+        } else {
+            a.strs[i] = append(a.strs[i], output[name])
+        }
+    }
+    a.rows++
+    if a.rows >= a.batchSize {
+        return a.flushBatch()
+    }
+    return nil
+}
+
+func (a *arrowWriter) Close() error {
+    if a.rows > 0 {
+        if err := a.flushBatch(); err != nil {
+            return err
+        }
+    }
+    _, err := a.w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}) // end of stream marker
+    return err
+}
+
+// flushBatch writes the current rows as a RecordBatch message and empties the columns
+func (a *arrowWriter) flushBatch() error {
+    var body []byte
+    var nodes []byte   // FieldNode structs (length, null_count)
+    var buffers []byte // Buffer structs (offset, length)
+
+    // addBuffer appends a buffer to the body (padded to 8 bytes) and records where it is
+    addBuffer := func(data []byte) {
+        buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(body)))
+        buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(data)))
+        body = append(body, data...)
+        for len(body)%8 != 0 {
+            body = append(body, 0)
+        }
+    }
+
+    for i, name := range a.fields {
+        if fieldTypes[name] == "int" {
+            validity, nullCount := arrowValidity(a.nulls[i])
+            nodes = binary.LittleEndian.AppendUint64(nodes, uint64(a.rows))
+            nodes = binary.LittleEndian.AppendUint64(nodes, uint64(nullCount))
+            addBuffer(validity)
+            a.nulls[i] = a.nulls[i][:0]
+
+            values := make([]byte, 0, 8*a.rows)
+            for _, n := range a.ints[i] {
+                values = binary.LittleEndian.AppendUint64(values, uint64(n))
+            }
+            addBuffer(values)
+            a.ints[i] = a.ints[i][:0]
+        } else {
+            nodes = binary.LittleEndian.AppendUint64(nodes, uint64(a.rows))
+            nodes = binary.LittleEndian.AppendUint64(nodes, 0) // no nulls
+            addBuffer(nil) // validity bitmap can be left empty when there are no nulls
+
+            offsets := make([]byte, 0, 4*(a.rows+1))
+            var data []byte
+            offsets = binary.LittleEndian.AppendUint32(offsets, 0)
+            for _, s := range a.strs[i] {
+                data = append(data, s...)
+                offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+            }
+            addBuffer(offsets)
+            addBuffer(data)
+            a.strs[i] = a.strs[i][:0]
+        }
+    }
+
+    batch := &fbTable{}
+    batch.scalar(0, 8, uint64(a.rows)) // length
+    batch.offset(1, fbStructs{nodes, len(nodes) / 16})
+    batch.offset(2, fbStructs{buffers, len(buffers) / 16})
+    a.rows = 0
+
+    return a.writeMessage(arrowRecordBatch, batch, body)
+}
+
+// arrowValidity builds the validity bitmap for a column (one bit per row, least significant bit first, 1 = not null), and
+// counts the nulls. If there aren't any the bitmap is left empty, which readers take to mean every row has a value.
+//
+//   rows:   5 0 null 3 null 1 2 4 9   -> 11101011 00000001 (bytes 0xeb 0x01), 2 nulls
+func arrowValidity(nulls []bool) ([]byte, int) {
+    count := 0
+    bitmap := make([]byte, (len(nulls)+7)/8)
+    for row, null := range nulls {
+        if null {
+            count++
+        } else {
+            bitmap[row/8] |= 1 << (row % 8)
+        }
+    }
+    if count == 0 {
+        return nil, 0
+    }
+    return bitmap, count
+}
+
+// writeMessage writes an encapsulated IPC message (the Message flatbuffer followed by the body)
+func (a *arrowWriter) writeMessage(headerType uint64, header fbObject, body []byte) error {
+    message := &fbTable{}
+    message.scalar(0, 2, arrowMetadataV5)
+    message.scalar(1, 1, headerType)
+    message.offset(2, header)
+    message.scalar(3, 8, uint64(len(body))) // bodyLength
+    metadata := fbFinish(message)
+
+    // metadata is padded so that the body starts on an 8-byte boundary
+    for (8+len(metadata))%8 != 0 {
+        metadata = append(metadata, 0)
+    }
+
+    prefix := []byte{0xff, 0xff, 0xff, 0xff} // continuation marker
+    prefix = binary.LittleEndian.AppendUint32(prefix, uint32(len(metadata)))
+    if _, err := a.w.Write(prefix); err != nil {
+        return err
+    }
+    if _, err := a.w.Write(metadata); err != nil {
+        return err
+    }
+    _, err := a.w.Write(body)
+    return err
+}
+
+// Flatbuffers
+// -----------
+// Just enough of a flatbuffers builder to write the arrow Message/Schema/RecordBatch tables.
+// Objects are laid out front-to-back: a table's vtable comes first, then the table, then anything it points to
+// (flatbuffers offsets to child objects are unsigned, so children always have to come after their parent).
+// Everything is aligned relative to the start of the buffer.
+
+type fbObject interface {
+    write(b *[]byte) int // append the object to the buffer and return its position
+}
+
+type fbField struct {
+    size  int      // size of the scalar in bytes (0 if this is an offset to a child object)
+    value uint64
+    child fbObject
+}
+
+type fbTable struct {
+    fields map[int]fbField // field id -> value
+}
+
+func (t *fbTable) scalar(id int, size int, value uint64) {
+    if t.fields == nil {
+        t.fields = map[int]fbField{}
+    }
+    t.fields[id] = fbField{size: size, value: value}
+}
+
+func (t *fbTable) offset(id int, child fbObject) {
+    if t.fields == nil {
+        t.fields = map[int]fbField{}
+    }
+    t.fields[id] = fbField{child: child}
+}
+
+func (t *fbTable) write(b *[]byte) int {
+    // number of fields in the vtable
+    n := 0
+    for id := range t.fields {
+        if id+1 > n {
+            n = id + 1
+        }
+    }
+
+    // vtable: [vtable size][table size][offset of each field in the table]
+    fbPad(b, 2)
+    vtable := len(*b)
+    *b = append(*b, make([]byte, 4+2*n)...)
+
+    // table: [soffset to vtable][fields...]
+    fbPad(b, 8)
+    table := len(*b)
+    *b = binary.LittleEndian.AppendUint32(*b, uint32(table-vtable))
+
+    positions := map[int]int{}
+    for id := 0; id < n; id++ {
+        f, ok := t.fields[id]
+        if !ok {
+            continue
+        }
+        size := f.size
+        if f.child != nil {
+            size = 4 // uoffset
+        }
+        fbPad(b, size)
+        positions[id] = len(*b)
+        binary.LittleEndian.PutUint16((*b)[vtable+4+2*id:], uint16(len(*b)-table))
+        switch size {
+        case 1:
+            *b = append(*b, byte(f.value))
+        case 2:
+            *b = binary.LittleEndian.AppendUint16(*b, uint16(f.value))
+        case 4:
+            *b = binary.LittleEndian.AppendUint32(*b, uint32(f.value))
+        case 8:
+            *b = binary.LittleEndian.AppendUint64(*b, f.value)
+        }
+    }
+    binary.LittleEndian.PutUint16((*b)[vtable:], uint16(4+2*n))
+    binary.LittleEndian.PutUint16((*b)[vtable+2:], uint16(len(*b)-table))
+
+    // children
+    for id := 0; id < n; id++ {
+        f, ok := t.fields[id]
+        if !ok || f.child == nil {
+            continue
+        }
+        child := f.child.write(b)
+        binary.LittleEndian.PutUint32((*b)[positions[id]:], uint32(child-positions[id]))
+    }
+
+    return table
+}
+
+// string: [length][bytes][0]
+type fbString string
+
+func (s fbString) write(b *[]byte) int {
+    fbPad(b, 4)
+    pos := len(*b)
+    *b = binary.LittleEndian.AppendUint32(*b, uint32(len(s)))
+    *b = append(*b, s...)
+    *b = append(*b, 0)
+    return pos
+}
+
+// vector of tables: [length][offset to each table]
+type fbTables []fbObject
+
+func (v fbTables) write(b *[]byte) int {
+    fbPad(b, 4)
+    pos := len(*b)
+    *b = binary.LittleEndian.AppendUint32(*b, uint32(len(v)))
+    *b = append(*b, make([]byte, 4*len(v))...)
+    for i, t := range v {
+        child := t.write(b)
+        slot := pos + 4 + 4*i
+        binary.LittleEndian.PutUint32((*b)[slot:], uint32(child-slot))
+    }
+    return pos
+}
+
+// vector of 16-byte structs: [length][structs] (structs are 8-byte aligned)
+type fbStructs struct {
+    data  []byte
+    count int
+}
+
+func (v fbStructs) write(b *[]byte) int {
+    for (len(*b)+4)%8 != 0 {
+        *b = append(*b, 0)
+    }
+    pos := len(*b)
+    *b = binary.LittleEndian.AppendUint32(*b, uint32(v.count))
+    *b = append(*b, v.data...)
+    return pos
+}
+
+// fbFinish returns a complete flatbuffer with the given table as the root
+func fbFinish(root fbObject) []byte {
+    b := make([]byte, 4) // offset to root table
+    pos := root.write(&b)
+    binary.LittleEndian.PutUint32(b, uint32(pos))
+    return b
+}
+
+func fbPad(b *[]byte, align int) {
+    for len(*b)%align != 0 {
+        *b = append(*b, 0)
+    }
+}
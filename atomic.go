@@ -0,0 +1,57 @@
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+
+// Atomic Output (-atomic)
+// -----------------------
+// A dump that stops part way through (an error reading the database, a full disk, too many panics, ctrl-c) leaves an
+// output file that looks just like a finished one, and something downstream could pick it up without noticing it's
+// missing most of the utxos. So this writes to the -o file with .tmp on the end instead, and only renames it to the -o
+// file once everything has been written (the buffer flushed, the format finished, and no error from the iterator):
+//
+//   utxodump.csv.tmp -> utxodump.csv
+//
+// The rename replaces the file in one go, so anything looking at the -o file only ever sees the old one or the whole of
+// the new one. If the dump doesn't finish, the .tmp file is left where it is (to look at what went wrong), the -o file
+// isn't touched, and the program exits with a status of 1.
+//
+// Only the -o file is done like this, not the other files (-offset-index, -summary-file, -log).
+type atomicFile struct {
+    path string // -o
+    tmp  string // where it's written until it's finished
+    f    *os.File
+    done bool
+}
+
+func atomicTempPath(path string) string {
+    return path + ".tmp"
+}
+
+// commit flushes the rest of the rows to the temp file, and moves it to the -o file
+func (a *atomicFile) commit(w *bufio.Writer) error {
+    if err := w.Flush(); err != nil {
+        return fmt.Errorf("couldn't write %s: %v", a.tmp, err)
+    }
+    if err := a.f.Sync(); err != nil { // (make sure it's all on disk before it gets its real name)
+        return fmt.Errorf("couldn't write %s: %v", a.tmp, err)
+    }
+    if err := a.f.Close(); err != nil {
+        return fmt.Errorf("couldn't write %s: %v", a.tmp, err)
+    }
+    if err := os.Rename(a.tmp, a.path); err != nil {
+        return fmt.Errorf("couldn't rename %s to %s: %v", a.tmp, a.path, err)
+    }
+    a.done = true
+    return nil
+}
+
+// abandoned says where the unfinished output is, if it didn't get committed (returns false if it did)
+func (a *atomicFile) abandoned() bool {
+    if a.done {
+        return false
+    }
+    fmt.Printf("The dump didn't finish, so the output has been left in %s (%s hasn't been touched).\n", a.tmp, a.path)
+    return true
+}
@@ -56,13 +56,20 @@ func hrpExpand(hrp string) []int {
     return ret
 }
 
-func verifyChecksum(hrp string, data []int) bool {
-    return polymod(append(hrpExpand(hrp), data...)) == 1
+// checksumConstant is what the checksum of a valid string works out to, bech32Const or bech32mConst (anything else is invalid)
+func checksumConstant(hrp string, data []int) int {
+    return polymod(append(hrpExpand(hrp), data...))
 }
 
-func createChecksum(hrp string, data []int) []int {
+// Checksum constants: Bech32 (BIP-173) for witness version 0, Bech32m (BIP-350) for witness version 1 and above
+const (
+    bech32Const  = 1
+    bech32mConst = 0x2bc830a3
+)
+
+func createChecksum(hrp string, data []int, constant int) []int {
     values := append(append(hrpExpand(hrp), data...), []int{0, 0, 0, 0, 0, 0}...)
-    mod := polymod(values) ^ 1
+    mod := polymod(values) ^ constant
     ret := make([]int, 6)
     for p := 0; p < len(ret); p++ {
         ret[p] = (mod >> uint(5*(5-p))) & 31
@@ -73,6 +80,15 @@ func createChecksum(hrp string, data []int) []int {
 // Encode encodes hrp(human-readable part) and data(32bit data array), returns Bech32 / or error
 // if hrp is uppercase, return uppercase Bech32
 func Encode(hrp string, data []int) (string, error) {
+    return encode(hrp, data, bech32Const)
+}
+
+// EncodeM is the same as Encode, but returns Bech32m (BIP-350)
+func EncodeM(hrp string, data []int) (string, error) {
+    return encode(hrp, data, bech32mConst)
+}
+
+func encode(hrp string, data []int, constant int) (string, error) {
     if (len(hrp) + len(data) + 7) > 90 {
         return "", fmt.Errorf("too long : hrp length=%d, data length=%d", len(hrp), len(data))
     }
@@ -89,7 +105,7 @@ func Encode(hrp string, data []int) (string, error) {
     }
     lower := strings.ToLower(hrp) == hrp
     hrp = strings.ToLower(hrp)
-    combined := append(data, createChecksum(hrp, data)...)
+    combined := append(data, createChecksum(hrp, data, constant)...)
     var ret bytes.Buffer
     ret.WriteString(hrp)
     ret.WriteString("1")
@@ -107,35 +123,60 @@ func Encode(hrp string, data []int) (string, error) {
 
 // Decode decodes bechString(Bech32) returns hrp(human-readable part) and data(32bit data array) / or error
 func Decode(bechString string) (string, []int, error) {
+    hrp, data, constant, err := decode(bechString)
+    if err != nil {
+        return "", nil, err
+    }
+    if constant != bech32Const {
+        return "", nil, fmt.Errorf("invalid checksum")
+    }
+    return hrp, data, nil
+}
+
+// DecodeM is the same as Decode, but for Bech32m (BIP-350)
+func DecodeM(bechString string) (string, []int, error) {
+    hrp, data, constant, err := decode(bechString)
+    if err != nil {
+        return "", nil, err
+    }
+    if constant != bech32mConst {
+        return "", nil, fmt.Errorf("invalid checksum")
+    }
+    return hrp, data, nil
+}
+
+// decode checks the string is either Bech32 or Bech32m, and returns which one (the checksum constant)
+func decode(bechString string) (string, []int, int, error) {
     if len(bechString) > 90 {
-        return "", nil, fmt.Errorf("too long : len=%d", len(bechString))
+        return "", nil, 0, fmt.Errorf("too long : len=%d", len(bechString))
     }
     if strings.ToLower(bechString) != bechString && strings.ToUpper(bechString) != bechString {
-        return "", nil, fmt.Errorf("mixed case")
+        return "", nil, 0, fmt.Errorf("mixed case")
     }
     bechString = strings.ToLower(bechString)
     pos := strings.LastIndex(bechString, "1")
     if pos < 1 || pos+7 > len(bechString) {
-        return "", nil, fmt.Errorf("separator '1' at invalid position : pos=%d , len=%d", pos, len(bechString))
+        return "", nil, 0, fmt.Errorf("separator '1' at invalid position : pos=%d , len=%d", pos, len(bechString))
     }
     hrp := bechString[0:pos]
     for p, c := range hrp {
         if c < 33 || c > 126 {
-            return "", nil, fmt.Errorf("invalid character human-readable part : bechString[%d]=%d", p, c)
+            return "", nil, 0, fmt.Errorf("invalid character human-readable part : bechString[%d]=%d", p, c)
         }
     }
     data := []int{}
     for p := pos + 1; p < len(bechString); p++ {
         d := strings.Index(charset, fmt.Sprintf("%c", bechString[p]))
         if d == -1 {
-            return "", nil, fmt.Errorf("invalid character data part : bechString[%d]=%d", p, bechString[p])
+            return "", nil, 0, fmt.Errorf("invalid character data part : bechString[%d]=%d", p, bechString[p])
         }
         data = append(data, d)
     }
-    if !verifyChecksum(hrp, data) {
-        return "", nil, fmt.Errorf("invalid checksum")
+    constant := checksumConstant(hrp, data)
+    if constant != bech32Const && constant != bech32mConst {
+        return "", nil, 0, fmt.Errorf("invalid checksum")
     }
-    return hrp, data[:len(data)-6], nil
+    return hrp, data[:len(data)-6], constant, nil
 }
 
 func convertbits(data []int, frombits, tobits uint, pad bool) ([]int, error) {
@@ -167,8 +208,12 @@ func convertbits(data []int, frombits, tobits uint, pad bool) ([]int, error) {
 }
 
 // SegwitAddrDecode decodes hrp(human-readable part) Segwit Address(string), returns version(int) and data(bytes array) / or error
+// witness version 0 has to use the bech32 checksum, and version 1+ (e.g. taproot) has to use bech32m (BIP-350), e.g.
+//
+//   bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4                      -> 0, 751e76e8199196d454941c45d1b3a323f1433bd6
+//   bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0 -> 1, 79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798
 func SegwitAddrDecode(hrp, addr string) (int, []int, error) {
-    dechrp, data, err := Decode(addr)
+    dechrp, data, constant, err := decode(addr)
     if err != nil {
         return -1, nil, err
     }
@@ -181,6 +226,9 @@ func SegwitAddrDecode(hrp, addr string) (int, []int, error) {
     if data[0] > 16 {
         return -1, nil, fmt.Errorf("invalid witness version : %d", data[0])
     }
+    if (data[0] == 0 && constant != bech32Const) || (data[0] > 0 && constant != bech32mConst) {
+        return -1, nil, fmt.Errorf("invalid checksum for witness version %d (bech32 for version 0, bech32m for 1+)", data[0])
+    }
     res, err := convertbits(data[1:], 5, 8, false)
     if err != nil {
         return -1, nil, err
@@ -209,7 +257,12 @@ func SegwitAddrEncode(hrp string, version int, program []int) (string, error) {
     if err != nil {
         return "", err
     }
-    ret, err := Encode(hrp, append([]int{version}, data...))
+    // witness version 0 uses the original bech32 checksum, version 1+ (e.g. taproot) uses bech32m
+    constant := bech32Const
+    if version > 0 {
+        constant = bech32mConst
+    }
+    ret, err := encode(hrp, append([]int{version}, data...), constant)
     if err != nil {
         return "", err
     }
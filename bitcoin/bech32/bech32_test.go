@@ -0,0 +1,174 @@
+package bech32
+
+import "encoding/hex"
+import "strings"
+import "testing"
+
+// Test vectors from BIP-173 (bech32) and BIP-350 (bech32m)
+
+var validBech32 = []string{
+    "A12UEL5L",
+    "a12uel5l",
+    "an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+    "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+    "11" + strings.Repeat("q", 82) + "c8247j", // (90 characters, the longest there can be)
+    "split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+    "?1ezyfcl",
+}
+
+var validBech32m = []string{
+    "A1LQFN3A",
+    "a1lqfn3a",
+    "an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+    "abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+    "11" + strings.Repeat("l", 82) + "ludsr8",
+    "split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+    "?1v759aa",
+}
+
+// each string only decodes with its own checksum
+func TestDecodeChecksums(t *testing.T) {
+    for _, s := range validBech32 {
+        if _, _, err := Decode(s); err != nil {
+            t.Errorf("Decode(%s): %v", s, err)
+        }
+        if _, _, err := DecodeM(s); err == nil {
+            t.Errorf("DecodeM(%s) accepted a bech32 checksum", s)
+        }
+    }
+    for _, s := range validBech32m {
+        if _, _, err := DecodeM(s); err != nil {
+            t.Errorf("DecodeM(%s): %v", s, err)
+        }
+        if _, _, err := Decode(s); err == nil {
+            t.Errorf("Decode(%s) accepted a bech32m checksum", s)
+        }
+    }
+}
+
+func TestSegwitAddrDecodeValid(t *testing.T) {
+    tests := []struct {
+        address      string
+        scriptPubKey string // witness version opcode + push + program
+    }{
+        {"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+        {"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+        {"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", "5128751e76e8199196d454941c45d1b3a323f1433bd6751e76e8199196d454941c45d1b3a323f1433bd6"},
+        {"BC1SW50QGDZ25J", "6002751e"},
+        {"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "5210751e76e8199196d454941c45d1b3a323"},
+        {"tb1qqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesrxh6hy", "0020000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+        {"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+        {"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.address, func(t *testing.T) {
+            hrp := strings.ToLower(tt.address[:2])
+            version, program, err := SegwitAddrDecode(hrp, tt.address)
+            if err != nil {
+                t.Fatalf("SegwitAddrDecode: %v", err)
+            }
+
+            // scriptPubKey: OP_0 or OP_1..OP_16 (0x51..0x60), then the push of the program
+            op := 0
+            if version > 0 {
+                op = 0x50 + version
+            }
+            script := []byte{byte(op), byte(len(program))}
+            for _, b := range program {
+                script = append(script, byte(b))
+            }
+            if got := hex.EncodeToString(script); got != tt.scriptPubKey {
+                t.Errorf("scriptPubKey = %s, want %s", got, tt.scriptPubKey)
+            }
+
+            // and back again (the encoder always writes lowercase)
+            address, err := SegwitAddrEncode(hrp, version, program)
+            if err != nil {
+                t.Fatalf("SegwitAddrEncode: %v", err)
+            }
+            if address != strings.ToLower(tt.address) {
+                t.Errorf("SegwitAddrEncode = %s, want %s", address, strings.ToLower(tt.address))
+            }
+        })
+    }
+}
+
+func TestSegwitAddrDecodeInvalid(t *testing.T) {
+    tests := []struct {
+        address string
+        reason  string
+    }{
+        {"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut", "invalid human-readable part"},
+        {"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", "version 1 with a bech32 checksum"},
+        {"tb1z0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqglt7rf", "version 2 with a bech32 checksum"},
+        {"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL", "version 16 with a bech32 checksum"},
+        {"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", "version 0 with a bech32m checksum"},
+        {"tb1q0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq24jc47", "version 0 with a bech32m checksum"},
+        {"bc1p38j9r5y49hruaue7wxjce0updqjuyyx0kh56v8s25huc6995vvpql3jow4", "invalid character in the checksum"},
+        {"BC130XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ7ZWS8R", "invalid witness version (17)"},
+        {"bc1pw5dgrnzv", "invalid program length (1 byte)"},
+        {"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v8n0nx0muaewav253zgeav", "invalid program length (41 bytes)"},
+        {"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P", "invalid program length for version 0 (16 bytes)"},
+        {"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq47Zagq", "mixed case"},
+        {"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7v07qwwzcrf", "more than 4 bits of zero padding"},
+        {"tb1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vpggkg4j", "non-zero padding"},
+        {"bc1gmk9yu", "empty data section"},
+    }
+    for _, tt := range tests {
+        hrp := "bc"
+        if strings.HasPrefix(strings.ToLower(tt.address), "tb") {
+            hrp = "tb"
+        }
+        if version, program, err := SegwitAddrDecode(hrp, tt.address); err == nil {
+            t.Errorf("SegwitAddrDecode(%s) = %d, %v, want an error (%s)", tt.address, version, program, tt.reason)
+        }
+    }
+}
+
+// version 0 always gets a bech32 checksum, and versions 1 to 16 always get bech32m
+func TestSegwitAddrEncodeChecksum(t *testing.T) {
+    // BIP-173 and BIP-350 vectors
+    known := []struct {
+        version int
+        program string
+        want    string
+    }{
+        {0, "751e76e8199196d454941c45d1b3a323f1433bd6", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+        {1, "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"},
+    }
+    for _, tt := range known {
+        b, _ := hex.DecodeString(tt.program)
+        program := make([]int, len(b))
+        for i, v := range b {
+            program[i] = int(v)
+        }
+        if got, err := SegwitAddrEncode("bc", tt.version, program); err != nil || got != tt.want {
+            t.Errorf("SegwitAddrEncode(bc, %d, %s) = %s, %v, want %s", tt.version, tt.program, got, err, tt.want)
+        }
+    }
+
+    // every version, with every program length it allows
+    for version := 0; version <= 16; version++ {
+        for length := 2; length <= 40; length++ {
+            if version == 0 && length != 20 && length != 32 {
+                continue
+            }
+            program := make([]int, length)
+            for i := range program {
+                program[i] = (i * 37 + version) % 256
+            }
+            address, err := SegwitAddrEncode("bc", version, program)
+            if err != nil {
+                t.Fatalf("SegwitAddrEncode(bc, %d, %d bytes): %v", version, length, err)
+            }
+            _, _, errBech32 := Decode(address)
+            _, _, errBech32m := DecodeM(address)
+            if version == 0 && (errBech32 != nil || errBech32m == nil) {
+                t.Errorf("version 0 (%d bytes) %s doesn't have a bech32 checksum", length, address)
+            }
+            if version > 0 && (errBech32m != nil || errBech32 == nil) {
+                t.Errorf("version %d (%d bytes) %s doesn't have a bech32m checksum", version, length, address)
+            }
+        }
+    }
+}
@@ -1,8 +1,25 @@
 package btcleveldb
 
-import "math"          // math.Pow() in decompress_value()
-
-func Varint128Read(bytes []byte, offset int) ([]byte, int) { // take a byte array and return (byte array and number of bytes read)
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/keys" // decompress public keys in p2pk scripts
+import "fmt"           // errors
+
+const maxVarintLen = 9 // bytes (63 bits)
+
+func Varint128Read(bytes []byte, offset int) ([]byte, int, error) { // take a byte array and return (byte array and number of bytes read)
+
+    // A value that's been cut short (a corrupt entry) can end before the varint does, or before it even starts:
+    //
+    //   b98276   -> b98276 (3 bytes read)
+    //   b982     -> error (the last byte still has the 8th bit set, so there should be another one)
+    //   (empty)  -> error
+    //
+    // and a corrupt one can go on for longer than any number we could decode (an int64 only has room for 9 bytes of 7 bits,
+    // and nothing in the chainstate gets anywhere near that), which is an error too:
+    //
+    //   80808080808080808000 -> error (10 bytes)
+    if offset < 0 || offset >= len(bytes) {
+        return nil, 0, fmt.Errorf("no bytes left to read a varint from (offset %d, length %d)", offset, len(bytes))
+    }
 
     // store bytes
     result := []byte{} // empty byte slice
@@ -12,19 +29,22 @@ func Varint128Read(bytes []byte, offset int) ([]byte, int) { // take a byte arra
 
         // store each byte as you go
         result = append(result, v)
+        if len(result) > maxVarintLen {
+            return nil, 0, fmt.Errorf("varint at offset %d is more than %d bytes long (%x)", offset, maxVarintLen, result)
+        }
 
         // Bitwise AND each of them with 128 (0b10000000) to check if the 8th bit has been set
         set := v & 128 // 0b10000000 is same as 1 << 7
 
         // When you get to one without the 8th bit set, return that byte slice
         if set == 0 {
-            return result, len(result)
+            return result, len(result), nil
             // Also return the number of bytes read
         }
     }
 
-    // Return zero bytes read if we haven't managed to read bytes properly
-    return result, 0
+    // Got to the end without finding the last byte of the varint
+    return nil, 0, fmt.Errorf("varint at offset %d runs past the end of the value (%x)", offset, bytes[offset:])
 
 }
 
@@ -64,11 +84,25 @@ func Varint128Decode(bytes []byte) int { // takes a byte slice, returns an int
 
 func DecompressValue(x int) int {
 
-    n := 0      // decompressed value
+    // amount = mantissa * 10^exponent
+    mantissa, exponent := DecompressValueParts(x)
+    for i := 0; i < exponent; i++ {
+        mantissa *= 10
+    }
+    return mantissa
+
+}
+
+func DecompressValueParts(x int) (int, int) { // returns the significant digits (mantissa) and the number of 0s on the end (exponent) of a compressed amount
+
+    //   5000000000 = 5 * 10^9  (mantissa 5, exponent 9)
+    //          546 = 546 * 10^0 (mantissa 546, exponent 0)
+
+    n := 0      // decompressed value (without the 0s)
 
     // Return value if it is zero (nothing to decompress)
     if x == 0 {
-        return 0
+        return 0, 0
     }
 
     // Decompress...
@@ -80,21 +114,100 @@ func DecompressValue(x int) int {
     if e < 9 {
         d := x % 9 // remainder mod 9
         x = x / 9  // (reduce x down by 9)
-        n = x * 10 + d + 1 // work out n
+        n = x * 10 + d + 1 // work out n (the last digit is never 0, so it only needs 1-9)
     } else {
         n = x + 1
     }
 
-    // Multiply n by 10 to the power of the first remainder
-    result := float64(n) * math.Pow(10, float64(e)) // math.Pow takes a float and returns a float
+    return n, e
+
+}
+
+func ObfuscateKey(value []byte) ([]byte, error) { // takes the value of the obfuscate key entry, returns the key itself
+
+    //   08b12dcefd8f872536
+    //   <><-------------->
+    //   /         \
+    // size     obfuscate key
+
+    if len(value) == 0 {
+        return nil, fmt.Errorf("obfuscate key is empty")
+    }
+
+    // The first byte tells you how long the key should be, so check it matches what we've actually got. A key that's been
+    // cut short (or has something extra on the end) would quietly deobfuscate every utxo wrong, so it's an error:
+    //
+    //   08b12dcefd8f872536   -> b12dcefd8f872536
+    //   08b12dcefd8f8725     -> error (says it is 8 bytes but it is 7 bytes)
+    //   07b12dcefd8f872536   -> error (says it is 7 bytes but it is 8 bytes)
+    //   00                   -> empty key (the values are read as they are)
+    size := int(value[0])
+    if size != len(value)-1 {
+        return nil, fmt.Errorf("obfuscate key says it is %d bytes but it is %d bytes (%x)", size, len(value)-1, value)
+    }
+
+    // Copy the key (don't hold on to the leveldb iterator's buffer)
+    key := make([]byte, size)
+    copy(key, value[1:])
+    return key, nil
+
+}
+
+func Deobfuscate(value []byte, key []byte) []byte { // XOR the value with the obfuscate key, returns the deobfuscated value
 
-    // manual exponentiation
-    // multiplier := 1
-    // for i := 0; i < e; i++ {
-    //     multiplier *= 10
-    // }
-    // fmt.Println(multiplier)
+    // No key, nothing to do
+    if len(key) == 0 {
+        return value
+    }
 
-    return int(result)
+    // The key is repeated to the length of the value (or cut short if it's longer than the value)
+    //   [175 184 95 99 240 37 253 115 181 161 4 33 81 167 111 145 131 0 233 37 232 118 180 123 120 78]
+    //   [177 45 206 253 143 135 37 54]                                                                  <- obfuscate key
+    //   [177 45 206 253 143 135 37 54 177 45 206 253 143 135 37 54 177 45 206 253 143 135 37 54 177 45] <- extended
+    xor := make([]byte, len(value))
+    for i := range value {
+        xor[i] = value[i] ^ key[i % len(key)]
+    }
+    return xor
+
+}
+
+func DecompressScript(nsize int, script []byte) ([]byte, bool) { // rebuild the full scriptPubKey from the nsize and the (compressed) script, returns false if it can't be rebuilt
+
+    // https://github.com/bitcoin/bitcoin/blob/master/src/compressor.cpp
+    switch {
+    case nsize == 0 && len(script) == 20: // P2PKH = OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY OP_CHECKSIG
+        full := []byte{0x76, 0xa9, 20}
+        full = append(full, script...)
+        return append(full, 0x88, 0xac), true
+
+    case nsize == 1 && len(script) == 20: // P2SH = OP_HASH160 <20 bytes> OP_EQUAL
+        full := []byte{0xa9, 20}
+        full = append(full, script...)
+        return append(full, 0x87), true
+
+    case (nsize == 2 || nsize == 3) && len(script) == 33: // P2PK (compressed) = <33 bytes> OP_CHECKSIG (the script already starts with the 02/03 from nsize)
+        full := []byte{33}
+        full = append(full, script...)
+        return append(full, 0xac), true
+
+    case (nsize == 4 || nsize == 5) && len(script) == 33: // P2PK (uncompressed) = <65 bytes> OP_CHECKSIG (4 = y is even, 5 = y is odd)
+        pubkey := keys.DecompressPublicKey(script[1:], nsize == 5)
+        if pubkey == nil {
+            return nil, false // x isn't on the curve
+        }
+        full := []byte{65}
+        full = append(full, pubkey...)
+        return append(full, 0xac), true
+
+    case (nsize == 4 || nsize == 5) && len(script) == 65 && script[0] == 0x04: // P2PK (uncompressed) that's already been decompressed (e.g. the script field)
+        full := []byte{65}
+        full = append(full, script...)
+        return append(full, 0xac), true
+
+    case nsize > 5 && len(script) == nsize-6: // full script
+        return script, true
+    }
 
+    return nil, false // the script isn't the length the nsize says it should be
 }
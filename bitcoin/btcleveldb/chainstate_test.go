@@ -0,0 +1,122 @@
+package btcleveldb
+
+import "bytes"
+import "encoding/hex"
+import "testing"
+
+func unhex(t *testing.T, s string) []byte {
+    t.Helper()
+    b, err := hex.DecodeString(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+func TestVarint128Read(t *testing.T) {
+    tests := []struct {
+        name   string
+        value  string
+        offset int
+        want   string // the varint (empty if it should be an error)
+    }{
+        {"whole value", "b98276", 0, "b98276"},
+        {"stops at the last byte", "b98276a2ec", 0, "b98276"},
+        {"from an offset", "c0842680ed59", 4, "ed59"},
+        {"single byte", "00", 0, "00"},
+        {"nine bytes", "808080808080808000", 0, "808080808080808000"},
+        {"empty", "", 0, ""},
+        {"offset at the end", "b98276", 3, ""},
+        {"negative offset", "b98276", -1, ""},
+        {"truncated", "b982", 0, ""},
+        {"truncated after an offset", "c0842680ed", 4, ""},
+        {"ten bytes (too big for an int64)", "80808080808080808000", 0, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            varint, n, err := Varint128Read(unhex(t, tt.value), tt.offset)
+            if tt.want == "" {
+                if err == nil {
+                    t.Fatalf("Varint128Read(%s, %d) = %x, want an error", tt.value, tt.offset, varint)
+                }
+                if varint != nil || n != 0 {
+                    t.Errorf("Varint128Read(%s, %d) returned %x (%d bytes) with the error", tt.value, tt.offset, varint, n)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Varint128Read(%s, %d): %v", tt.value, tt.offset, err)
+            }
+            want := unhex(t, tt.want)
+            if !bytes.Equal(varint, want) || n != len(want) {
+                t.Errorf("Varint128Read(%s, %d) = %x (%d bytes), want %s (%d bytes)", tt.value, tt.offset, varint, n, tt.want, len(want))
+            }
+        })
+    }
+}
+
+func TestObfuscateKey(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+        want  string // the key (ignored if it should be an error)
+        err   bool
+    }{
+        {"correct", "08b12dcefd8f872536", "b12dcefd8f872536", false},
+        {"shorter than it says", "08b12dcefd8f8725", "", true},
+        {"longer than it says", "07b12dcefd8f872536", "", true},
+        {"no key", "00", "", false},
+        {"empty", "", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            value := unhex(t, tt.value)
+            key, err := ObfuscateKey(value)
+            if tt.err {
+                if err == nil {
+                    t.Fatalf("ObfuscateKey(%s) = %x, want an error", tt.value, key)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ObfuscateKey(%s): %v", tt.value, err)
+            }
+            if hex.EncodeToString(key) != tt.want {
+                t.Errorf("ObfuscateKey(%s) = %x, want %s", tt.value, key, tt.want)
+            }
+
+            // it's a copy, not a slice of the value (which is leveldb's buffer)
+            if len(key) > 0 {
+                value[1] ^= 0xff
+                if hex.EncodeToString(key) != tt.want {
+                    t.Errorf("ObfuscateKey(%s) returned part of the value instead of a copy", tt.value)
+                }
+            }
+        })
+    }
+}
+
+func TestDeobfuscate(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+        key   string
+        want  string
+    }{
+        // the key is repeated to the length of the value
+        {"key shorter than the value", "71a9e87d62de25953e189f706bcf59263f15de1bf6c893bda9b045", "b12dcefd8f872536", "c0842680ed5900a38f35518de4487c108e3810e6794fb68b189d8b"},
+        {"key the same length as the value", "71a9e87d62de2595", "b12dcefd8f872536", "c0842680ed5900a3"},
+        // the key is cut short to the length of the value
+        {"key longer than the value", "71a9e8", "b12dcefd8f872536", "c08426"},
+        {"empty value", "", "b12dcefd8f872536", ""},
+        {"no key", "c0842680ed59", "", "c0842680ed59"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := Deobfuscate(unhex(t, tt.value), unhex(t, tt.key))
+            if hex.EncodeToString(got) != tt.want {
+                t.Errorf("Deobfuscate(%s, %s) = %x, want %s", tt.value, tt.key, got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,237 @@
+package btcscript
+
+import "encoding/hex" // pushes in Disasm
+import "errors"
+import "strconv"      // witness_vN
+import "strings"
+
+// Opcodes
+const (
+    OP_0             = 0x00
+    OP_1             = 0x51
+    OP_16            = 0x60
+    OP_CHECKSIG      = 0xac
+    OP_CHECKMULTISIG = 0xae
+)
+
+func IsP2PK(script []byte) bool { // <pubkey> OP_CHECKSIG
+    //
+    //    21 02b4632d08485ff1df2db55b9dafd23347d1c47a457072a1e87be26896549a8737 ac
+    //    <> <--------------------------------------------------------------> <>
+    //   /                              |                                       \
+    //  push 33 bytes          compressed public key                         OP_CHECKSIG
+    //
+    //    41 04ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84c ac
+    //    <> <------------------------------------------------------------------------------------------------------------------------------> <>
+    //   /                                                      |                                                                               \
+    //  push 65 bytes                               uncompressed public key                                                                  OP_CHECKSIG
+
+    // Same sizes that Bitcoin Core accepts for a public key (the first byte of the key tells you how long it should be)
+    if len(script) == 35 && script[0] == 33 && script[34] == OP_CHECKSIG {
+        return script[1] == 0x02 || script[1] == 0x03 // compressed
+    }
+    if len(script) == 67 && script[0] == 65 && script[66] == OP_CHECKSIG {
+        return script[1] == 0x04 || script[1] == 0x06 || script[1] == 0x07 // uncompressed (or hybrid)
+    }
+    return false
+}
+
+func IsP2PKH(script []byte) bool { // OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY OP_CHECKSIG
+    return len(script) == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 20 && script[23] == 0x88 && script[24] == OP_CHECKSIG
+}
+
+func IsP2SH(script []byte) bool { // OP_HASH160 <20 bytes> OP_EQUAL
+    return len(script) == 23 && script[0] == 0xa9 && script[1] == 20 && script[22] == 0x87
+}
+
+func IsP2MS(script []byte) bool { // OP_m <pubkeys...> OP_n OP_CHECKMULTISIG
+    return len(script) > 0 && script[len(script)-1] == OP_CHECKMULTISIG
+}
+
+func IsP2WPKH(script []byte) bool { // OP_0 <20 bytes>
+    return len(script) == 22 && script[0] == OP_0 && script[1] == 20
+}
+
+func IsP2WSH(script []byte) bool { // OP_0 <32 bytes>
+    return len(script) == 34 && script[0] == OP_0 && script[1] == 32
+}
+
+func IsP2TR(script []byte) bool { // OP_1 <32 bytes> (a witness version 1 program of any other length isn't taproot)
+    return len(script) == 34 && script[0] == OP_1 && script[1] == 32
+}
+
+func WitnessProgram(script []byte) []byte { // OP_0-OP_16 <2-40 bytes>, returns the program (or nil if it's not a witness program)
+    if len(script) < 4 || len(script) > 42 {
+        return nil
+    }
+    if script[0] != OP_0 && (script[0] < OP_1 || script[0] > OP_16) {
+        return nil
+    }
+    if int(script[1]) != len(script)-2 {
+        return nil
+    }
+    return script[2:]
+}
+
+func WitnessVersion(script []byte) int { // 0-16 for a witness program (OP_0 = 0, OP_1 = 1, ... OP_16 = 16), or -1 if it's not one
+    if WitnessProgram(script) == nil {
+        return -1
+    }
+    if script[0] == OP_0 {
+        return 0
+    }
+    return int(script[0]) - OP_1 + 1
+}
+
+func Type(script []byte) string { // classify a full script by matching it against the standard templates
+    switch {
+    case IsP2PK(script):
+        return "p2pk"
+    case IsP2PKH(script):
+        return "p2pkh"
+    case IsP2SH(script):
+        return "p2sh"
+    case IsP2WPKH(script):
+        return "p2wpkh"
+    case IsP2WSH(script):
+        return "p2wsh"
+    case IsP2TR(script):
+        return "p2tr"
+    case WitnessVersion(script) >= 2: // not used yet, so anyone can spend them (until a soft fork gives them a meaning)
+        return "witness_v" + strconv.Itoa(WitnessVersion(script))
+    case IsP2MS(script):
+        return "p2ms"
+    }
+    return "non-standard"
+}
+
+// Opcode names for Disasm (anything not in here is shown as OP_UNKNOWN, like bitcoin core does)
+var opcodeNames = map[byte]string{
+    0x4f: "-1", // OP_1NEGATE
+    0x50: "OP_RESERVED",
+    0x61: "OP_NOP", 0x62: "OP_VER", 0x63: "OP_IF", 0x64: "OP_NOTIF", 0x65: "OP_VERIF", 0x66: "OP_VERNOTIF",
+    0x67: "OP_ELSE", 0x68: "OP_ENDIF", 0x69: "OP_VERIFY", 0x6a: "OP_RETURN",
+    0x6b: "OP_TOALTSTACK", 0x6c: "OP_FROMALTSTACK", 0x6d: "OP_2DROP", 0x6e: "OP_2DUP", 0x6f: "OP_3DUP", 0x70: "OP_2OVER",
+    0x71: "OP_2ROT", 0x72: "OP_2SWAP", 0x73: "OP_IFDUP", 0x74: "OP_DEPTH", 0x75: "OP_DROP", 0x76: "OP_DUP", 0x77: "OP_NIP",
+    0x78: "OP_OVER", 0x79: "OP_PICK", 0x7a: "OP_ROLL", 0x7b: "OP_ROT", 0x7c: "OP_SWAP", 0x7d: "OP_TUCK",
+    0x7e: "OP_CAT", 0x7f: "OP_SUBSTR", 0x80: "OP_LEFT", 0x81: "OP_RIGHT", 0x82: "OP_SIZE",
+    0x83: "OP_INVERT", 0x84: "OP_AND", 0x85: "OP_OR", 0x86: "OP_XOR", 0x87: "OP_EQUAL", 0x88: "OP_EQUALVERIFY",
+    0x89: "OP_RESERVED1", 0x8a: "OP_RESERVED2",
+    0x8b: "OP_1ADD", 0x8c: "OP_1SUB", 0x8d: "OP_2MUL", 0x8e: "OP_2DIV", 0x8f: "OP_NEGATE", 0x90: "OP_ABS", 0x91: "OP_NOT",
+    0x92: "OP_0NOTEQUAL", 0x93: "OP_ADD", 0x94: "OP_SUB", 0x95: "OP_MUL", 0x96: "OP_DIV", 0x97: "OP_MOD",
+    0x98: "OP_LSHIFT", 0x99: "OP_RSHIFT", 0x9a: "OP_BOOLAND", 0x9b: "OP_BOOLOR", 0x9c: "OP_NUMEQUAL",
+    0x9d: "OP_NUMEQUALVERIFY", 0x9e: "OP_NUMNOTEQUAL", 0x9f: "OP_LESSTHAN", 0xa0: "OP_GREATERTHAN",
+    0xa1: "OP_LESSTHANOREQUAL", 0xa2: "OP_GREATERTHANOREQUAL", 0xa3: "OP_MIN", 0xa4: "OP_MAX", 0xa5: "OP_WITHIN",
+    0xa6: "OP_RIPEMD160", 0xa7: "OP_SHA1", 0xa8: "OP_SHA256", 0xa9: "OP_HASH160", 0xaa: "OP_HASH256",
+    0xab: "OP_CODESEPARATOR", 0xac: "OP_CHECKSIG", 0xad: "OP_CHECKSIGVERIFY", 0xae: "OP_CHECKMULTISIG",
+    0xaf: "OP_CHECKMULTISIGVERIFY",
+    0xb0: "OP_NOP1", 0xb1: "OP_CHECKLOCKTIMEVERIFY", 0xb2: "OP_CHECKSEQUENCEVERIFY", 0xb3: "OP_NOP4", 0xb4: "OP_NOP5",
+    0xb5: "OP_NOP6", 0xb6: "OP_NOP7", 0xb7: "OP_NOP8", 0xb8: "OP_NOP9", 0xb9: "OP_NOP10", 0xba: "OP_CHECKSIGADD",
+}
+
+func Disasm(script []byte) string { // script as text, e.g. OP_DUP OP_HASH160 62e907b1... OP_EQUALVERIFY OP_CHECKSIG
+    // Pushed data is shown in hex, and OP_0 to OP_16 as the numbers 0 to 16. If a push runs past the end of the script
+    // the rest is shown as [error], like bitcoin core's asm:
+    //
+    //   76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac -> OP_DUP OP_HASH160 62e907b15cbf27d5425399ebf6f0fb50ebb88f18 OP_EQUALVERIFY OP_CHECKSIG
+    //   0014751e76e8199196d454941c45d1b3a323f1433bd6       -> 0 751e76e8199196d454941c45d1b3a323f1433bd6
+    //   6a0101                                             -> OP_RETURN 01
+    //   6a05aabb                                           -> OP_RETURN [error]
+    tokens, err := Tokenize(script)
+    words := make([]string, 0, len(tokens)+1)
+    for _, t := range tokens {
+        switch {
+        case t.IsPush() && t.Op != OP_0:
+            words = append(words, hex.EncodeToString(t.Data))
+        case t.Op == OP_0:
+            words = append(words, "0")
+        case t.Op >= OP_1 && t.Op <= OP_16:
+            words = append(words, strconv.Itoa(int(t.Op-OP_1)+1))
+        case opcodeNames[t.Op] != "":
+            words = append(words, opcodeNames[t.Op])
+        default:
+            words = append(words, "OP_UNKNOWN")
+        }
+    }
+    if err != nil {
+        words = append(words, "[error]")
+    }
+    return strings.Join(words, " ")
+}
+
+// Tokens
+// ------
+// Tokenize splits a script up in to its opcodes, along with the data for each push:
+//
+//   6a0101 -> {OP_RETURN} {0x01, 01}
+//
+// If a push runs past the end of the script it returns the tokens before it and an error (the script can't be run,
+// but it can still be in a utxo, because scriptPubKeys don't get checked until they're spent).
+type Token struct {
+    Op   byte
+    Data []byte // the bytes pushed (only for the push opcodes, 0x00 to 0x4e)
+}
+
+var ErrPushPastEnd = errors.New("push runs past the end of the script")
+
+func Tokenize(script []byte) ([]Token, error) {
+    tokens := []Token{}
+    for i := 0; i < len(script); {
+        op := script[i]
+        i++
+
+        // number of bytes this opcode pushes
+        size := -1
+        switch {
+        case op >= 0x01 && op <= 0x4b: // push 1-75 bytes
+            size = int(op)
+        case op >= 0x4c && op <= 0x4e: // OP_PUSHDATA1, 2, 4 (the size comes next, little-endian)
+            n := 1 << (op - 0x4c) // 1, 2, or 4 bytes of size
+            if i+n > len(script) {
+                return tokens, ErrPushPastEnd
+            }
+            size = 0
+            for j := n - 1; j >= 0; j-- {
+                size = size<<8 | int(script[i+j])
+            }
+            i += n
+        }
+
+        if size < 0 {
+            tokens = append(tokens, Token{Op: op})
+            continue
+        }
+        if size > len(script)-i {
+            return tokens, ErrPushPastEnd
+        }
+        tokens = append(tokens, Token{Op: op, Data: script[i : i+size]})
+        i += size
+    }
+    return tokens, nil
+}
+
+func (t Token) IsPush() bool { // OP_0, a push of 1-75 bytes, or OP_PUSHDATA1/2/4
+    return t.Op <= 0x4e
+}
+
+func OpcodeName(op byte) string { // the name of an opcode, e.g. 0x6a = OP_RETURN, 0x14 = OP_PUSHBYTES_20
+    switch {
+    case op == OP_0:
+        return "OP_0"
+    case op >= 0x01 && op <= 0x4b:
+        return "OP_PUSHBYTES_" + strconv.Itoa(int(op))
+    case op == 0x4c:
+        return "OP_PUSHDATA1"
+    case op == 0x4d:
+        return "OP_PUSHDATA2"
+    case op == 0x4e:
+        return "OP_PUSHDATA4"
+    case op == 0x4f:
+        return "OP_1NEGATE" // (shown as -1 in Disasm)
+    case op >= OP_1 && op <= OP_16:
+        return "OP_" + strconv.Itoa(int(op-OP_1)+1)
+    case opcodeNames[op] != "":
+        return opcodeNames[op]
+    }
+    return "OP_UNKNOWN"
+}
@@ -0,0 +1,89 @@
+package btcscript
+
+import "encoding/hex"
+import "strings"
+import "testing"
+
+func TestWitnessType(t *testing.T) {
+    tests := []struct {
+        name    string
+        script  string
+        version int    // WitnessVersion
+        want    string // Type
+    }{
+        {"v0 20 bytes", "0014" + strings.Repeat("11", 20), 0, "p2wpkh"},
+        {"v0 32 bytes", "0020" + strings.Repeat("11", 32), 0, "p2wsh"},
+        {"v1 32 bytes", "5120" + strings.Repeat("11", 32), 1, "p2tr"},
+
+        // a witness program, but only taproot (32 bytes) has a meaning for version 1
+        {"v1 20 bytes", "5114" + strings.Repeat("11", 20), 1, "non-standard"},
+        {"v1 2 bytes", "51021111", 1, "non-standard"},
+
+        // future versions (any program length from 2 to 40 bytes)
+        {"v2 2 bytes", "52021111", 2, "witness_v2"},
+        {"v2 32 bytes", "5220" + strings.Repeat("11", 32), 2, "witness_v2"},
+        {"v2 40 bytes", "5228" + strings.Repeat("11", 40), 2, "witness_v2"},
+        {"v3 20 bytes", "5314" + strings.Repeat("11", 20), 3, "witness_v3"},
+        {"v16 32 bytes", "6020" + strings.Repeat("11", 32), 16, "witness_v16"},
+
+        // not witness programs
+        {"v2 1 byte", "520111", -1, "non-standard"},
+        {"v2 41 bytes", "5229" + strings.Repeat("11", 41), -1, "non-standard"},
+        {"push longer than the script", "52201111", -1, "non-standard"},
+        {"push shorter than the script", "5202111111", -1, "non-standard"},
+        {"OP_1NEGATE", "4f021111", -1, "non-standard"},
+        {"OP_PUSHDATA1", "4c021111", -1, "non-standard"},
+        {"empty", "", -1, "non-standard"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            script, err := hex.DecodeString(tt.script)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if version := WitnessVersion(script); version != tt.version {
+                t.Errorf("WitnessVersion(%s) = %d, want %d", tt.script, version, tt.version)
+            }
+            if got := Type(script); got != tt.want {
+                t.Errorf("Type(%s) = %s, want %s", tt.script, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestIsP2PK(t *testing.T) {
+    compressed := "02b4632d08485ff1df2db55b9dafd23347d1c47a457072a1e87be26896549a8737"
+    uncompressed := "04ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84c"
+    tests := []struct {
+        name   string
+        script string
+        want   bool
+    }{
+        {"compressed 02", "21" + compressed + "ac", true},
+        {"compressed 03", "2103" + compressed[2:] + "ac", true},
+        {"uncompressed 04", "41" + uncompressed + "ac", true},
+        {"hybrid 06", "4106" + uncompressed[2:] + "ac", true},
+        {"hybrid 07", "4107" + uncompressed[2:] + "ac", true},
+
+        {"33 bytes with an uncompressed prefix", "2104" + compressed[2:] + "ac", false},
+        {"65 bytes with a compressed prefix", "4102" + uncompressed[2:] + "ac", false},
+        {"no OP_CHECKSIG", "21" + compressed + "ad", false}, // OP_CHECKSIGVERIFY
+        {"nothing after the key", "21" + compressed, false},
+        {"push doesn't match the key", "20" + compressed + "ac", false},
+        {"32 byte key", "20" + compressed[2:] + "ac", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            script, err := hex.DecodeString(tt.script)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := IsP2PK(script); got != tt.want {
+                t.Errorf("IsP2PK(%s) = %v, want %v", tt.script, got, tt.want)
+            }
+            if want := map[bool]string{true: "p2pk", false: "non-standard"}[tt.want]; Type(script) != want {
+                t.Errorf("Type(%s) = %s, want %s", tt.script, Type(script), want)
+            }
+        })
+    }
+}
@@ -1,6 +1,7 @@
 package crypto
 
 import "crypto/sha256"
+import "golang.org/x/crypto/ripemd160" // go get golang.org/x/crypto/ripemd160
 
 
 func Hash256(bytes []byte) []byte {
@@ -9,6 +10,13 @@ func Hash256(bytes []byte) []byte {
     return hash2[:] // return slice []byte{}, not [32]byte{}
 }
 
+func Hash160(bytes []byte) []byte {
+    hash1 := sha256.Sum256(bytes) // sha256 first
+    hasher := ripemd160.New()     // then ripemd160
+    hasher.Write(hash1[:])
+    return hasher.Sum(nil)
+}
+
 func Checksum(bytes []byte) []byte {
     hash := Hash256(bytes) // hash256 the bytes
     cksum := hash[:4]      // get last 4 bytes
@@ -0,0 +1,77 @@
+package descriptor
+
+import "strings"
+
+// Output Descriptors (BIP-380)
+// ------------------
+// A descriptor describes a scriptPubKey in a way that a wallet can import (e.g. pk(02...), addr(1A1z...), raw(6a...)).
+// Each descriptor can have a checksum on the end after a # (e.g. addr(mkmZxiEcEd8ZqjQWVZuC6so5dFMKEFpN2j)#02wpgw69),
+// which Bitcoin Core's importdescriptors requires.
+
+// Characters allowed in a descriptor (their position in this string is what goes in to the checksum)
+const inputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+    "IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+    "ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+// Characters used for the checksum itself (same as bech32)
+const checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func polymod(c uint64, val uint64) uint64 {
+    c0 := c >> 35
+    c = ((c & 0x7ffffffff) << 5) ^ val
+    if c0&1 != 0 {
+        c ^= 0xf5dee51989
+    }
+    if c0&2 != 0 {
+        c ^= 0xa9fdca3312
+    }
+    if c0&4 != 0 {
+        c ^= 0x1bab10e32d
+    }
+    if c0&8 != 0 {
+        c ^= 0x3706b1677a
+    }
+    if c0&16 != 0 {
+        c ^= 0x644d626ffd
+    }
+    return c
+}
+
+// Checksum returns the 8 character checksum for a descriptor (or an empty string if it has a character that isn't allowed)
+func Checksum(desc string) string {
+    c := uint64(1)
+    cls := uint64(0)
+    clscount := 0
+    for _, ch := range desc {
+        pos := strings.IndexRune(inputCharset, ch)
+        if pos == -1 {
+            return ""
+        }
+        c = polymod(c, uint64(pos&31)) // lower 5 bits of each character
+        cls = cls*3 + uint64(pos>>5)    // upper bits get grouped in threes
+        clscount++
+        if clscount == 3 {
+            c = polymod(c, cls)
+            cls = 0
+            clscount = 0
+        }
+    }
+    if clscount > 0 {
+        c = polymod(c, cls)
+    }
+    for j := 0; j < 8; j++ {
+        c = polymod(c, 0) // shift further to make room for the checksum
+    }
+    c ^= 1
+
+    checksum := make([]byte, 8)
+    for j := 0; j < 8; j++ {
+        checksum[j] = checksumCharset[(c>>(5*(7-j)))&31]
+    }
+    return string(checksum)
+}
+
+// AddChecksum puts the checksum on the end of a descriptor (e.g. raw(deadbeef) to raw(deadbeef)#89f8spxm)
+func AddChecksum(desc string) string {
+    return desc + "#" + Checksum(desc)
+}
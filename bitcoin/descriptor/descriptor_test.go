@@ -0,0 +1,31 @@
+package descriptor
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+    tests := []struct {
+        desc string
+        want string
+    }{
+        {"raw(deadbeef)", "89f8spxm"}, // BIP-380
+        {"addr(mkmZxiEcEd8ZqjQWVZuC6so5dFMKEFpN2j)", "02wpgw69"}, // bitcoin core's doc/descriptors.md
+        {"pk(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)", "gn28ywm7"}, // (the reference code in BIP-380)
+        {"raw(Ü)", ""}, // character that isn't allowed
+    }
+    for _, tt := range tests {
+        if got := Checksum(tt.desc); got != tt.want {
+            t.Errorf("Checksum(%s) = %q, want %q", tt.desc, got, tt.want)
+        }
+    }
+
+    // BIP-380's "error in payload" vector doesn't have the same checksum
+    if got := Checksum("raw(dedbeef)"); got == "89f8spxm" {
+        t.Errorf("Checksum(raw(dedbeef)) = %s, the same as raw(deadbeef)", got)
+    }
+}
+
+func TestAddChecksum(t *testing.T) {
+    if got := AddChecksum("raw(deadbeef)"); got != "raw(deadbeef)#89f8spxm" {
+        t.Errorf("AddChecksum(raw(deadbeef)) = %s, want raw(deadbeef)#89f8spxm", got)
+    }
+}
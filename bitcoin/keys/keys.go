@@ -2,6 +2,9 @@ package keys
 
 import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/crypto"
 import "github.com/akamensky/base58"
+import "bytes" // compare checksums
+import "fmt"
+import "math/big" // secp256k1 field arithmetic
 
 func Hash160ToAddress(hash160 []byte, prefix []byte) string {
     //
@@ -16,3 +19,78 @@ func Hash160ToAddress(hash160 []byte, prefix []byte) string {
     address := base58.Encode(hash160_prepared)
     return address
 }
+
+func AddressToHash160(address string) ([]byte, []byte, error) { // returns (prefix, hash160)
+    //
+    //                               address
+    //                                  | base58 decode
+    //    [00] [203 194 152 111 249 174 214 130 89 32 174 206 20 170 111 83 130 202 85 128] [56 132 221 179]
+    //    /                                  \                                                          \
+    // prefix                              hash160                                                   checksum
+
+    decoded, err := base58.Decode(address)
+    if err != nil {
+        return nil, nil, err
+    }
+    if len(decoded) != 25 { // 1 byte prefix + 20 byte hash160 + 4 byte checksum
+        return nil, nil, fmt.Errorf("invalid address length: %s", address)
+    }
+    payload := decoded[:21]
+    if !bytes.Equal(crypto.Checksum(payload), decoded[21:]) {
+        return nil, nil, fmt.Errorf("invalid address checksum: %s", address)
+    }
+    return payload[:1], payload[1:], nil
+}
+
+// secp256k1: y^2 = x^3 + 7 (mod p)
+var secp256k1P, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+
+func DecompressPublicKey(compressed []byte, yOdd bool) []byte { // returns the 65 byte uncompressed public key (04 + x + y), or nil if x isn't on the curve
+    //
+    //    02 79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798                                                                   (compressed)
+    //    <> <-------------------------------x----------------------------->
+    //    04 79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798 483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8 (uncompressed)
+    //    <> <-------------------------------x-----------------------------> <-------------------------------y----------------------------->
+    //
+    // With an odd y (03 prefix) you get the other root (p - y):
+    //
+    //    03 79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798
+    //    04 79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798 b7c52588d95c3b9aa25b0403f1eef75702e84bb7597aabe663b82f6f04ef2777
+    //
+    // The compressed key can be given with or without its 02/03 prefix, as yOdd says which of the two y values to use.
+
+    if len(compressed) == 33 {
+        compressed = compressed[1:]
+    }
+    if len(compressed) != 32 {
+        return nil
+    }
+    x := new(big.Int).SetBytes(compressed)
+    if x.Cmp(secp256k1P) >= 0 {
+        return nil
+    }
+
+    // y^2 = x^3 + 7
+    y2 := new(big.Int).Exp(x, big.NewInt(3), secp256k1P)
+    y2.Add(y2, big.NewInt(7))
+    y2.Mod(y2, secp256k1P)
+
+    // square root (p = 3 mod 4, so the root is y2^((p+1)/4))
+    exponent := new(big.Int).Add(secp256k1P, big.NewInt(1))
+    exponent.Rsh(exponent, 2)
+    y := new(big.Int).Exp(y2, exponent, secp256k1P)
+    if new(big.Int).Exp(y, big.NewInt(2), secp256k1P).Cmp(y2) != 0 {
+        return nil // no square root, so x isn't on the curve
+    }
+
+    // there are two roots (y and p-y), pick the one with the right parity
+    if y.Bit(0) == 1 != yOdd {
+        y.Sub(secp256k1P, y)
+    }
+
+    uncompressed := make([]byte, 65)
+    uncompressed[0] = 0x04
+    x.FillBytes(uncompressed[1:33])
+    y.FillBytes(uncompressed[33:65])
+    return uncompressed
+}
@@ -0,0 +1,40 @@
+package keys
+
+import "encoding/hex"
+import "strings"
+import "testing"
+
+func TestDecompressPublicKey(t *testing.T) {
+    tests := []struct {
+        name       string
+        compressed string
+        yOdd       bool
+        want       string // 04 + x + y (empty for nil)
+    }{
+        // the generator point (its y is even, so the 03 key is the other root)
+        {"G even", "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", false, "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"},
+        {"G odd", "0379be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", true, "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798b7c52588d95c3b9aa25b0403f1eef75702e84bb7597aabe663b82f6f04ef2777"},
+        {"G without the prefix", "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", false, "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"},
+
+        // 6G, which has an odd y
+        {"6G odd", "03fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556", true, "04fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556ae12777aacfbb620f3be96017f45c560de80f0f6518fe4a03c870c36b075f297"},
+        {"6G even", "02fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556", false, "04fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a146029755651ed8885530449df0c4169fe80ba3a9f217f0f09ae701b5fc378f3c84f8a0998"},
+
+        // not on the curve
+        {"x = 5", "02" + strings.Repeat("00", 31) + "05", false, ""},
+        {"x bigger than p", "02" + strings.Repeat("ff", 32), false, ""},
+        {"31 bytes", "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f817", false, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            compressed, err := hex.DecodeString(tt.compressed)
+            if err != nil {
+                t.Fatal(err)
+            }
+            got := hex.EncodeToString(DecompressPublicKey(compressed, tt.yOdd))
+            if got != tt.want {
+                t.Errorf("DecompressPublicKey(%s, %v) = %s, want %s", tt.compressed, tt.yOdd, got, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,65 @@
+package muhash
+
+import "golang.org/x/crypto/chacha20" // expand each hash to 3072 bits
+import "crypto/sha256"
+import "math/big"
+
+// MuHash3072
+// ----------
+// The rolling hash Bitcoin Core uses for the utxo set (gettxoutsetinfo hash_type=muhash). Every item gets turned in to a
+// 3072-bit number, and the hash of the set is all of those numbers multiplied together (mod a prime), so the order the
+// items get added in doesn't matter:
+//
+//   item -> sha256 -> chacha20 keystream (384 bytes) -> little-endian number -> multiply it in
+//
+// https://github.com/bitcoin/bitcoin/blob/master/src/crypto/muhash.cpp
+type MuHash struct {
+    numerator   *big.Int
+    denominator *big.Int // removed items get multiplied in here, and divided out at the end
+}
+
+// 2^3072 - 1103717 (the largest 3072-bit safe prime)
+var modulus = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 3072), big.NewInt(1103717))
+
+func New() *MuHash {
+    return &MuHash{numerator: big.NewInt(1), denominator: big.NewInt(1)}
+}
+
+// toNum3072 turns some data in to a 3072-bit number
+func toNum3072(data []byte) *big.Int {
+    hash := sha256.Sum256(data)
+    cipher, _ := chacha20.NewUnauthenticatedCipher(hash[:], make([]byte, chacha20.NonceSize)) // key = hash, nonce = 0
+    keystream := make([]byte, 384)
+    cipher.XORKeyStream(keystream, keystream) // xor with zeros = the keystream itself
+    return new(big.Int).SetBytes(reverse(keystream)) // little-endian
+}
+
+func (m *MuHash) Insert(data []byte) {
+    m.numerator.Mul(m.numerator, toNum3072(data))
+    m.numerator.Mod(m.numerator, modulus)
+}
+
+func (m *MuHash) Remove(data []byte) {
+    m.denominator.Mul(m.denominator, toNum3072(data))
+    m.denominator.Mod(m.denominator, modulus)
+}
+
+// Finalize returns the 32 byte hash (reverse it for the hex that bitcoin core shows)
+func (m *MuHash) Finalize() [32]byte {
+    inverse := new(big.Int).ModInverse(m.denominator, modulus)
+    result := new(big.Int).Mul(m.numerator, inverse)
+    result.Mod(result, modulus)
+
+    data := make([]byte, 384)
+    result.FillBytes(data)
+    return sha256.Sum256(reverse(data)) // little-endian
+}
+
+// reverse returns a reversed copy of a byte slice (big.Int works with big-endian bytes)
+func reverse(b []byte) []byte {
+    r := make([]byte, len(b))
+    for i := range b {
+        r[len(b)-1-i] = b[i]
+    }
+    return r
+}
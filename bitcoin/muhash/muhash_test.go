@@ -0,0 +1,46 @@
+package muhash
+
+import "encoding/hex"
+import "testing"
+
+// item is the 32 bytes {i, 0, 0, ...} that Bitcoin Core's tests use (FromInt in src/test/crypto_tests.cpp)
+func item(i byte) []byte {
+    data := make([]byte, 32)
+    data[0] = i
+    return data
+}
+
+// finalHex is the hash the way bitcoin core shows it (reversed)
+func finalHex(m *MuHash) string {
+    hash := m.Finalize()
+    return hex.EncodeToString(reverse(hash[:]))
+}
+
+// muhash_tests in src/test/crypto_tests.cpp (and test_muhash in test/functional/test_framework/crypto/muhash.py):
+//
+//   MuHash3072 acc = FromInt(0); acc *= FromInt(1); acc /= FromInt(2);
+func TestMuHashCoreVector(t *testing.T) {
+    m := New()
+    m.Insert(item(0))
+    m.Insert(item(1))
+    m.Remove(item(2))
+    if got, want := finalHex(m), "10d312b100cbd32ada024a6646e40d3482fcff103668d2625f10002a607d5863"; got != want {
+        t.Errorf("MuHash = %s, want %s", got, want)
+    }
+}
+
+// the order the items go in doesn't matter, and removing an item takes it back out again
+func TestMuHashOrder(t *testing.T) {
+    a := New()
+    for _, i := range []byte{1, 2, 3} {
+        a.Insert(item(i))
+    }
+    b := New()
+    for _, i := range []byte{3, 4, 1, 2} {
+        b.Insert(item(i))
+    }
+    b.Remove(item(4))
+    if finalHex(a) != finalHex(b) {
+        t.Errorf("MuHash depends on the order: %s != %s", finalHex(a), finalHex(b))
+    }
+}
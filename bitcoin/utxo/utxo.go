@@ -0,0 +1,222 @@
+package utxo
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript"
+import "github.com/syndtr/goleveldb/leveldb"
+import "github.com/syndtr/goleveldb/leveldb/iterator"
+import "github.com/syndtr/goleveldb/leveldb/opt"
+import "github.com/syndtr/goleveldb/leveldb/util"
+
+import "context" // stopping the stream early
+import "fmt"
+
+// Streaming UTXOs
+// ---------------
+// For Go programs that want to read the utxo set without going through a csv file. StreamUTXOs decodes each utxo in the
+// chainstate and sends it down a channel, so the utxos can be used as they come (the channel is unbuffered by default,
+// so the decoding only keeps up with however fast they're being used):
+//
+//   db, err := leveldb.OpenFile("/home/user/.bitcoin/chainstate", &opt.Options{ReadOnly: true})
+//   if err != nil {
+//       log.Fatal(err)
+//   }
+//   defer db.Close()
+//
+//   ctx, cancel := context.WithCancel(context.Background())
+//   defer cancel() // (stops the stream if we return early)
+//
+//   utxos, errs := utxo.StreamUTXOs(ctx, db, utxo.Options{})
+//   total := int64(0)
+//   for u := range utxos {
+//       total += u.Amount
+//   }
+//   if err := <-errs; err != nil {
+//       log.Fatal(err)
+//   }
+//   fmt.Println(total, "satoshis")
+//
+// The command line tool splits up each entry with DecodeKey and DecodeValue, the same as Decode does. It doesn't go
+// through StreamUTXOs, as it only works out the fields that have been asked for with -f (the addresses, the full
+// scripts, and so on), which is quicker than doing everything for every utxo.
+
+// ChainstateDB is what the utxos get read from. A *leveldb.DB (or a *leveldb.Snapshot) already is one.
+type ChainstateDB interface {
+    Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+    NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// UTXO is one decoded entry from the chainstate.
+type UTXO struct {
+    TxID         [32]byte // in the order it's usually shown in (reversed from how it's stored)
+    Vout         int
+    Height       int
+    Coinbase     bool
+    Amount       int64  // satoshis
+    NSize        int    // how the script was compressed in the chainstate (0 = p2pkh, 1 = p2sh, 2-5 = p2pk, 6+ = full script)
+    Script       []byte // the script as it's stored (the hash160, the public key, or the full script)
+    ScriptPubKey []byte // the full script, or nil if it can't be rebuilt (e.g. a public key that isn't on the curve)
+    Type         string // p2pk, p2pkh, p2sh, p2ms, p2wpkh, p2wsh, p2tr, witness_v2 to witness_v16, or non-standard
+}
+
+// Options for StreamUTXOs.
+type Options struct {
+    Buffer int  // size of the channel (0 = unbuffered)
+    Strict bool // stop with an error at a malformed entry, instead of skipping it
+}
+
+// the obfuscate key is stored under 0e + 00 + "obfuscate_key"
+var obfuscateKeyKey = append([]byte{0x0e, 0x00}, []byte("obfuscate_key")...)
+
+// StreamUTXOs sends each utxo in the chainstate down the first channel, in the order they're stored in (by txid). When
+// it's finished (or the ctx has been cancelled, or there's been an error) both channels get closed, and the error (if
+// there is one) is sent on the second channel first. So read all of the utxos, then read the error.
+func StreamUTXOs(ctx context.Context, db ChainstateDB, opts Options) (<-chan UTXO, <-chan error) {
+    utxos := make(chan UTXO, opts.Buffer)
+    errs := make(chan error, 1) // (buffered, so sending the error never waits for anyone to read it)
+
+    go func() {
+        defer close(errs)
+        defer close(utxos)
+        if err := stream(ctx, db, opts, utxos); err != nil {
+            errs <- err
+        }
+    }()
+    return utxos, errs
+}
+
+func stream(ctx context.Context, db ChainstateDB, opts Options, utxos chan<- UTXO) error {
+    // Chainstates from before bitcoin 0.12 don't have an obfuscate key, so the values are read as they are
+    var obfuscateKey []byte
+    value, err := db.Get(obfuscateKeyKey, nil)
+    if err == nil {
+        obfuscateKey, err = btcleveldb.ObfuscateKey(value)
+    }
+    if err != nil && err != leveldb.ErrNotFound {
+        return fmt.Errorf("couldn't get obfuscate key: %v", err)
+    }
+
+    iter := db.NewIterator(util.BytesPrefix([]byte{0x43}), nil) // only the utxos (43 = C)
+    defer iter.Release()
+    for iter.Next() {
+        u, err := Decode(iter.Key(), iter.Value(), obfuscateKey)
+        if err != nil {
+            if opts.Strict {
+                return err
+            }
+            continue
+        }
+        select {
+        case utxos <- u:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+    return iter.Error()
+}
+
+// Decode decodes a utxo from its key and (obfuscated) value in the chainstate. Nothing in the result points in to the
+// key or value, so it's fine to reuse them afterwards (leveldb iterators do).
+func Decode(key []byte, value []byte, obfuscateKey []byte) (UTXO, error) {
+    var u UTXO
+    var err error
+    u.TxID, u.Vout, err = DecodeKey(key)
+    if err != nil {
+        return u, err
+    }
+
+    v, err := DecodeValue(btcleveldb.Deobfuscate(value, obfuscateKey))
+    if err != nil {
+        return u, fmt.Errorf("%x: %v", key, err)
+    }
+    u.Height = v.HeightCode >> 1
+    u.Coinbase = v.HeightCode&1 == 1
+    u.Amount = int64(btcleveldb.DecompressValue(v.CompressedAmount))
+    u.NSize = v.NSize
+    u.Script = append([]byte{}, v.Script...) // (copy, Deobfuscate doesn't copy if there's no key)
+
+    // the type comes from the nsize for the compressed scripts (like the command line tool), and from the templates for the rest
+    u.ScriptPubKey, _ = btcleveldb.DecompressScript(u.NSize, u.Script)
+    switch {
+    case u.NSize == 0:
+        u.Type = "p2pkh"
+    case u.NSize == 1:
+        u.Type = "p2sh"
+    case u.NSize < 6:
+        u.Type = "p2pk"
+    default:
+        u.Type = btcscript.Type(u.ScriptPubKey)
+    }
+    return u, nil
+}
+
+// DecodeKey gets the txid (in the order it's usually shown in) and the vout from a utxo's key:
+//
+//   43 0000155b9869d56c66d9e86e3c01de38e3892a42b99949fe109ac034fff65839 00
+//   <> <--------------------------------------------------------------> <>
+//   C                     txid (little-endian)                          vout (varint)
+func DecodeKey(key []byte) (txid [32]byte, vout int, err error) {
+    if len(key) < 34 || key[0] != 0x43 {
+        return txid, 0, fmt.Errorf("%x isn't a utxo key", key)
+    }
+    for i := 0; i < 32; i++ {
+        txid[i] = key[32-i]
+    }
+    return txid, btcleveldb.Varint128Decode(key[33:]), nil
+}
+
+// Value is a utxo's value (after it's been deobfuscated) split up in to its parts:
+//
+//   c08426 80ed59 00    a38f35518de4487c108e3810e6794fb68b189d8b
+//   <----> <----> <>    <-------------------------------------->
+//   height amount nsize script (the hash160 for nsize 0)
+//   code   (compressed)
+type Value struct {
+    HeightCode       int    // height << 1 | coinbase
+    CompressedAmount int    // btcleveldb.DecompressValue gets the satoshis
+    NSize            int    // 0 = p2pkh, 1 = p2sh, 2-5 = p2pk, 6+ = full script (6 + length)
+    Script           []byte // the script as it's stored (for nsize 2-5 the nsize is the first byte of the public key). Not a copy.
+    Ends             [3]int // where the height code, the amount, and the nsize end in the value (0 if it didn't get that far)
+}
+
+// DecodeValue splits up a deobfuscated value. It's an error if a varint runs off the end of the value, or the script isn't
+// as long as the nsize says it should be (a value that's been cut short would give the wrong script). The parts that got
+// decoded before the error are still set.
+func DecodeValue(xor []byte) (Value, error) {
+    var v Value
+
+    code, n, err := btcleveldb.Varint128Read(xor, 0)
+    if err != nil {
+        return v, fmt.Errorf("height: %v", err)
+    }
+    v.HeightCode = btcleveldb.Varint128Decode(code)
+    v.Ends[0] = n
+
+    amount, n, err := btcleveldb.Varint128Read(xor, v.Ends[0])
+    if err != nil {
+        return v, fmt.Errorf("amount: %v", err)
+    }
+    v.CompressedAmount = btcleveldb.Varint128Decode(amount)
+    v.Ends[1] = v.Ends[0] + n
+
+    nsize, n, err := btcleveldb.Varint128Read(xor, v.Ends[1])
+    if err != nil {
+        return v, fmt.Errorf("nsize: %v", err)
+    }
+    v.NSize = btcleveldb.Varint128Decode(nsize)
+    v.Ends[2] = v.Ends[1] + n
+
+    offset := v.Ends[2]
+    want := v.NSize - 6
+    switch {
+    case v.NSize < 2:
+        want = 20
+    case v.NSize < 6:
+        offset-- // the nsize is the first byte of the public key
+        want = 33
+    }
+    if len(xor)-offset != want {
+        return v, fmt.Errorf("script is %d bytes but the nsize %d says it should be %d", len(xor)-offset, v.NSize, want)
+    }
+    v.Script = xor[offset:]
+    return v, nil
+}
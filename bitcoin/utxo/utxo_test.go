@@ -0,0 +1,253 @@
+package utxo
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/syndtr/goleveldb/leveldb"
+import "github.com/syndtr/goleveldb/leveldb/opt"
+
+import "context"
+import "encoding/hex"
+import "fmt"
+import "log"
+import "os"
+import "testing"
+
+// A few utxos to put in the test chainstate (the values before they're obfuscated)
+var testEntries = []struct {
+    key   string // 43 + txid (little-endian) + vout (varint)
+    value string // varint(height << 1 | coinbase) + varint(compressed amount) + varint(nsize) + script
+}{
+    // height 532819, not coinbase, 339500 satoshis, p2pkh (the example in utxodump.go)
+    {"430000155b9869d56c66d9e86e3c01de38e3892a42b99949fe109ac034fff6583900", "c0842680ed5900a38f35518de4487c108e3810e6794fb68b189d8b"},
+    // height 1, coinbase, 5000000000 satoshis, p2pk (compressed public key, the nsize is its first byte)
+    {"431111111111111111111111111111111111111111111111111111111111111111" + "01", "033202" + "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+    // height 2, not coinbase, 0 satoshis, full script (nsize 9 = 3 bytes) OP_RETURN 01
+    {"432222222222222222222222222222222222222222222222222222222222222222" + "00", "040009" + "6a0101"},
+}
+
+// and one that's been cut short in the middle of the amount
+const testMalformedKey = "433333333333333333333333333333333333333333333333333333333333333333" + "00"
+const testMalformedValue = "0482"
+
+// newTestChainstate writes the test entries to a new leveldb in a temp dir, obfuscated with the key (if there is one)
+func newTestChainstate(t *testing.T, obfuscateKey []byte, malformed bool) *leveldb.DB {
+    t.Helper()
+    db, err := leveldb.OpenFile(t.TempDir(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    put := func(key string, value string) {
+        k, _ := hex.DecodeString(key)
+        v, _ := hex.DecodeString(value)
+        if err := db.Put(k, btcleveldb.Deobfuscate(v, obfuscateKey), nil); err != nil { // (xor goes both ways)
+            t.Fatal(err)
+        }
+    }
+    if obfuscateKey != nil {
+        if err := db.Put(obfuscateKeyKey, append([]byte{byte(len(obfuscateKey))}, obfuscateKey...), nil); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, e := range testEntries {
+        put(e.key, e.value)
+    }
+    if malformed {
+        put(testMalformedKey, testMalformedValue)
+    }
+    return db
+}
+
+// exampleChainstate writes the test entries (not obfuscated) to a leveldb in a temp dir for the example, and returns the
+// path to it and a func to remove it
+func exampleChainstate() (string, func()) {
+    path, err := os.MkdirTemp("", "chainstate")
+    if err != nil {
+        log.Fatal(err)
+    }
+    db, err := leveldb.OpenFile(path, nil)
+    if err != nil {
+        log.Fatal(err)
+    }
+    for _, e := range testEntries {
+        k, _ := hex.DecodeString(e.key)
+        v, _ := hex.DecodeString(e.value)
+        if err := db.Put(k, v, nil); err != nil {
+            log.Fatal(err)
+        }
+    }
+    db.Close()
+    return path, func() { os.RemoveAll(path) }
+}
+
+func ExampleStreamUTXOs() {
+    path, cleanup := exampleChainstate() // (a chainstate with three utxos in it, instead of ~/.bitcoin/chainstate)
+    defer cleanup()
+
+    db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true})
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer db.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel() // (stops the stream if we return early)
+
+    utxos, errs := StreamUTXOs(ctx, db, Options{})
+    total := int64(0)
+    for u := range utxos {
+        fmt.Printf("%x:%d %d %s\n", u.TxID, u.Vout, u.Amount, u.Type)
+        total += u.Amount
+    }
+    if err := <-errs; err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(total, "satoshis")
+
+    // Output:
+    // 3958f6ff34c09a10fe4999b9422a89e338de013c6ee8d9666cd569985b150000:0 339500 p2pkh
+    // 1111111111111111111111111111111111111111111111111111111111111111:1 5000000000 p2pk
+    // 2222222222222222222222222222222222222222222222222222222222222222:0 0 non-standard
+    // 5000339500 satoshis
+}
+
+// collect reads the whole stream
+func collect(t *testing.T, db ChainstateDB, opts Options) ([]UTXO, error) {
+    t.Helper()
+    utxos, errs := StreamUTXOs(context.Background(), db, opts)
+    var all []UTXO
+    for u := range utxos {
+        all = append(all, u)
+    }
+    return all, <-errs
+}
+
+func TestStreamUTXOs(t *testing.T) {
+    obfuscateKey, _ := hex.DecodeString("b12dcefd8f872536")
+    for _, tt := range []struct {
+        name         string
+        obfuscateKey []byte
+    }{
+        {"plain", nil},
+        {"obfuscated", obfuscateKey},
+    } {
+        t.Run(tt.name, func(t *testing.T) {
+            db := newTestChainstate(t, tt.obfuscateKey, false)
+            utxos, err := collect(t, db, Options{})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(utxos) != 3 {
+                t.Fatalf("got %d utxos, want 3", len(utxos))
+            }
+
+            // in key order (by txid as it's stored)
+            p2pkh, p2pk, opReturn := utxos[0], utxos[1], utxos[2]
+
+            if txid := hex.EncodeToString(p2pkh.TxID[:]); txid != "3958f6ff34c09a10fe4999b9422a89e338de013c6ee8d9666cd569985b150000" {
+                t.Errorf("txid = %s", txid)
+            }
+            if p2pkh.Vout != 0 || p2pkh.Height != 532819 || p2pkh.Coinbase || p2pkh.Amount != 339500 || p2pkh.NSize != 0 || p2pkh.Type != "p2pkh" {
+                t.Errorf("p2pkh = %+v", p2pkh)
+            }
+            if script := hex.EncodeToString(p2pkh.ScriptPubKey); script != "76a914a38f35518de4487c108e3810e6794fb68b189d8b88ac" {
+                t.Errorf("p2pkh scriptPubKey = %s", script)
+            }
+
+            if p2pk.Vout != 1 || p2pk.Height != 1 || !p2pk.Coinbase || p2pk.Amount != 5000000000 || p2pk.NSize != 2 || p2pk.Type != "p2pk" {
+                t.Errorf("p2pk = %+v", p2pk)
+            }
+            if script := hex.EncodeToString(p2pk.ScriptPubKey); script != "210279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798ac" {
+                t.Errorf("p2pk scriptPubKey = %s", script)
+            }
+
+            if opReturn.Height != 2 || opReturn.Amount != 0 || opReturn.NSize != 9 || opReturn.Type != "non-standard" || hex.EncodeToString(opReturn.Script) != "6a0101" {
+                t.Errorf("op_return = %+v", opReturn)
+            }
+        })
+    }
+}
+
+func TestStreamUTXOsMalformed(t *testing.T) {
+    db := newTestChainstate(t, nil, true)
+
+    // skipped
+    utxos, err := collect(t, db, Options{})
+    if err != nil || len(utxos) != 3 {
+        t.Errorf("got %d utxos and %v, want the 3 good ones and no error", len(utxos), err)
+    }
+
+    // stops the stream with -strict
+    if _, err := collect(t, db, Options{Strict: true}); err == nil {
+        t.Error("Strict didn't stop at the malformed entry")
+    }
+}
+
+func TestStreamUTXOsCancel(t *testing.T) {
+    db := newTestChainstate(t, nil, false)
+    ctx, cancel := context.WithCancel(context.Background())
+    utxos, errs := StreamUTXOs(ctx, db, Options{})
+    <-utxos // take the first one, then stop
+    cancel()
+    for range utxos { // (one more might already be on its way)
+    }
+    if err := <-errs; err != context.Canceled {
+        t.Errorf("error = %v, want %v", err, context.Canceled)
+    }
+}
+
+func TestDecodeP2PKFullScript(t *testing.T) {
+    // p2pk scripts that got stored in full (nsize 6 + script length) instead of as a compressed public key, e.g. for an
+    // uncompressed key that isn't on the curve. They're counted as p2pk the same as the ones with nsize 2-5.
+    key, _ := hex.DecodeString("431111111111111111111111111111111111111111111111111111111111111111" + "00")
+    tests := []struct {
+        name   string
+        nsize  string // varint
+        script string
+    }{
+        {"compressed", "29", "2102b4632d08485ff1df2db55b9dafd23347d1c47a457072a1e87be26896549a8737ac"},
+        {"uncompressed", "49", "4104ae1a62fe09c5f51b13905f07f06b99a2f7159b2225f374cd378d71302fa28414e7aab37397f554a7df5f142c21c1b7303b8a0626f1baded5c72a704f7e6cd84cac"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            value, _ := hex.DecodeString("0200" + tt.nsize + tt.script) // height 1, not coinbase, 0 satoshis
+            u, err := Decode(key, value, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if u.Type != "p2pk" {
+                t.Errorf("type = %s, want p2pk", u.Type)
+            }
+            if script := hex.EncodeToString(u.ScriptPubKey); script != tt.script {
+                t.Errorf("scriptPubKey = %s, want %s", script, tt.script)
+            }
+        })
+    }
+}
+
+func TestDecodeValue(t *testing.T) {
+    tests := []struct {
+        name  string
+        value string
+        ends  [3]int // how far it got
+        err   bool
+    }{
+        {"p2pkh", "c0842680ed5900a38f35518de4487c108e3810e6794fb68b189d8b", [3]int{3, 6, 7}, false},
+        {"p2pk (the nsize is part of the key)", "033202" + "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", [3]int{1, 2, 3}, false},
+        {"amount cut short", "0482", [3]int{1, 0, 0}, true},
+        {"hash160 cut short", "c0842680ed5900a38f35518de4487c108e3810e6794fb68b189d", [3]int{3, 6, 7}, true},
+        {"full script longer than the nsize", "040009" + "6a010101", [3]int{1, 2, 3}, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            xor, _ := hex.DecodeString(tt.value)
+            v, err := DecodeValue(xor)
+            if (err != nil) != tt.err {
+                t.Fatalf("DecodeValue(%s) error = %v, want an error: %v", tt.value, err, tt.err)
+            }
+            if v.Ends != tt.ends {
+                t.Errorf("DecodeValue(%s) ends = %v, want %v", tt.value, v.Ends, tt.ends)
+            }
+        })
+    }
+}
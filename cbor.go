@@ -0,0 +1,89 @@
+package main
+
+import "bufio"
+import "strconv" // parse integer fields from the output map
+
+// CBOR Sequence
+// -------------
+// Writes each utxo as a CBOR map (https://www.rfc-editor.org/rfc/rfc8949) keyed by field name, one straight after the
+// other with nothing in between. This is a CBOR Sequence (https://www.rfc-editor.org/rfc/rfc8742), so there's no
+// length prefix or array around the whole thing. Just keep decoding items until the end of the file, e.g. in Python:
+//
+//   import cbor2
+//   with open("utxodump.cbor", "rb") as f:
+//       decoder = cbor2.CBORDecoder(f)
+//       while f.peek(1):
+//           print(decoder.decode())
+//
+// Integer fields (see fieldTypes) are CBOR integers (or null if they're empty), and everything else is a text string.
+type cborWriter struct {
+    w      *bufio.Writer
+    fields []string
+    keys   [][]byte // field names already encoded (they're the same for every row)
+    buf    []byte   // reused for each row
+}
+
+// CBOR major types (the top 3 bits of the first byte)
+const (
+    cborUnsigned = 0 << 5
+    cborNegative = 1 << 5
+    cborText     = 3 << 5
+    cborMap      = 5 << 5
+    cborNull     = 0xf6
+)
+
+func (c *cborWriter) Header(fields []string) error {
+    c.fields = fields
+    c.keys = make([][]byte, len(fields))
+    for i, name := range fields {
+        c.keys[i] = cborAppendText(nil, name)
+    }
+    return nil // no header, every map has its own keys
+}
+
+func (c *cborWriter) Row(output map[string]string) error {
+    buf := cborAppendHead(c.buf[:0], cborMap, uint64(len(c.fields)))
+    for i, name := range c.fields {
+        buf = append(buf, c.keys[i]...)
+        if fieldTypes[name] == "int" {
+            n, err := strconv.ParseInt(output[name], 10, 64)
+            switch {
+            case err != nil: // empty
+                buf = append(buf, cborNull)
+            case n < 0:
+                buf = cborAppendHead(buf, cborNegative, uint64(-1-n)) // -1 is stored as 0
+            default:
+                buf = cborAppendHead(buf, cborUnsigned, uint64(n))
+            }
+        } else {
+            buf = cborAppendText(buf, output[name])
+        }
+    }
+    c.buf = buf
+    _, err := c.w.Write(buf)
+    return err
+}
+
+func (c *cborWriter) Close() error {
+    return nil
+}
+
+// cborAppendHead adds the first byte of an item (major type + the number, or how many bytes the number takes up)
+func cborAppendHead(buf []byte, major byte, n uint64) []byte {
+    switch {
+    case n < 24:
+        return append(buf, major|byte(n))
+    case n <= 0xff:
+        return append(buf, major|24, byte(n))
+    case n <= 0xffff:
+        return append(buf, major|25, byte(n>>8), byte(n))
+    case n <= 0xffffffff:
+        return append(buf, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+    }
+    return append(buf, major|27, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32), byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func cborAppendText(buf []byte, s string) []byte {
+    buf = cborAppendHead(buf, cborText, uint64(len(s)))
+    return append(buf, s...)
+}
@@ -0,0 +1,49 @@
+package main
+
+import "crypto/sha256"
+import "encoding/hex"
+
+// Chain Hash (-chain-hash)
+// ----------
+// Each row gets a row_hash that chains it to the row before it, so if any row in the file gets added, removed, or changed,
+// every row_hash after it (and the final chain hash) changes too:
+//
+//   row_hash = sha256(previous row_hash + the other fields in this row as a csv line)
+//
+// The first row has no previous row_hash, so it's just the sha256 of its csv line. This wraps the -format writer (after any
+// -sort), so the hashes follow the order the rows are actually written to the file.
+type chainHashWriter struct {
+    out    rowWriter
+    fields []string // the fields that get hashed (everything apart from row_hash)
+    last   []byte   // previous row_hash
+}
+
+func (c *chainHashWriter) Header(fields []string) error {
+    for _, v := range fields {
+        if v != "row_hash" {
+            c.fields = append(c.fields, v)
+        }
+    }
+    return c.out.Header(fields)
+}
+
+func (c *chainHashWriter) Row(output map[string]string) error {
+    hash := sha256.New()
+    hash.Write(c.last)
+    hash.Write([]byte(csvLine(output, c.fields)))
+    c.last = hash.Sum(nil)
+
+    output["row_hash"] = hex.EncodeToString(c.last)
+    err := c.out.Row(output)
+    delete(output, "row_hash") // the output map gets reused for the next utxo
+    return err
+}
+
+func (c *chainHashWriter) Close() error {
+    return c.out.Close()
+}
+
+// final returns the row_hash of the last row, which covers the whole file
+func (c *chainHashWriter) final() string {
+    return hex.EncodeToString(c.last)
+}
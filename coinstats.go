@@ -0,0 +1,35 @@
+package main
+
+import "encoding/binary"
+
+// UTXO Set Hash (-muhash)
+// -----------------
+// serializeCoin writes a utxo the same way Bitcoin Core does before adding it to the muhash (TxOutSer in kernel/coinstats.cpp):
+//
+//   outpoint     txid (32 bytes, in the same little-endian order as the chainstate key) + vout (uint32 little-endian)
+//   height code  height*2 + coinbase (uint32 little-endian)
+//   txout        amount (int64 little-endian) + script length (compact size) + script
+//
+// Note that this uses the full amount and script, not the compressed versions stored in the chainstate.
+func serializeCoin(txid []byte, vout int, height int, coinbase int, amount int, script []byte) []byte {
+    data := make([]byte, 0, 32+4+4+8+9+len(script))
+    data = append(data, txid...)
+    data = binary.LittleEndian.AppendUint32(data, uint32(vout))
+    data = binary.LittleEndian.AppendUint32(data, uint32(height<<1 + coinbase))
+    data = binary.LittleEndian.AppendUint64(data, uint64(amount))
+    data = appendCompactSize(data, len(script))
+    return append(data, script...)
+}
+
+// appendCompactSize writes a length in bitcoin's variable length format
+func appendCompactSize(data []byte, n int) []byte {
+    switch {
+    case n < 0xfd:
+        return append(data, byte(n))
+    case n <= 0xffff:
+        return binary.LittleEndian.AppendUint16(append(data, 0xfd), uint16(n))
+    case n <= 0xffffffff:
+        return binary.LittleEndian.AppendUint32(append(data, 0xfe), uint32(n))
+    }
+    return binary.LittleEndian.AppendUint64(append(data, 0xff), uint64(n))
+}
@@ -0,0 +1,38 @@
+package main
+
+import "encoding/hex"
+import "testing"
+
+func TestSerializeCoin(t *testing.T) {
+    // genesis block coinbase output (txid 4a5e1e4b...da33b, which is stored the other way round in the chainstate key)
+    txid, _ := hex.DecodeString("3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a")
+    script, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+
+    want := "3ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a" + // txid
+        "00000000" +         // vout 0
+        "01000000" +         // height 0 * 2 + coinbase 1
+        "00f2052a01000000" + // 5000000000 satoshis
+        "43" + hex.EncodeToString(script) // script length (67) + script
+    if got := hex.EncodeToString(serializeCoin(txid, 0, 0, 1, 5000000000, script)); got != want {
+        t.Errorf("serializeCoin = %s, want %s", got, want)
+    }
+}
+
+func TestAppendCompactSize(t *testing.T) {
+    tests := []struct {
+        n    int
+        want string
+    }{
+        {0, "00"},
+        {252, "fc"},
+        {253, "fdfd00"},
+        {0xffff, "fdffff"},
+        {0x10000, "fe00000100"},
+        {0x100000000, "ff0000000001000000"},
+    }
+    for _, tt := range tests {
+        if got := hex.EncodeToString(appendCompactSize(nil, tt.n)); got != tt.want {
+            t.Errorf("appendCompactSize(%d) = %s, want %s", tt.n, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,16 @@
+package main
+
+import "encoding/hex"
+
+// Core Serialization (the core_serialization field)
+// -------------------------------------------------
+// The value for the utxo as bitcoin core serializes the Coin (SerializeHash/Unserialize in coins.h), before it gets
+// obfuscated and written to the chainstate, e.g. the one in the diagram in utxodump.go:
+//
+//   c08426 80ed59 00 a38f35518de4487c108e3810e6794fb68b189d8b
+//   height 532819 (not coinbase), amount 339500, nsize 0 (p2pkh), hash160
+//
+// So it's just the deobfuscated value, and reading it back with the obfuscation key left out gives the same utxo.
+func coreSerialization(xor []byte) string {
+    return hex.EncodeToString(xor)
+}
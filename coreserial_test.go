@@ -0,0 +1,38 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/utxo"
+
+import "encoding/hex"
+import "testing"
+
+func TestCoreSerialization(t *testing.T) {
+    // the entry in the diagram in utxodump.go, as it is on disk (obfuscated with b12dcefd8f872536)
+    key, _ := hex.DecodeString("430000155b9869d56c66d9e86e3c01de38e3892a42b99949fe109ac034fff6583900")
+    value, _ := hex.DecodeString("71a9e87d62de25953e189f706bcf59263f15de1bf6c893bda9b045")
+    obfuscateKey, _ := hex.DecodeString("b12dcefd8f872536")
+
+    // the Coin: height 532819 (not coinbase), amount 339500, nsize 0 (p2pkh), hash160
+    want := "c08426" + "80ed59" + "00" + "a38f35518de4487c108e3810e6794fb68b189d8b"
+    field := coreSerialization(btcleveldb.Deobfuscate(value, obfuscateKey))
+    if field != want {
+        t.Fatalf("core_serialization = %s, want %s", field, want)
+    }
+
+    // reading the field back (with no obfuscation) gives the same utxo as reading the entry on disk
+    coin, _ := hex.DecodeString(field)
+    fromField, err := utxo.Decode(key, coin, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fromDisk, err := utxo.Decode(key, value, obfuscateKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fromField.Height != 532819 || fromField.Coinbase || fromField.Amount != 339500 || fromField.Type != "p2pkh" {
+        t.Errorf("core_serialization decodes to %+v", fromField)
+    }
+    if hex.EncodeToString(fromField.ScriptPubKey) != hex.EncodeToString(fromDisk.ScriptPubKey) || fromField.Height != fromDisk.Height || fromField.Amount != fromDisk.Amount {
+        t.Errorf("core_serialization decodes to %+v, the entry on disk to %+v", fromField, fromDisk)
+    }
+}
@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+import "os"
+import "path/filepath"
+
+// Data Directory (-datadir)
+// --------------
+// Bitcoin Core keeps the chainstate for each network in its own folder inside the data directory:
+//
+//   mainnet  <datadir>/chainstate
+//   testnet3 <datadir>/testnet3/chainstate
+//   testnet4 <datadir>/testnet4/chainstate
+//
+// so -datadir saves having to remember the full path to the chainstate folder. With just -testnet it uses whichever of
+// the testnet3 or testnet4 folders it finds first (use -network to pick one).
+func chainstateFromDatadir(datadir string, name string) (string, error) {
+    candidates := []string{}
+    for _, dir := range networks[name].dirs {
+        candidates = append(candidates, filepath.Join(datadir, filepath.FromSlash(dir)))
+    }
+
+    for _, path := range candidates {
+        if info, err := os.Stat(path); err == nil && info.IsDir() {
+            return path, nil
+        }
+    }
+
+    return "", fmt.Errorf("couldn't find a %s chainstate in %s (looked for %s)", name, datadir, candidates)
+}
@@ -0,0 +1,152 @@
+package main
+
+import "fmt"
+import "hash/maphash" // fast hashing for the bloom filter
+import "math"
+
+// Distinct Counting (-count-addresses)
+// -----------------
+// Counting how many different addresses there are means remembering every address we've seen so far, which takes a lot of
+// memory for the whole utxo set (tens of millions of addresses). So there's a choice of how to remember them (-distinct-method):
+//
+//   exact  - a map of every address (exact count, but uses the most memory)
+//   bloom  - a scalable bloom filter (fixed memory per address, but a false positive means a new address looks like one we've
+//            already seen, so the count can come out a little low, by at most the -bloom-fp rate)
+type distinctSet interface {
+    add(item []byte) bool // returns true if the item hasn't been seen before
+    describe() string     // for the final report
+}
+
+func newDistinctSet(method string, fpRate float64) (distinctSet, error) {
+    switch method {
+    case "exact":
+        return &exactSet{items: map[string]struct{}{}}, nil
+    case "bloom":
+        if fpRate <= 0 || fpRate >= 1 {
+            return nil, fmt.Errorf("-bloom-fp needs to be between 0 and 1 (e.g. 0.001)")
+        }
+        return newScalableBloom(fpRate), nil
+    }
+    return nil, fmt.Errorf("'%s' is not a distinct method you can use. Choose from the following: exact,bloom", method)
+}
+
+// exact
+type exactSet struct {
+    items map[string]struct{}
+}
+
+func (e *exactSet) add(item []byte) bool {
+    if _, ok := e.items[string(item)]; ok {
+        return false
+    }
+    e.items[string(item)] = struct{}{}
+    return true
+}
+
+func (e *exactSet) describe() string {
+    return "exact"
+}
+
+// toBloom moves everything in the set in to a bloom filter, which uses a lot less memory (-max-memory)
+func (e *exactSet) toBloom(fpRate float64) (*scalableBloom, error) {
+    if fpRate <= 0 || fpRate >= 1 {
+        return nil, fmt.Errorf("-bloom-fp needs to be between 0 and 1 (e.g. 0.001)")
+    }
+    s := newScalableBloom(fpRate)
+    s.switched = len(e.items)
+    for item := range e.items {
+        s.add([]byte(item))
+        delete(e.items, item) // (as we go, so there aren't two copies of everything at once)
+    }
+    return s, nil
+}
+
+// bloom
+// A scalable bloom filter is a list of bloom filters. When the newest one gets full a bigger one gets added (with a lower
+// false positive rate), so we don't need to know how many items there are going to be up front, and the overall false
+// positive rate stays below the target (https://gsd.di.uminho.pt/members/cbm/ps/dbloom.pdf).
+const bloomInitialCapacity = 1 << 20 // items in the first filter
+const bloomGrowth = 2                // each filter can hold twice as many items as the last one
+const bloomTightening = 0.5          // and has half the false positive rate
+
+type scalableBloom struct {
+    fpRate   float64
+    filters  []*bloomFilter
+    seed1    maphash.Seed
+    seed2    maphash.Seed
+    switched int // items there were when it was switched from an exact set (-max-memory)
+}
+
+type bloomFilter struct {
+    bits     []uint64
+    m        uint64 // number of bits
+    k        uint64 // number of hashes
+    capacity int
+    items    int
+}
+
+func newScalableBloom(fpRate float64) *scalableBloom {
+    s := &scalableBloom{fpRate: fpRate, seed1: maphash.MakeSeed(), seed2: maphash.MakeSeed()}
+    s.grow()
+    return s
+}
+
+// grow adds a new filter (the rates of all the filters add up to less than fpRate: fpRate*(1-r) * (1 + r + r^2 + ...) = fpRate)
+func (s *scalableBloom) grow() {
+    n := len(s.filters)
+    capacity := bloomInitialCapacity * int(math.Pow(bloomGrowth, float64(n)))
+    p := s.fpRate * (1 - bloomTightening) * math.Pow(bloomTightening, float64(n))
+
+    m := uint64(math.Ceil(-float64(capacity) * math.Log(p) / (math.Ln2 * math.Ln2))) // bits needed for this false positive rate
+    k := uint64(math.Max(1, math.Round(float64(m)/float64(capacity)*math.Ln2)))      // best number of hashes for that many bits
+    s.filters = append(s.filters, &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k, capacity: capacity})
+}
+
+func (s *scalableBloom) add(item []byte) bool {
+    // two hashes, and then the k hashes for each filter are h1 + i*h2 (Kirsch-Mitzenmacher)
+    h1 := maphash.Bytes(s.seed1, item)
+    h2 := maphash.Bytes(s.seed2, item) | 1
+
+    for _, f := range s.filters {
+        if f.has(h1, h2) {
+            return false
+        }
+    }
+
+    f := s.filters[len(s.filters)-1]
+    if f.items >= f.capacity {
+        s.grow()
+        f = s.filters[len(s.filters)-1]
+    }
+    f.set(h1, h2)
+    f.items++
+    return true
+}
+
+func (s *scalableBloom) describe() string {
+    bytes := 0
+    for _, f := range s.filters {
+        bytes += len(f.bits) * 8
+    }
+    if s.switched > 0 {
+        return fmt.Sprintf("exact, then a bloom filter after %d items because of -max-memory, %g false positive rate, %d MB", s.switched, s.fpRate, bytes/1000000)
+    }
+    return fmt.Sprintf("bloom filter, %g false positive rate, %d MB", s.fpRate, bytes/1000000)
+}
+
+func (f *bloomFilter) has(h1, h2 uint64) bool {
+    for i := uint64(0); i < f.k; i++ {
+        bit := (h1 + i*h2) % f.m
+        if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+            return false
+        }
+    }
+    return true
+}
+
+func (f *bloomFilter) set(h1, h2 uint64) {
+    for i := uint64(0); i < f.k; i++ {
+        bit := (h1 + i*h2) % f.m
+        f.bits[bit/64] |= 1 << (bit % 64)
+    }
+}
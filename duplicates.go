@@ -0,0 +1,130 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+
+import "encoding/binary"
+import "encoding/hex"
+import "fmt"
+import "log/slog"
+
+// Duplicate Outpoints (-detect-duplicates)
+// ----------------------------------------
+// Every key in leveldb is different, so every outpoint (txid:vout) in the chainstate should be too. If the same outpoint
+// comes out twice, two different keys have been decoded to the same txid and vout, which means either a bug in the
+// decoding (e.g. a vout varint with something extra on the end) or a corrupted database. So this is a check, and it
+// should never find anything:
+//
+//   adjacent - compare each outpoint with the one before it. The keys are sorted, so the keys for the same txid are
+//              next to each other and this catches most problems without using any memory.
+//   full     - remember every outpoint so a duplicate is found wherever it is. This uses the -distinct-method: exact
+//              remembers the key each outpoint came from (lots of memory for the whole utxo set, but it can show both
+//              keys), and bloom uses much less memory but can only say it's a possible duplicate (it might be a false
+//              positive, at the -bloom-fp rate).
+//
+// Each duplicate is printed (and logged) with both of the keys it came from.
+type duplicateDetector struct {
+    full     bool
+    exact    map[string]string // outpoint = key it first came from (full, exact)
+    bloom    distinctSet       // (full, bloom)
+    last     []byte            // previous outpoint (adjacent)
+    lastKey  []byte
+    found    int
+    possible int // found in the bloom filter, but could be a false positive
+    logger   *slog.Logger
+}
+
+func newDuplicateDetector(mode string, method string, fpRate float64, logger *slog.Logger) (*duplicateDetector, error) {
+    switch mode {
+    case "adjacent":
+        return &duplicateDetector{logger: logger}, nil
+    case "full":
+        d := &duplicateDetector{full: true, logger: logger}
+        if method == "exact" {
+            d.exact = map[string]string{}
+            return d, nil
+        }
+        bloom, err := newDistinctSet(method, fpRate)
+        if err != nil {
+            return nil, err
+        }
+        d.bloom = bloom
+        return d, nil
+    }
+    return nil, fmt.Errorf("'%s' is not a -detect-duplicates mode you can use. Choose from the following: adjacent,full", mode)
+}
+
+// outpoint works out the outpoint for a key the same way the txid and vout fields do (txid + 8 byte vout, so a vout
+// that's come out too big isn't cut down to look like a different one)
+func outpoint(key []byte) []byte {
+    op := make([]byte, 40)
+    copy(op, key[1:33])
+    binary.LittleEndian.PutUint64(op[32:], uint64(btcleveldb.Varint128Decode(key[33:])))
+    return op
+}
+
+func (d *duplicateDetector) check(key []byte) {
+    op := outpoint(key)
+
+    switch {
+    case !d.full:
+        if d.last != nil && string(op) == string(d.last) {
+            d.report(op, d.lastKey, key, false)
+        }
+        d.last = op
+        d.lastKey = append(d.lastKey[:0], key...) // copy (the iterator reuses the key's memory)
+    case d.exact != nil:
+        if first, ok := d.exact[string(op)]; ok {
+            d.report(op, []byte(first), key, false)
+            return
+        }
+        d.exact[string(op)] = string(key)
+    default:
+        if !d.bloom.add(op) {
+            d.report(op, nil, key, true)
+        }
+    }
+}
+
+// toBloom moves the outpoints seen so far in to a bloom filter, which uses a lot less memory (-max-memory)
+func (d *duplicateDetector) toBloom(fpRate float64) error {
+    if d.exact == nil {
+        return nil
+    }
+    if fpRate <= 0 || fpRate >= 1 {
+        return fmt.Errorf("-bloom-fp needs to be between 0 and 1 (e.g. 0.001)")
+    }
+    bloom := newScalableBloom(fpRate)
+    bloom.switched = len(d.exact)
+    for op := range d.exact {
+        bloom.add([]byte(op))
+        delete(d.exact, op) // (as we go, so there aren't two copies of everything at once)
+    }
+    d.exact = nil
+    d.bloom = bloom
+    return nil
+}
+
+func (d *duplicateDetector) report(op []byte, first []byte, key []byte, possible bool) {
+    txid := make([]byte, 32)
+    for i := range txid { // (shown reversed)
+        txid[i] = op[31-i]
+    }
+    name := fmt.Sprintf("%x:%d", txid, int(binary.LittleEndian.Uint64(op[32:])))
+
+    if possible {
+        d.possible++
+        fmt.Printf("Possible duplicate outpoint %s from key %x (could be a bloom filter false positive)\n", name, key)
+        d.logger.Warn("possible duplicate outpoint", "outpoint", name, "key", hex.EncodeToString(key))
+        return
+    }
+    d.found++
+    fmt.Printf("Duplicate outpoint %s from keys %x and %x\n", name, first, key)
+    d.logger.Error("duplicate outpoint", "outpoint", name, "first_key", hex.EncodeToString(first), "key", hex.EncodeToString(key))
+}
+
+func (d *duplicateDetector) print(human humanFormat) {
+    fmt.Printf("Duplicate Outpoints: %s\n", human.count(d.found))
+    if d.possible > 0 {
+        fmt.Printf("Possible Duplicate Outpoints: %s (bloom filter, some could be false positives)\n", human.count(d.possible))
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript"
+
+import "fmt"
+
+// Dust Ratio (the dust_ratio field)
+// ---------------------------------
+// Bitcoin Core won't relay a transaction with an output worth less than the "dust threshold": what it would cost (at the
+// dust relay fee of 3 sat/vB) to create the output and then spend it again. This works it out the same way Core does
+// (GetDustThreshold in policy.cpp), from the size of the output and a typical input to spend it:
+//
+//   output = 8 amount + 1 script length + script
+//   input  = 32 txid + 4 vout + 1 scriptsig length + 107 scriptsig + 4 sequence = 148 (or 67 for a witness program,
+//            where the 107 bytes of signature and public key are in the witness and count as a quarter)
+//
+//   p2pkh   (34 + 148) * 3 = 546      p2wpkh  (31 + 67) * 3 = 294
+//   p2sh    (32 + 148) * 3 = 540      p2wsh   (43 + 67) * 3 = 330
+//   p2pk    (44 + 148) * 3 = 576      p2tr    (43 + 67) * 3 = 330
+//
+// dust_ratio is the amount divided by the threshold, so anything under 1 is dust.
+const dustRelayFee = 3000 // sat/kvB (-dustrelayfee in bitcoin core)
+
+// dustThreshold gets the threshold for a utxo from its nsize and script (as it's stored in the chainstate)
+func dustThreshold(nsize int, script []byte) int {
+    // length of the full script (without having to decompress it)
+    length := len(script)
+    witness := false
+    switch {
+    case nsize == 0:
+        length = 25 // OP_DUP OP_HASH160 <20> OP_EQUALVERIFY OP_CHECKSIG
+    case nsize == 1:
+        length = 23 // OP_HASH160 <20> OP_EQUAL
+    case nsize == 2 || nsize == 3:
+        length = 35 // <33> OP_CHECKSIG
+    case nsize == 4 || nsize == 5:
+        length = 67 // <65> OP_CHECKSIG
+    default:
+        witness = btcscript.WitnessProgram(script) != nil
+    }
+
+    size := 8 + compactSizeLength(length) + length
+    if witness {
+        size += 32 + 4 + 1 + 107/4 + 4
+    } else {
+        size += 32 + 4 + 1 + 107 + 4
+    }
+    return size * dustRelayFee / 1000
+}
+
+// compactSizeLength is how many bytes it takes to write n as a compact size (the length in front of a script)
+func compactSizeLength(n int) int {
+    switch {
+    case n < 253:
+        return 1
+    case n <= 0xffff:
+        return 3
+    case n <= 0xffffffff:
+        return 5
+    }
+    return 9
+}
+
+// dustRatio is the amount divided by the dust threshold (to 4 decimal places)
+func dustRatio(amount int, nsize int, script []byte) string {
+    return fmt.Sprintf("%.4f", float64(amount)/float64(dustThreshold(nsize, script)))
+}
@@ -0,0 +1,285 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/keys"   // base58 addresses
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/bech32" // segwit addresses
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/descriptor"
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript" // witness versions
+
+import "encoding/hex"
+import "strings"
+import "sync"
+
+// Addresses
+// ---------
+// encodeAddress gets the address for a script (once we know what type it is), using the prefixes for the network. Script
+// types without an address (p2pk, p2ms, non-standard) return an empty string.
+func encodeAddress(scriptType string, script []byte, params networkParams) string {
+    if strings.HasPrefix(scriptType, "witness_v") { // future witness versions (witness_v2 to witness_v16)
+        scriptType = "p2wsh" // (encoded the same way, the version comes from the script)
+    }
+
+    switch scriptType {
+    case "p2pkh":
+        return keys.Hash160ToAddress(script, []byte{params.p2pkh}) // 1address (or (m/n)address on testnet)
+    case "p2sh":
+        return keys.Hash160ToAddress(script, []byte{params.p2sh}) // 3address (or 2address on testnet)
+    case "p2wpkh", "p2wsh", "p2tr":
+        // script  = [0 20 112 13 22 53 196 57 157 53 6 28 29 171 204 70 50 195 15 237 173 214]
+        // version = [0]   (OP_0, or OP_1 to OP_16 for versions 1 to 16)
+        // program =      [112 13 22 53 196 57 157 53 6 28 29 171 204 70 50 195 15 237 173 214]
+        version := btcscript.WitnessVersion(script)
+        program := script[2:]
+
+        // bech32 function takes an int array and not a byte array, so convert the array to integers
+        programint := make([]int, len(program))
+        for i, v := range program {
+            programint[i] = int(v) // cast every value to an int
+        }
+
+        address, _ := bech32.SegwitAddrEncode(params.hrp, version, programint) // hrp (string), version (int), program ([]int), bech32m for version 1+ (e.g. bc1p for p2tr)
+        return address
+    }
+    return ""
+}
+
+// hasAddress tells us if encodeAddress can make an address for this script type
+func hasAddress(scriptType string) bool {
+    switch scriptType {
+    case "p2pkh", "p2sh", "p2wpkh", "p2wsh", "p2tr":
+        return true
+    }
+    return strings.HasPrefix(scriptType, "witness_v")
+}
+
+// encodeDescriptor gets the output descriptor for a script: pk() when we have the public key, raw() for a full script, and
+// addr() for everything else with an address.
+func encodeDescriptor(scriptType string, nsize int, script []byte, address string) string {
+    desc := ""
+    switch {
+    case scriptType == "p2pk" && (nsize == 2 || nsize == 3): // compressed public key (nsize is the first byte of it)
+        desc = "pk(" + hex.EncodeToString(script) + ")"
+    case scriptType == "p2pk" && (nsize == 4 || nsize == 5): // uncompressed public key, which the chainstate has compressed, so it needs its y back
+        if pubkey := uncompressedPublicKey(scriptType, nsize, script); pubkey != nil { // (nil if the x isn't on the curve)
+            desc = "pk(" + hex.EncodeToString(pubkey) + ")"
+        }
+    case scriptType == "p2pk" && nsize > 5 && (script[1] == 0x06 || script[1] == 0x07): // hybrid key, which bitcoin core won't have in a pk()
+        desc = "raw(" + hex.EncodeToString(script) + ")"
+    case scriptType == "p2pk" && nsize > 5: // full script, so the public key is between the push and the OP_CHECKSIG
+        desc = "pk(" + hex.EncodeToString(script[1:len(script)-1]) + ")"
+    case scriptType == "p2ms": // multi() would need every key to be a valid public key, which isn't always the case for bare multisig
+        desc = "raw(" + hex.EncodeToString(script) + ")"
+    case address != "":
+        desc = "addr(" + address + ")"
+    }
+    if desc == "" {
+        return ""
+    }
+    return descriptor.AddChecksum(desc)
+}
+
+// scriptField is the script as it's shown in the script field. That's the script as it's stored in the chainstate, apart
+// from the uncompressed P2PK keys (nsize 4 and 5), which the chainstate compresses to save space. Those get their y put
+// back, so you get the 65 byte public key that's in the script on-chain instead of one that never existed:
+//
+//   nsize 4: 0479be667e...16f81798 -> 0479be667e...16f81798483ada77...fb10d4b8
+//            <>                       <> <-------x--------> <-------y------->
+//            nsize (not 04 prefix)    04 prefix
+//
+// (the same as the other P2PK keys, which are just the public key without the push or the OP_CHECKSIG). If the x isn't
+// on the curve there's no y to put back, so it's left the way it's stored.
+func scriptField(nsize int, script []byte) string {
+    if (nsize == 4 || nsize == 5) && len(script) == 33 {
+        if pubkey := keys.DecompressPublicKey(script[1:], nsize == 5); pubkey != nil {
+            return hex.EncodeToString(pubkey)
+        }
+    }
+    return hex.EncodeToString(script)
+}
+
+// uncompressedPublicKey gets the 65 byte (04 + x + y) public key from a P2PK script, working out the y if the key has been
+// compressed (either in the script itself, or by the chainstate for nsize 4 and 5), or changing the prefix of a hybrid key.
+// Returns nil if it's not P2PK, or the key isn't a valid point on the curve.
+func uncompressedPublicKey(scriptType string, nsize int, script []byte) []byte {
+    if scriptType != "p2pk" {
+        return nil
+    }
+    if nsize < 6 { // 2 = 02 + x, 3 = 03 + x, 4 = x (y is even), 5 = x (y is odd)
+        return keys.DecompressPublicKey(script, nsize == 3 || nsize == 5)
+    }
+
+    pubkey := script[1:len(script)-1] // full script, so the public key is between the push and the OP_CHECKSIG
+    switch {
+    case len(pubkey) == 65 && pubkey[0] == 0x04:
+        return pubkey
+    case len(pubkey) == 65 && (pubkey[0] == 0x06 || pubkey[0] == 0x07):
+        // hybrid key (06 = y is even, 07 = y is odd), which is the same point as the 04 key, as long as the prefix
+        // matches the y
+        if pubkey[0] & 1 != pubkey[64] & 1 {
+            return nil
+        }
+        return append([]byte{0x04}, pubkey[1:]...)
+    case len(pubkey) == 33 && (pubkey[0] == 0x02 || pubkey[0] == 0x03):
+        return keys.DecompressPublicKey(pubkey, pubkey[0] == 0x03)
+    }
+    return nil
+}
+
+// pubkeyParity is whether the y coordinate of the public key in a P2PK output is even or odd (the pubkey_parity field).
+// A compressed key has it in the prefix (02 = even, 03 = odd), and the chainstate keeps it in the nsize for the
+// uncompressed keys it compresses (4 = even, 5 = odd), so nothing needs decompressing. A full uncompressed key has it in
+// the last bit of y. Returns "" for every other script type.
+func pubkeyParity(scriptType string, nsize int, script []byte) string {
+    if scriptType != "p2pk" {
+        return ""
+    }
+    odd := false
+    if nsize < 6 {
+        odd = nsize == 3 || nsize == 5
+    } else {
+        pubkey := script[1:len(script)-1] // full script, so the public key is between the push and the OP_CHECKSIG
+        switch len(pubkey) {
+        case 33:
+            odd = pubkey[0] == 0x03
+        case 65:
+            odd = pubkey[64]&1 == 1
+        default:
+            return ""
+        }
+    }
+    if odd {
+        return "odd"
+    }
+    return "even"
+}
+
+// addressPayload gets the bytes an address is an encoding of, without the checksum (for joining on something simpler
+// than the address string):
+//
+//   p2pkh, p2sh   version byte + hash160  (what gets base58check encoded, e.g. 00 62e907b1...)
+//   segwit        witness version + program  (what gets bech32 encoded, e.g. 00 751e76e8...)
+//
+// Returns nil if the script doesn't have an address.
+func addressPayload(scriptType string, script []byte, params networkParams) []byte {
+    switch {
+    case scriptType == "p2pkh":
+        return append([]byte{params.p2pkh}, script...)
+    case scriptType == "p2sh":
+        return append([]byte{params.p2sh}, script...)
+    case hasAddress(scriptType): // p2wpkh, p2wsh, p2tr, witness_v2 to witness_v16
+        return append([]byte{byte(btcscript.WitnessVersion(script))}, btcscript.WitnessProgram(script)...)
+    }
+    return nil
+}
+
+// legacyAddress is the P2PKH address for the same hash160 as a P2WPKH output (the address_legacy field). Nobody can have
+// sent to this address to make the utxo, it's only for matching up with data that has written segwit hashes as legacy
+// addresses. Returns "" for every other script type.
+func legacyAddress(scriptType string, script []byte, params networkParams) string {
+    if scriptType != "p2wpkh" {
+        return ""
+    }
+    return keys.Hash160ToAddress(btcscript.WitnessProgram(script), []byte{params.p2pkh})
+}
+
+// Parallel Encoding (-parallel-encode)
+// -----------------
+// Working out the addresses (base58 and bech32) is the slowest part of decoding each utxo, so this spreads it over a
+// pool of goroutines:
+//
+//   main loop --> jobs --> [encoder] [encoder] [encoder] ...
+//        \                     |         |         |
+//         `-> ordered ---> collector (waits for each job in turn, so the rows still come out in the same order)
+//
+// The main loop sends each job to both channels, so the collector sees them in the same order they came out of the database.
+type encodeJob struct {
+    key        []byte
+    output     map[string]string
+    scriptType string
+    nsize      int
+    script     []byte
+    done       chan struct{} // closed when the address has been added to output
+}
+
+type encodePipeline struct {
+    jobs     chan *encodeJob
+    ordered  chan *encodeJob
+    finished chan struct{}
+    failed   chan struct{} // closed once writing a row has failed (err is set before it's closed)
+    err      error         // first error from writing a row
+    workers  sync.WaitGroup
+}
+
+// newEncodePipeline starts n encoders and the collector, which passes each finished row on to write()
+func newEncodePipeline(n int, params networkParams, withDescriptor bool, write func(key []byte, output map[string]string) error) *encodePipeline {
+    p := &encodePipeline{
+        jobs:     make(chan *encodeJob, n*64),
+        ordered:  make(chan *encodeJob, n*256),
+        finished: make(chan struct{}),
+        failed:   make(chan struct{}),
+    }
+
+    for w := 0; w < n; w++ {
+        p.workers.Add(1)
+        go func() {
+            defer p.workers.Done()
+            for job := range p.jobs {
+                address := encodeAddress(job.scriptType, job.script, params)
+                job.output["address"] = address
+                if withDescriptor {
+                    job.output["descriptor"] = encodeDescriptor(job.scriptType, job.nsize, job.script, address)
+                }
+                close(job.done)
+            }
+        }()
+    }
+
+    go func() {
+        defer close(p.finished)
+        for job := range p.ordered {
+            <-job.done
+            if p.err == nil {
+                p.err = write(job.key, job.output)
+                if p.err != nil {
+                    close(p.failed) // (the jobs still coming get drained without being written)
+                }
+            }
+        }
+    }()
+
+    return p
+}
+
+// send copies everything the job needs (the main loop reuses the output map, and the iterator reuses the key and script memory).
+// It returns the error if writing an earlier row has failed, so the main loop can stop instead of decoding the rest of the
+// chainstate for nothing.
+func (p *encodePipeline) send(key []byte, output map[string]string, scriptType string, nsize int, script []byte) error {
+    select {
+    case <-p.failed:
+        return p.err
+    default:
+    }
+
+    job := &encodeJob{
+        key:        append([]byte{}, key...),
+        output:     make(map[string]string, len(output)+2),
+        scriptType: scriptType,
+        nsize:      nsize,
+        script:     append([]byte{}, script...),
+        done:       make(chan struct{}),
+    }
+    for k, v := range output {
+        job.output[k] = v
+    }
+    p.ordered <- job
+    p.jobs <- job
+    return nil
+}
+
+// close waits for the rows still in the pipeline to be written
+func (p *encodePipeline) close() error {
+    close(p.jobs)
+    close(p.ordered)
+    p.workers.Wait()
+    <-p.finished
+    return p.err
+}
@@ -0,0 +1,148 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/bech32"
+
+import "bytes"
+import "errors"
+import "encoding/hex"
+import "fmt"
+import "strings"
+import "testing"
+
+func TestEncodeAddressWitnessFuture(t *testing.T) {
+    // a synthetic version 2 program (OP_2 <32 bytes>), which gets a bech32m address like p2tr
+    script, _ := hex.DecodeString("5220" + strings.Repeat("11", 32))
+    address := encodeAddress("witness_v2", script, mainnetParams)
+    if !strings.HasPrefix(address, "bc1z") { // (z is 2 in bech32)
+        t.Fatalf("address = %q, want bc1z...", address)
+    }
+    if _, _, err := bech32.DecodeM(address); err != nil {
+        t.Errorf("%s isn't bech32m: %v", address, err)
+    }
+    version, program, err := bech32.SegwitAddrDecode("bc", address)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if version != 2 || len(program) != 32 {
+        t.Errorf("%s decodes to version %d with a %d byte program", address, version, len(program))
+    }
+}
+
+// benchmarkScripts is a mix of the script types that get an address (as they're stored in the chainstate)
+func benchmarkScripts() (types []string, scripts [][]byte) {
+    for i := 0; i < 1000; i++ {
+        hash := bytes.Repeat([]byte{byte(i)}, 20)
+        switch i % 4 {
+        case 0:
+            types, scripts = append(types, "p2pkh"), append(scripts, hash)
+        case 1:
+            types, scripts = append(types, "p2sh"), append(scripts, hash)
+        case 2:
+            types, scripts = append(types, "p2wpkh"), append(scripts, append([]byte{0x00, 20}, hash...))
+        case 3:
+            types, scripts = append(types, "p2tr"), append(scripts, append([]byte{0x51, 32}, bytes.Repeat([]byte{byte(i)}, 32)...))
+        }
+    }
+    return types, scripts
+}
+
+// The address path in the main loop (without -parallel-encode)
+func BenchmarkEncodeAddress(b *testing.B) {
+    types, scripts := benchmarkScripts()
+    output := map[string]string{}
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        output["address"] = encodeAddress(types[i % len(types)], scripts[i % len(scripts)], mainnetParams)
+    }
+}
+
+// The same with -parallel-encode (including the copying and the ordered collector), e.g.
+//
+//   go test -bench Encode -cpu 8
+func BenchmarkEncodePipeline(b *testing.B) {
+    types, scripts := benchmarkScripts()
+    for _, n := range []int{1, 2, 4, 8} {
+        b.Run(fmt.Sprintf("parallel-encode=%d", n), func(b *testing.B) {
+            output := map[string]string{"txid": strings.Repeat("11", 32), "vout": "0", "amount": "339500"}
+            key := make([]byte, 34)
+            pipeline := newEncodePipeline(n, mainnetParams, false, func(key []byte, output map[string]string) error { return nil })
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if err := pipeline.send(key, output, types[i % len(types)], 0, scripts[i % len(scripts)]); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            if err := pipeline.close(); err != nil {
+                b.Fatal(err)
+            }
+        })
+    }
+}
+
+func TestUncompressedPublicKey(t *testing.T) {
+    // the generator point (y is even)
+    x := "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+    y := "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+    tests := []struct {
+        name   string
+        nsize  int
+        script string
+        want   string // empty for nil
+    }{
+        {"compressed (nsize 2)", 2, "02" + x, "04" + x + y},
+        {"uncompressed (nsize 4)", 4, "04" + x, "04" + x + y},
+        {"full script, uncompressed", 73, "41" + "04" + x + y + "ac", "04" + x + y},
+        {"full script, compressed", 41, "21" + "02" + x + "ac", "04" + x + y},
+        {"full script, hybrid", 73, "41" + "06" + x + y + "ac", "04" + x + y},
+        {"full script, hybrid with the wrong parity", 73, "41" + "07" + x + y + "ac", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            script, _ := hex.DecodeString(tt.script)
+            got := hex.EncodeToString(uncompressedPublicKey("p2pk", tt.nsize, script))
+            if got != tt.want {
+                t.Errorf("uncompressedPublicKey = %s, want %s", got, tt.want)
+            }
+        })
+    }
+
+    // and the descriptor for a hybrid key is the raw script
+    script, _ := hex.DecodeString("41" + "06" + x + y + "ac")
+    if desc := encodeDescriptor("p2pk", 73, script, ""); !strings.HasPrefix(desc, "raw(4106") {
+        t.Errorf("descriptor = %s, want raw(...)", desc)
+    }
+}
+
+func TestEncodePipelineWriteError(t *testing.T) {
+    // the disk fills up (or whatever) after 10 rows
+    errFull := errors.New("no space left on device")
+    written := 0
+    pipeline := newEncodePipeline(2, mainnetParams, false, func(key []byte, output map[string]string) error {
+        if written == 10 {
+            return errFull
+        }
+        written++
+        return nil
+    })
+
+    // send stops taking rows soon after (once the ones already in the pipeline have caught up), instead of carrying on
+    // to the end
+    types, scripts := benchmarkScripts()
+    sent := 0
+    var err error
+    for ; sent < 100000 && err == nil; sent++ {
+        err = pipeline.send(make([]byte, 34), map[string]string{}, types[sent % len(types)], 0, scripts[sent % len(scripts)])
+    }
+    if err != errFull {
+        t.Fatalf("send = %v after %d rows, want %v", err, sent, errFull)
+    }
+    if sent == 100000 {
+        t.Errorf("send took all %d rows", sent)
+    }
+    if err := pipeline.close(); err != errFull {
+        t.Errorf("close = %v, want %v", err, errFull)
+    }
+    if written != 10 {
+        t.Errorf("wrote %d rows, want 10", written)
+    }
+}
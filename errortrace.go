@@ -0,0 +1,99 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/utxo"
+
+import "encoding/hex"
+import "fmt"
+import "log/slog"
+import "strings"
+
+// Verbose Errors (-verbose-errors)
+// --------------------------------
+// A malformed entry (or one that panics) normally only gets its key logged, which isn't much to go on. With
+// -verbose-errors the decoder keeps track of how far it's got through each value, so when an entry fails it can print
+// (and log) everything you'd need to work out why, or to put in a bug report:
+//
+//   Entry 43eeeeeeee...00 failed: amount: varint at offset 1 runs past the end of the value (80)
+//    key:           43eeeeeeee...00
+//    value:         b3ad
+//    obfuscate key: b12dcefd8f872536
+//    deobfuscated:  02 | 80
+//    failed at:     offset 1 (of 2 bytes)
+//    decoded:       height=1 coinbase=0
+//
+// The | in the deobfuscated value is where it failed (the offset), so everything before it decoded fine. The trace is
+// only kept with the flag (it's nil otherwise, and does nothing).
+type decodeTrace struct {
+    obfuscateKey []byte
+    xor          []byte   // the value with the obfuscation taken off (nil if the value isn't being decoded)
+    offset       int      // how far in to xor the decoder has got
+    decoded      []string // what's been decoded so far, e.g. height=532819
+}
+
+func newDecodeTrace(obfuscateKey []byte) *decodeTrace {
+    return &decodeTrace{obfuscateKey: obfuscateKey}
+}
+
+// reset is called at the start of each entry
+func (t *decodeTrace) reset() {
+    if t == nil {
+        return
+    }
+    t.xor = nil
+    t.offset = 0
+    t.decoded = t.decoded[:0]
+}
+
+// deobfuscated keeps the value once the obfuscation has been taken off
+func (t *decodeTrace) deobfuscated(xor []byte) {
+    if t == nil {
+        return
+    }
+    t.xor = xor
+}
+
+// step records each part of the value as it gets decoded, and the offset the next part starts at
+func (t *decodeTrace) step(offset int, name string, value int) {
+    if t == nil {
+        return
+    }
+    t.offset = offset
+    t.decoded = append(t.decoded, fmt.Sprintf("%s=%d", name, value))
+}
+
+// parts records the parts of the value that got decoded by utxo.DecodeValue (all of them, or the ones before the error)
+func (t *decodeTrace) parts(v utxo.Value) {
+    if t == nil {
+        return
+    }
+    if v.Ends[0] > 0 {
+        t.step(v.Ends[0], "height", v.HeightCode >> 1)
+        t.step(v.Ends[0], "coinbase", v.HeightCode & 1)
+    }
+    if v.Ends[1] > 0 {
+        t.step(v.Ends[1], "amount_compressed", v.CompressedAmount)
+    }
+    if v.Ends[2] > 0 {
+        t.step(v.Ends[2], "nsize", v.NSize)
+    }
+}
+
+// report prints and logs everything about the entry that failed
+func (t *decodeTrace) report(key []byte, value []byte, failure string, logger *slog.Logger) {
+    deobfuscated := "(not decoded)"
+    if t.xor != nil {
+        at := min(t.offset, len(t.xor))
+        deobfuscated = hex.EncodeToString(t.xor[:at]) + " | " + hex.EncodeToString(t.xor[at:])
+    }
+    decoded := strings.Join(t.decoded, " ")
+
+    fmt.Printf("Entry %x failed: %s\n", key, failure)
+    fmt.Printf(" key:           %x\n", key)
+    fmt.Printf(" value:         %x\n", value)
+    fmt.Printf(" obfuscate key: %x\n", t.obfuscateKey)
+    fmt.Printf(" deobfuscated:  %s\n", deobfuscated)
+    fmt.Printf(" failed at:     offset %d (of %d bytes)\n", t.offset, len(value))
+    fmt.Printf(" decoded:       %s\n", decoded)
+
+    logger.Error("entry failed (verbose)", "key", hex.EncodeToString(key), "value", hex.EncodeToString(value), "obfuscate_key", hex.EncodeToString(t.obfuscateKey), "deobfuscated", hex.EncodeToString(t.xor), "offset", t.offset, "decoded", decoded, "error", failure)
+}
@@ -0,0 +1,235 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/keys"   // decode base58 addresses
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/bech32" // decode segwit addresses
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript" // witness programs
+
+import "bufio" // reading addresses files line by line
+import "bytes"
+import "fmt"
+import "math/bits" // counting leading zeros
+import "os"
+import "strconv"
+import "strings"
+
+// Address Filters
+// ---------------
+// -address/-addresses-file only dump utxos locked to the given addresses, and -exclude-address/-exclude-addresses-file
+// skip utxos locked to the given addresses. If both are used, the excluded addresses are taken away from the included ones.
+//
+// The addresses are decoded once at the start to the same raw bytes we get from the chainstate, so we don't have to
+// encode an address for every utxo just to compare it:
+//
+//   p2pkh  = 00 + hash160          (nsize 0 + script)
+//   p2sh   = 01 + hash160          (nsize 1 + script)
+//   segwit = version + length + program (the full script)
+//
+// With -address-prefix-match the addresses are prefixes instead (e.g. bc1qxyz), which can't be decoded, so the address
+// of each utxo gets encoded and compared as a string instead (slower).
+type addressFilter struct {
+    include map[string]bool
+    exclude map[string]bool
+
+    prefixMatch     bool // -address-prefix-match
+    includePrefixes []string
+    excludePrefixes []string
+    params          networkParams // for encoding the address of each utxo (prefix match only)
+}
+
+// active tells us if we need to get the script for every utxo to check it against the filter
+func (a *addressFilter) active() bool {
+    return len(a.include) > 0 || len(a.exclude) > 0 || len(a.includePrefixes) > 0 || len(a.excludePrefixes) > 0
+}
+
+// match returns true if the utxo with this nsize and script should be dumped
+func (a *addressFilter) match(nsize int, script []byte) bool {
+    if a.prefixMatch {
+        return a.matchPrefix(filterAddress(nsize, script, a.params))
+    }
+
+    k := addressFilterKey(nsize, script)
+    if len(a.include) > 0 && !a.include[k] {
+        return false
+    }
+    return !a.exclude[k]
+}
+
+// matchPrefix checks an encoded address against the -address-prefix-match prefixes
+func (a *addressFilter) matchPrefix(address string) bool {
+    if len(a.includePrefixes) > 0 {
+        if address == "" || !hasAnyPrefix(address, a.includePrefixes) {
+            return false
+        }
+    }
+    return address == "" || !hasAnyPrefix(address, a.excludePrefixes)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+    for _, prefix := range prefixes {
+        if strings.HasPrefix(s, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// filterAddress encodes the address for the nsize and script (without needing the script type), or "" if it hasn't got one
+func filterAddress(nsize int, script []byte, params networkParams) string {
+    switch {
+    case nsize == 0:
+        return encodeAddress("p2pkh", script, params)
+    case nsize == 1:
+        return encodeAddress("p2sh", script, params)
+    case nsize > 5 && (btcscript.WitnessVersion(script) == 0 || btcscript.WitnessVersion(script) >= 2 || btcscript.IsP2TR(script)):
+        return encodeAddress("p2wsh", script, params) // (every witness version is encoded the same way)
+    }
+    return ""
+}
+
+// normalizeAddress tidies up an address that has been pasted in (surrounding whitespace, and bech32 addresses in
+// uppercase or mixed case, which are all the same address)
+func normalizeAddress(address string, params networkParams) string {
+    address = strings.TrimSpace(address)
+    lower := strings.ToLower(address)
+    if strings.HasPrefix(lower, params.hrp+"1") || strings.HasPrefix(params.hrp+"1", lower) { // (a prefix can be shorter than the hrp)
+        return lower
+    }
+    return address // base58 is case-sensitive
+}
+
+func addressFilterKey(nsize int, script []byte) string {
+    if nsize == 0 || nsize == 1 { // p2pkh or p2sh
+        return string(append([]byte{byte(nsize)}, script...))
+    }
+    return string(script)
+}
+
+// addressHash gets the hash160 (p2pkh, p2sh) or witness program (segwit) that an address is made from
+// Other scripts (e.g. p2pk, p2ms) don't have one, so they return nil.
+func addressHash(nsize int, script []byte) []byte {
+    if nsize == 0 || nsize == 1 {
+        return script
+    }
+    if nsize > 5 {
+        return btcscript.WitnessProgram(script)
+    }
+    return nil
+}
+
+// hashLeadingZeros counts the zero bits at the start of the address hash (hash_leading_zeros), e.g. 00000f... = 20
+// Vanity addresses and ones ground out by a program tend to have a lot more of them than the 1 you'd expect by chance.
+// Empty if there's no hash (e.g. p2pk, p2ms).
+func hashLeadingZeros(nsize int, script []byte) string {
+    hash := addressHash(nsize, script)
+    if len(hash) == 0 {
+        return ""
+    }
+    zeros := 0
+    for _, b := range hash {
+        zeros += bits.LeadingZeros8(b)
+        if b != 0 {
+            break
+        }
+    }
+    return strconv.Itoa(zeros)
+}
+
+// newAddressFilter decodes all the addresses from the comma-separated flags and files
+func newAddressFilter(include string, includeFile string, exclude string, excludeFile string, prefixMatch bool, params networkParams) (*addressFilter, error) {
+    a := &addressFilter{include: map[string]bool{}, exclude: map[string]bool{}, prefixMatch: prefixMatch, params: params}
+
+    for _, set := range []struct {
+        list     string
+        file     string
+        keys     map[string]bool
+        prefixes *[]string
+    }{
+        {include, includeFile, a.include, &a.includePrefixes},
+        {exclude, excludeFile, a.exclude, &a.excludePrefixes},
+    } {
+        addresses := []string{}
+        if set.list != "" {
+            addresses = append(addresses, strings.Split(set.list, ",")...)
+        }
+        if set.file != "" {
+            fromFile, err := readAddressesFile(set.file)
+            if err != nil {
+                return nil, err
+            }
+            addresses = append(addresses, fromFile...)
+        }
+        for _, address := range addresses {
+            address = normalizeAddress(address, params)
+            if address == "" {
+                continue // e.g. a trailing comma
+            }
+            if prefixMatch {
+                *set.prefixes = append(*set.prefixes, address)
+                continue
+            }
+            k, err := decodeAddress(address, params)
+            if err != nil {
+                return nil, err
+            }
+            set.keys[k] = true
+        }
+    }
+
+    return a, nil
+}
+
+// readAddressesFile reads one address per line (blank lines and lines starting with # are ignored)
+func readAddressesFile(file string) ([]string, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    addresses := []string{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        addresses = append(addresses, line)
+    }
+    return addresses, scanner.Err()
+}
+
+// decodeAddress turns an address in to the filter key we can compare against each utxo
+func decodeAddress(address string, params networkParams) (string, error) {
+
+    // bech32 (segwit)
+    hrp := params.hrp
+    if strings.HasPrefix(strings.ToLower(address), hrp+"1") {
+        version, program, err := bech32.SegwitAddrDecode(hrp, strings.ToLower(address))
+        if err != nil {
+            return "", fmt.Errorf("couldn't decode address %s: %v", address, err)
+        }
+        script := []byte{byte(version)}
+        if version > 0 {
+            script[0] = byte(0x50 + version) // OP_1 to OP_16
+        }
+        script = append(script, byte(len(program)))
+        for _, v := range program {
+            script = append(script, byte(v))
+        }
+        return string(script), nil
+    }
+
+    // base58 (p2pkh, p2sh)
+    prefix, hash160, err := keys.AddressToHash160(address)
+    if err != nil {
+        return "", fmt.Errorf("couldn't decode address %s: %v", address, err)
+    }
+    p2pkh, p2sh := []byte{params.p2pkh}, []byte{params.p2sh}
+    switch {
+    case bytes.Equal(prefix, p2pkh):
+        return addressFilterKey(0, hash160), nil
+    case bytes.Equal(prefix, p2sh):
+        return addressFilterKey(1, hash160), nil
+    }
+    return "", fmt.Errorf("address %s is not for this network", address)
+}
@@ -0,0 +1,103 @@
+package main
+
+import "bufio"
+import "encoding/json" // escaping strings
+import "strconv"
+
+// Grouped JSON (-format grouped-json, -group-by)
+// ----------------------------------------------
+// One json object per transaction, with all of its unspent outputs:
+//
+//   {"txid":"0e3e2357...","outputs":[{"vout":0,"amount":5000000000,"type":"p2pk"},{"vout":1,"amount":546,"type":"p2pkh"}]}
+//
+// All the outputs for a txid are next to each other in the chainstate (the keys are sorted by txid), so we only need to
+// hold on to the outputs for one transaction at a time, and write them out when the txid changes.
+//
+// With -group-by address there's one object per address instead, with the number of utxos and the total amount for the
+// address, which is what you want for putting wallets back together:
+//
+//   {"address":"1A1zP1eP...","utxos":2,"amount":5000000546,"outputs":[{"txid":"0e3e2357...","vout":1,"amount":546},...]}
+//
+// The chainstate isn't in address order, so the rows go through the -sort (by address) first, and then come here one
+// address after the other the same as the txids do. The utxos without an address (p2pk, p2ms, non-standard) don't belong
+// together, and on mainnet there are millions of them, which would make one enormous group to hold in memory (and one
+// enormous line). So each of these gets an object of its own instead, with an empty address (they all come first):
+//
+//   {"address":"","utxos":1,"amount":5000000000,"outputs":[{"txid":"4a5e1e4b...","vout":0,"amount":5000000000}]}
+type groupedJSONWriter struct {
+    w       *bufio.Writer
+    by      string              // the field the outputs are grouped by (txid, or address with -group-by address)
+    totals  bool                // add the number of utxos and the total amount to each group (-group-by address)
+    fields  []string            // fields for each output (everything in -f apart from the one they're grouped by)
+    key     string              // txid (or address) we're currently collecting outputs for
+    outputs []map[string]string
+    amount  int                 // total amount of the outputs (satoshis)
+    newline string
+}
+
+func (g *groupedJSONWriter) Header(fields []string) error {
+    for _, v := range fields {
+        if v != g.by {
+            g.fields = append(g.fields, v)
+        }
+    }
+    return nil
+}
+
+func (g *groupedJSONWriter) Row(output map[string]string) error {
+    if len(g.outputs) > 0 && !g.sameGroup(output[g.by]) {
+        if err := g.flush(); err != nil {
+            return err
+        }
+    }
+    g.key = output[g.by]
+
+    // copy the values (the output map gets reused for the next utxo)
+    values := map[string]string{}
+    for _, v := range g.fields {
+        values[v] = output[v]
+    }
+    g.outputs = append(g.outputs, values)
+    if g.totals {
+        amount, _ := strconv.Atoi(output["amount"])
+        g.amount += amount
+    }
+    return nil
+}
+
+// sameGroup says if a row goes in the group we're collecting (a utxo without an address is always on its own)
+func (g *groupedJSONWriter) sameGroup(key string) bool {
+    if g.by == "address" && key == "" {
+        return false
+    }
+    return key == g.key
+}
+
+func (g *groupedJSONWriter) Close() error {
+    if len(g.outputs) > 0 {
+        return g.flush()
+    }
+    return nil
+}
+
+// flush writes the current transaction (or address)
+func (g *groupedJSONWriter) flush() error {
+    key, _ := json.Marshal(g.key)
+    line := `{"` + g.by + `":` + string(key)
+    if g.totals {
+        line += `,"utxos":` + strconv.Itoa(len(g.outputs)) + `,"amount":` + strconv.Itoa(g.amount)
+    }
+    line += `,"outputs":[`
+    for i, values := range g.outputs {
+        if i > 0 {
+            line += ","
+        }
+        line += jsonObject(values, g.fields)
+    }
+    line += "]}" + g.newline
+    g.outputs = g.outputs[:0]
+    g.amount = 0
+
+    _, err := g.w.WriteString(line)
+    return err
+}
@@ -0,0 +1,39 @@
+package main
+
+import "strconv"
+import "strings"
+
+// Human-Readable Stats (-human)
+// -----------------------------
+// Puts thousands separators in the numbers in the stats at the end (e.g. Total UTXOs: 81,234,567), to make them easier
+// to read in a report. This is only for the stats that get printed, the rows in the file (and the -summary-file) are
+// always left as plain numbers so they can be read back in.
+type humanFormat bool
+
+// count formats a whole number (e.g. 81234567 -> 81,234,567)
+func (h humanFormat) count(n int) string {
+    return h.number(strconv.Itoa(n))
+}
+
+// number formats a number that's already a string, keeping any decimal places as they are (e.g. 19400000.00000000 -> 19,400,000.00000000)
+func (h humanFormat) number(s string) string {
+    if !h {
+        return s
+    }
+    sign := ""
+    if strings.HasPrefix(s, "-") {
+        sign, s = "-", s[1:]
+    }
+    whole, fraction, hasFraction := strings.Cut(s, ".")
+
+    grouped := ""
+    for len(whole) > 3 {
+        grouped = "," + whole[len(whole)-3:] + grouped
+        whole = whole[:len(whole)-3]
+    }
+    grouped = sign + whole + grouped
+    if hasFraction {
+        grouped += "." + fraction
+    }
+    return grouped
+}
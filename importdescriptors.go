@@ -0,0 +1,106 @@
+package main
+
+import "bufio"
+import "encoding/json" // escaping the descriptors
+import "fmt"
+import "sort"
+import "strconv"
+import "strings"
+
+// Import Descriptors (-format importdescriptors)
+// ----------------------------------------------
+// Writes the descriptors for the utxos as the json array that Bitcoin Core's importdescriptors takes, so the utxos can be
+// watched in a (watch-only) descriptor wallet:
+//
+//   [
+//   {"desc":"addr(1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa)#...","timestamp":1231006505},
+//   {"desc":"pk(0279be66...)#...","timestamp":1231006505}
+//   ]
+//
+//   $ bitcoin-cli -rpcwallet=watchonly importdescriptors "$(cat utxodump.json)"
+//
+// Each descriptor only goes in once (an address can have lots of utxos). The timestamp tells the wallet how far back to
+// rescan the blocks to find the utxos, so it needs to be before the block the oldest utxo for the descriptor was created
+// in. We only have the height, so the time is estimated at 9 minutes a block from the genesis block. Blocks have come a
+// bit faster than every 10 minutes on average, so this always comes out a little before the real time (which means a
+// slightly longer rescan, but it won't miss anything).
+//
+// A utxo without a descriptor (a non-standard script, or a P2PK public key that isn't on the curve) can't go in the file,
+// so the ones that get left out are counted and shown at the end, instead of just going missing:
+//
+//   importdescriptors: left out 3 utxos that don't have a descriptor (non-standard 2, p2pk 1)
+type importDescriptorsWriter struct {
+    w         *bufio.Writer
+    now       bool           // -import-timestamp now
+    order     []string       // descriptors in the order they were first seen
+    minHeight map[string]int // descriptor = height of its oldest utxo
+    skipped   map[string]int // script type = number of utxos left out
+}
+
+const genesisTime = 1231006505 // 2009-01-03 18:15:05 UTC
+const estimatedBlockTime = 9 * 60 // seconds (a bit faster than the real average, so the estimate is never too late)
+
+func newImportDescriptorsWriter(w *bufio.Writer, timestamp string) (*importDescriptorsWriter, error) {
+    if timestamp != "height" && timestamp != "now" {
+        return nil, fmt.Errorf("-import-timestamp needs to be height or now")
+    }
+    return &importDescriptorsWriter{w: w, now: timestamp == "now", minHeight: map[string]int{}, skipped: map[string]int{}}, nil
+}
+
+func (d *importDescriptorsWriter) Header(fields []string) error {
+    return nil // the descriptor and height get worked out whatever the -f fields are
+}
+
+func (d *importDescriptorsWriter) Row(output map[string]string) error {
+    desc := output["descriptor"]
+    if desc == "" { // (non-standard scripts, and public keys that aren't on the curve)
+        d.skipped[output["type"]]++
+        return nil
+    }
+    height, _ := strconv.Atoi(output["height"])
+    if h, ok := d.minHeight[desc]; !ok {
+        d.order = append(d.order, desc)
+        d.minHeight[desc] = height
+    } else if height < h {
+        d.minHeight[desc] = height
+    }
+    return nil
+}
+
+func (d *importDescriptorsWriter) Close() error {
+    d.w.WriteString("[\n")
+    for i, desc := range d.order {
+        escaped, _ := json.Marshal(desc)
+        timestamp := `"now"` // only watch for new transactions (no rescan)
+        if !d.now {
+            timestamp = strconv.Itoa(genesisTime + d.minHeight[desc] * estimatedBlockTime)
+        }
+        d.w.WriteString(`{"desc":` + string(escaped) + `,"timestamp":` + timestamp + `}`)
+        if i < len(d.order)-1 {
+            d.w.WriteByte(',')
+        }
+        d.w.WriteByte('\n')
+    }
+    _, err := d.w.WriteString("]\n")
+    d.reportSkipped()
+    return err
+}
+
+// reportSkipped says how many utxos didn't go in the file (and what types they were)
+func (d *importDescriptorsWriter) reportSkipped() {
+    total := 0
+    var types []string
+    for scriptType, n := range d.skipped {
+        total += n
+        types = append(types, scriptType)
+    }
+    if total == 0 {
+        return
+    }
+    sort.Strings(types)
+    var counts []string
+    for _, scriptType := range types {
+        counts = append(counts, fmt.Sprintf("%s %d", scriptType, d.skipped[scriptType]))
+    }
+    fmt.Printf("importdescriptors: left out %d utxos that don't have a descriptor (%s)\n", total, strings.Join(counts, ", "))
+}
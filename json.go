@@ -0,0 +1,106 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript"
+
+import "bufio"
+import "encoding/hex"
+import "encoding/json" // escaping strings
+import "strconv"
+import "strings"
+
+// JSON (-format json, -json-script)
+// ---------------------------------
+// One flat json object per line for each utxo, with the -f fields in order (int fields as numbers):
+//
+//   {"txid":"0e3e2357...","vout":1,"amount":546,"nsize":0,"type":"p2pkh","script":"62e907b1..."}
+//
+// With -json-script the script fields (nsize, type, script) are put together in a script object instead, along with the
+// hash160 (for p2pkh, p2sh and p2wpkh), the full scriptPubKey (rebuilt from the compressed script in the chainstate), and
+// the scriptPubKey as asm:
+//
+//   {"txid":"0e3e2357...","vout":1,"amount":546,"script":{"nsize":0,"type":"p2pkh","hash160":"62e907b1...",
+//    "scriptpubkey":"76a91462e907b1...88ac","asm":"OP_DUP OP_HASH160 62e907b1... OP_EQUALVERIFY OP_CHECKSIG"}}
+//
+// The script object goes where the first script field is in -f (or on the end if none of them are).
+type jsonWriter struct {
+    w          *bufio.Writer
+    nestScript bool     // -json-script
+    fields     []string // flat fields (without the script fields if they're being nested)
+    scriptAt   int      // where the script object goes in the fields
+    newline    string
+}
+
+func (j *jsonWriter) Header(fields []string) error {
+    if !j.nestScript {
+        j.fields = fields
+        return nil
+    }
+    j.scriptAt = -1
+    for _, v := range fields {
+        if v == "nsize" || v == "type" || v == "script" {
+            if j.scriptAt < 0 {
+                j.scriptAt = len(j.fields)
+            }
+            continue
+        }
+        j.fields = append(j.fields, v)
+    }
+    if j.scriptAt < 0 {
+        j.scriptAt = len(j.fields)
+    }
+    return nil // no header, every object has its own keys
+}
+
+func (j *jsonWriter) Row(output map[string]string) error {
+    if !j.nestScript {
+        _, err := j.w.WriteString(jsonObject(output, j.fields) + j.newline)
+        return err
+    }
+
+    // put the script object in between the flat fields, e.g. {"txid":"...","script":{...},"amount":546}
+    before := jsonObject(output, j.fields[:j.scriptAt])
+    after := jsonObject(output, j.fields[j.scriptAt:])
+    members := []string{}
+    if len(before) > 2 { // (not just {})
+        members = append(members, before[1:len(before)-1])
+    }
+    members = append(members, `"script":`+scriptObject(output))
+    if len(after) > 2 {
+        members = append(members, after[1:len(after)-1])
+    }
+    _, err := j.w.WriteString("{" + strings.Join(members, ",") + "}" + j.newline)
+    return err
+}
+
+func (j *jsonWriter) Close() error {
+    return nil
+}
+
+// scriptObject builds the nested script object for -json-script
+func scriptObject(output map[string]string) string {
+    nsize, _ := strconv.Atoi(output["nsize"])
+    script, _ := hex.DecodeString(output["script"])
+
+    object := `{"nsize":` + strconv.Itoa(nsize)
+    scriptType, _ := json.Marshal(output["type"])
+    object += `,"type":` + string(scriptType)
+
+    // hash160 of the public key (p2pkh, p2wpkh) or the script (p2sh)
+    switch output["type"] {
+    case "p2pkh", "p2sh":
+        object += `,"hash160":"` + hex.EncodeToString(script) + `"`
+    case "p2wpkh":
+        object += `,"hash160":"` + hex.EncodeToString(btcscript.WitnessProgram(script)) + `"`
+    }
+
+    // the full scriptPubKey (null if it can't be rebuilt, e.g. a public key that isn't on the curve)
+    if full, ok := btcleveldb.DecompressScript(nsize, script); ok {
+        object += `,"scriptpubkey":"` + hex.EncodeToString(full) + `"`
+        asm, _ := json.Marshal(btcscript.Disasm(full))
+        object += `,"asm":` + string(asm)
+    } else {
+        object += `,"scriptpubkey":null,"asm":null`
+    }
+    return object + "}"
+}
@@ -0,0 +1,97 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/syndtr/goleveldb/leveldb"
+
+import "encoding/binary" // outpoint_bin
+import "encoding/hex"
+import "fmt"
+
+// Housekeeping Keys
+// -----------------
+// Apart from the utxos (C = 0x43), the chainstate has a few other keys that Bitcoin Core uses to keep track of things.
+// These get skipped, so that they don't get counted as utxos:
+//
+//   0e + obfuscate_key = the key the values are XORed with
+//   B                  = hash of the block the utxo set is at (best block)
+//   H                  = the old and new best blocks, only there if bitcoind stopped in the middle of writing the utxos
+//
+// Anything else is unexpected (e.g. c = coins in the old format from before Bitcoin Core 0.15), so gets counted and logged.
+var housekeepingKeys = map[byte]string{
+    0x0e: "obfuscate key",
+    'B':  "best block",
+    'H':  "head blocks",
+}
+
+// Best Block (-show-tip)
+// ----------------------
+// The B value is the hash of the block the utxo set is at (obfuscated like every other value), so it says exactly which
+// block a dump is for:
+//
+//   B -> 6fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000 (stored little-endian, shown reversed)
+//
+// If bitcoind stopped in the middle of writing the utxos there's an H value as well, with the block it was going to
+// (first) and the block it was coming from. The utxo set is then a mix of the two, and bitcoind fixes it up when it next
+// starts, so the dump won't match either block.
+type chainTip struct {
+    best  string   // "" if there isn't a B key (e.g. a chainstate that's never had a block connected)
+    heads []string // from H, only there after an interrupted flush
+}
+
+func readChainTip(db *leveldb.DB, obfuscateKey []byte) (chainTip, error) {
+    var tip chainTip
+
+    value, err := db.Get([]byte{'B'}, nil)
+    if err != nil && err != leveldb.ErrNotFound {
+        return tip, fmt.Errorf("couldn't read the best block: %v", err)
+    }
+    if err == nil {
+        hash := btcleveldb.Deobfuscate(value, obfuscateKey)
+        if len(hash) != 32 {
+            return tip, fmt.Errorf("best block is %d bytes, it should be 32 (%x)", len(hash), hash)
+        }
+        tip.best = reversedHex(hash)
+    }
+
+    // H = count (1 byte, it's always 2) + 32 byte hashes
+    value, err = db.Get([]byte{'H'}, nil)
+    if err != nil && err != leveldb.ErrNotFound {
+        return tip, fmt.Errorf("couldn't read the head blocks: %v", err)
+    }
+    if err == nil {
+        heads := btcleveldb.Deobfuscate(value, obfuscateKey)
+        if len(heads) == 0 || len(heads) != 1+int(heads[0])*32 {
+            return tip, fmt.Errorf("head blocks aren't a list of hashes (%x)", heads)
+        }
+        for i := 1; i < len(heads); i += 32 {
+            tip.heads = append(tip.heads, reversedHex(heads[i:i+32]))
+        }
+    }
+    return tip, nil
+}
+
+// reversedHex shows a hash the way round it's usually shown (they're stored little-endian)
+func reversedHex(hash []byte) string {
+    reversed := make([]byte, len(hash))
+    for i := range hash {
+        reversed[i] = hash[len(hash)-1-i]
+    }
+    return hex.EncodeToString(reversed)
+}
+
+// Outpoint Bytes (outpoint_bin)
+// -----------------------------
+// The utxo key has the outpoint in it already, but with the vout as a varint. In a transaction input the outpoint is
+// always 36 bytes:
+//
+//   txid  32 bytes  in the order it's stored (the same as in the key), which is reversed from how txids are shown
+//   vout   4 bytes  uint32, little-endian
+//
+//   key 43 982051fd...57233e0e 01 -> 982051fd1e4ba744bbbe680e1fee14677ba1a3c3540bf7b1cdb606e857233e0e 01000000
+//   (txid 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098, vout 1)
+func outpointBytes(key []byte) []byte {
+    outpoint := make([]byte, 36)
+    copy(outpoint, key[1:33])
+    binary.LittleEndian.PutUint32(outpoint[32:], uint32(btcleveldb.Varint128Decode(key[33:])))
+    return outpoint
+}
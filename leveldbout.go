@@ -0,0 +1,95 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/syndtr/goleveldb/leveldb"
+import "github.com/syndtr/goleveldb/leveldb/opt"
+
+import "encoding/binary"
+import "encoding/hex"
+import "fmt"
+import "strconv"
+
+// LevelDB (-format leveldb)
+// -------------------------
+// Writes the utxos to a new LevelDB database at the -o path (a folder), without any obfuscation or compression, so it can
+// be read again quickly (or have other indexes built from it). Each utxo is one entry:
+//
+//   key    36 bytes  txid (32 bytes, in the byte order used inside transactions, so reversed from how txids are
+//                    shown) + vout (uint32, little-endian). The same as the outpoint in a transaction input.
+//   value  13 bytes  height (uint32, little-endian) + coinbase (1 byte, 0 or 1) + amount in satoshis (uint64,
+//                    little-endian), then the full scriptPubKey (the rest of the value)
+//
+// The database mustn't already exist (so the utxos don't get mixed in with something else). Whatever the -f fields are,
+// the fields needed for this get worked out, and any filters (e.g. -address) only let the utxos they match in.
+type levelDBWriter struct {
+    db        *leveldb.DB
+    batch     *leveldb.Batch
+    batchSize int
+}
+
+func newLevelDBWriter(path string, batchSize int) (*levelDBWriter, error) {
+    if batchSize <= 0 {
+        batchSize = 10000
+    }
+    db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfExist: true})
+    if err != nil {
+        return nil, fmt.Errorf("couldn't create leveldb at %s: %v", path, err)
+    }
+    return &levelDBWriter{db: db, batch: new(leveldb.Batch), batchSize: batchSize}, nil
+}
+
+func (l *levelDBWriter) Header(fields []string) error {
+    return nil // no header, every entry has the same layout
+}
+
+func (l *levelDBWriter) Row(output map[string]string) error {
+    txid, err := hex.DecodeString(output["txid"])
+    if err != nil || len(txid) != 32 {
+        return fmt.Errorf("leveldb: bad txid %q", output["txid"])
+    }
+    vout, _ := strconv.ParseUint(output["vout"], 10, 32)
+    height, _ := strconv.ParseUint(output["height"], 10, 32)
+    amount, _ := strconv.ParseUint(output["amount"], 10, 64)
+    nsize, _ := strconv.Atoi(output["nsize"])
+    script, _ := hex.DecodeString(output["script"])
+    full, ok := btcleveldb.DecompressScript(nsize, script)
+    if !ok {
+        return fmt.Errorf("leveldb: couldn't rebuild the script for %s:%s", output["txid"], output["vout"])
+    }
+
+    key := make([]byte, 36)
+    for i := range txid { // (shown reversed, so reverse it back)
+        key[i] = txid[31-i]
+    }
+    binary.LittleEndian.PutUint32(key[32:], uint32(vout))
+
+    value := make([]byte, 13, 13+len(full))
+    binary.LittleEndian.PutUint32(value[0:4], uint32(height))
+    if output["coinbase"] == "1" {
+        value[4] = 1
+    }
+    binary.LittleEndian.PutUint64(value[5:13], amount)
+    value = append(value, full...)
+
+    l.batch.Put(key, value)
+    if l.batch.Len() >= l.batchSize {
+        return l.flush()
+    }
+    return nil
+}
+
+func (l *levelDBWriter) flush() error {
+    if err := l.db.Write(l.batch, nil); err != nil {
+        return fmt.Errorf("leveldb: %v", err)
+    }
+    l.batch.Reset()
+    return nil
+}
+
+func (l *levelDBWriter) Close() error {
+    err := l.flush()
+    if closeErr := l.db.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import "io"
+import "log/slog" // structured (json) logging
+import "os"
+
+// Log File (-log)
+// ---------------
+// Writes a json line for each notable event (db opened, obfuscate key found, checkpoints, errors, final stats) to a separate
+// file, so it doesn't get mixed up with the results or the progress printed to the terminal. For example:
+//
+//   {"time":"2024-01-01T12:00:00Z","level":"INFO","msg":"checkpoint","utxos":100000}
+//
+// -v turns on DEBUG level as well, which logs every utxo as it gets decoded (the output file stays the same).
+// If there's no -log file, all the events just get thrown away.
+func newLogger(file string, verbose bool) (*slog.Logger, io.Closer, error) {
+    if file == "" {
+        return slog.New(slog.NewJSONHandler(io.Discard, nil)), io.NopCloser(nil), nil
+    }
+
+    f, err := os.Create(file)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    level := slog.LevelInfo
+    if verbose {
+        level = slog.LevelDebug
+    }
+    return slog.New(slog.NewJSONHandler(f, &slog.HandlerOptions{Level: level})), f, nil
+}
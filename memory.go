@@ -0,0 +1,78 @@
+package main
+
+import "fmt"
+import "log/slog"
+import "runtime"
+import "runtime/debug" // soft memory limit for the garbage collector
+
+// Memory Limit (-max-memory)
+// --------------------------
+// Some of the options keep things in memory for the whole scan (every address for -count-addresses, every row for -sort),
+// and on mainnet that can be enough to get the process killed. With -max-memory the heap gets checked every 10,000
+// entries, and when it goes over 80% of the limit each of these switches to a version that uses less memory (and says so):
+//
+//   -sort                          the rows collected so far get written out to a temp file as a sorted run, and the runs
+//                                  are kept to that size from then on (so the memory used for the sort stops growing), but
+//                                  never less than 100,000 rows (see minSpillRun)
+//   -count-addresses, reused       an exact set is swapped for a bloom filter (at -bloom-fp), so the counts become
+//                                  approximate (they can come out a little low)
+//   -detect-duplicates full        an exact set is swapped for a bloom filter, so duplicates found after that are only
+//                                  possible duplicates (and the first key isn't known)
+//
+// Each one only switches once. The limit is also given to the garbage collector (as a soft limit), so it works harder to
+// stay under it. Nothing else changes, so it's a guide rather than a hard cap: anything that isn't in the list above
+// (e.g. -format grouped-json, xlsx, or importdescriptors, which have to keep everything until the end) can still go over.
+type memoryGuard struct {
+    limit     uint64 // bytes
+    fallbacks []memoryFallback
+    warned    bool // still over the limit after everything has switched
+    logger    *slog.Logger
+}
+
+type memoryFallback struct {
+    name     string
+    fallback func() error // switches to the version that uses less memory
+}
+
+// switch over at this much of the limit, to leave some room for the memory the switch itself uses (e.g. the bloom filter)
+const memoryThreshold = 0.8
+
+func newMemoryGuard(megabytes int, logger *slog.Logger) *memoryGuard {
+    limit := uint64(megabytes) * 1000000
+    debug.SetMemoryLimit(int64(limit))
+    return &memoryGuard{limit: limit, logger: logger}
+}
+
+// add something that can switch to using less memory when the limit is getting close
+func (m *memoryGuard) add(name string, fallback func() error) {
+    m.fallbacks = append(m.fallbacks, memoryFallback{name: name, fallback: fallback})
+}
+
+// check the heap, and switch everything over if it's getting close to the limit
+func (m *memoryGuard) check() error {
+    var stats runtime.MemStats
+    runtime.ReadMemStats(&stats)
+    if float64(stats.HeapAlloc) < memoryThreshold*float64(m.limit) {
+        return nil
+    }
+
+    if len(m.fallbacks) == 0 {
+        if !m.warned {
+            m.warned = true
+            fmt.Printf("Memory: %d MB used, close to the -max-memory of %d MB (and nothing left that can use less)\n", stats.HeapAlloc/1000000, m.limit/1000000)
+            m.logger.Warn("memory limit", "heap_bytes", stats.HeapAlloc, "limit_bytes", m.limit)
+        }
+        return nil
+    }
+
+    for _, f := range m.fallbacks {
+        fmt.Printf("Memory: %d MB used, so %s (-max-memory %d MB)\n", stats.HeapAlloc/1000000, f.name, m.limit/1000000)
+        m.logger.Warn("memory fallback", "fallback", f.name, "heap_bytes", stats.HeapAlloc, "limit_bytes", m.limit)
+        if err := f.fallback(); err != nil {
+            return err
+        }
+    }
+    m.fallbacks = nil
+    runtime.GC() // (give the memory that's been freed back straight away, instead of switching and still being over)
+    return nil
+}
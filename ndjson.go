@@ -0,0 +1,58 @@
+package main
+
+import "bufio"
+import "encoding/json" // escaping strings
+
+// Typed NDJSON (-format ndjson-typed)
+// -----------------------------------
+// One json object per line for each utxo, where every value comes with a tag saying what type it is, so that loaders
+// that guess the schema from the data don't get it wrong (e.g. reading a txid of all digits as a number):
+//
+//   {"txid":{"t":"str","v":"0e3e2357..."},"vout":{"t":"i64","v":1},"amount":{"t":"i64","v":546}}
+//
+// The type tags are:
+//
+//   i64 = 64-bit signed integer (the v is a json number, or null if the field is empty)
+//   str = string (the v is a json string)
+type typedJSONWriter struct {
+    w       *bufio.Writer
+    fields  []string
+    keys    []string // field names already escaped (they're the same for every row)
+    newline string
+}
+
+func (t *typedJSONWriter) Header(fields []string) error {
+    t.fields = fields
+    t.keys = make([]string, len(fields))
+    for i, v := range fields {
+        key, _ := json.Marshal(v)
+        t.keys[i] = string(key)
+    }
+    return nil // no header, every object has its own keys
+}
+
+func (t *typedJSONWriter) Row(output map[string]string) error {
+    line := "{"
+    for i, v := range t.fields {
+        if i > 0 {
+            line += ","
+        }
+        line += t.keys[i] + ":"
+        if fieldTypes[v] == "int" {
+            value := output[v]
+            if value == "" {
+                value = "null"
+            }
+            line += `{"t":"i64","v":` + value + `}`
+        } else {
+            value, _ := json.Marshal(output[v])
+            line += `{"t":"str","v":` + string(value) + `}`
+        }
+    }
+    _, err := t.w.WriteString(line + "}" + t.newline)
+    return err
+}
+
+func (t *typedJSONWriter) Close() error {
+    return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import "fmt"
+import "strconv"
+import "strings"
+
+// Network Parameters (-network-params)
+// ------------------
+// The prefixes that go on the front of addresses for each network. Lots of other coins forked from bitcoin use the same
+// chainstate format with their own prefixes, so they can be set with -network-params (e.g. 'p2pkh=0x3a,p2sh=0x32,hrp=qc').
+type networkParams struct {
+    p2pkh byte   // base58 prefix for p2pkh addresses (e.g. 0x00 = 1address)
+    p2sh  byte   // base58 prefix for p2sh addresses (e.g. 0x05 = 3address)
+    hrp   string // human readable part for segwit addresses (e.g. bc = bc1address)
+}
+
+var mainnetParams = networkParams{p2pkh: 0x00, p2sh: 0x05, hrp: "bc"}
+var testnetParams = networkParams{p2pkh: 0x6f, p2sh: 0xc4, hrp: "tb"} // (m/n)address, 2address, tb1address
+
+// Networks (-network)
+// --------
+// Each network Bitcoin Core runs on keeps its chainstate in its own folder inside the data directory (see datadir.go).
+// testnet3 and testnet4 use the same address prefixes, so it's only the folder that's different.
+type network struct {
+    testnet bool     // uses the testnet address prefixes
+    dirs    []string // where to look for the chainstate in the -datadir (the first one that's there gets used)
+}
+
+var networks = map[string]network{
+    "mainnet":  {testnet: false, dirs: []string{"chainstate"}},
+    "testnet":  {testnet: true, dirs: []string{"testnet3/chainstate", "testnet4/chainstate"}}, // (-testnet, whichever one there is)
+    "testnet3": {testnet: true, dirs: []string{"testnet3/chainstate"}},
+    "testnet4": {testnet: true, dirs: []string{"testnet4/chainstate"}},
+}
+
+// parseNetworkParams changes the given params with a list of overrides (e.g. p2pkh=0x3a,p2sh=0x32,hrp=qc)
+func parseNetworkParams(params networkParams, overrides string) (networkParams, error) {
+    for _, override := range strings.Split(overrides, ",") {
+        name, value, ok := strings.Cut(strings.TrimSpace(override), "=")
+        if !ok {
+            return params, fmt.Errorf("-network-params %s needs to be name=value (e.g. p2pkh=0x3a)", override)
+        }
+        switch name {
+        case "p2pkh", "p2sh":
+            prefix, err := strconv.ParseUint(value, 0, 8) // 0x3a or 58
+            if err != nil {
+                return params, fmt.Errorf("-network-params %s needs to be a single byte (e.g. 0x3a)", override)
+            }
+            if name == "p2pkh" {
+                params.p2pkh = byte(prefix)
+            } else {
+                params.p2sh = byte(prefix)
+            }
+        case "hrp":
+            // bip-173: 1 to 83 characters in the range 33-126 (and we only use lowercase)
+            if len(value) < 1 || len(value) > 83 || strings.ToLower(value) != value {
+                return params, fmt.Errorf("-network-params %s needs to be 1 to 83 lowercase characters", override)
+            }
+            for _, c := range value {
+                if c < 33 || c > 126 {
+                    return params, fmt.Errorf("-network-params %s has a character that can't be used in a segwit address", override)
+                }
+            }
+            params.hrp = value
+        default:
+            return params, fmt.Errorf("'%s' is not a network parameter you can set. Choose from the following: p2pkh,p2sh,hrp", name)
+        }
+    }
+    return params, nil
+}
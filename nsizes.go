@@ -0,0 +1,63 @@
+package main
+
+import "fmt"
+
+// NSize Counts
+// ------------
+// Counts how many utxos have each nsize (the byte at the start of the script in the chainstate that says how it was
+// compressed), to show how much of the utxo set is using each kind of script compression:
+//
+//   0      = P2PKH (20 byte hash160)
+//   1      = P2SH (20 byte hash160)
+//   2-5    = P2PK (33 byte compressed public key, 4 and 5 are uncompressed public keys stored compressed)
+//   6+     = the full script, nsize - 6 bytes long (split up in to ranges below)
+//
+// The full scripts are grouped by length, with the two common segwit lengths on their own (28 = 22 byte script for
+// P2WPKH, 40 = 34 byte script for P2WSH and P2TR).
+type nsizeBucket struct {
+    name string
+    max  int // nsizes up to and including this go in this bucket (the last bucket has no maximum)
+}
+
+var nsizeBuckets = []nsizeBucket{
+    {"0", 0},
+    {"1", 1},
+    {"2-5", 5},
+    {"6-27", 27},
+    {"28", 28},
+    {"29-39", 39},
+    {"40", 40},
+    {"41-111", 111}, // (up to 105 byte scripts, e.g. 3 compressed keys in a bare multisig)
+    {"112+", 0},
+}
+
+type nsizeHistogram struct {
+    counts []int
+}
+
+func newNsizeHistogram() *nsizeHistogram {
+    return &nsizeHistogram{counts: make([]int, len(nsizeBuckets))}
+}
+
+func (h *nsizeHistogram) add(nsize int) {
+    b := len(nsizeBuckets) - 1
+    for i, bucket := range nsizeBuckets[:b] {
+        if nsize <= bucket.max {
+            b = i
+            break
+        }
+    }
+    h.counts[b]++
+}
+
+// print the count in each bucket, with the share of all the utxos counted
+func (h *nsizeHistogram) print(human humanFormat) {
+    total := 0
+    for _, n := range h.counts {
+        total += n
+    }
+    fmt.Println("NSizes:")
+    for i, bucket := range nsizeBuckets {
+        fmt.Printf(" %-12s %10s utxos (%6.2f%%)\n", bucket.name, human.count(h.counts[i]), percent(h.counts[i], total))
+    }
+}
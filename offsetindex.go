@@ -0,0 +1,81 @@
+package main
+
+import "bufio"
+import "encoding/hex"
+import "fmt"
+import "io"
+import "os"
+
+// Offset Index (-offset-index)
+// ----------------------------
+// Writes a txid,offset line for each transaction, where offset is the byte in the output file that its first row starts
+// at. The chainstate is sorted by txid, so all the outputs for a transaction are next to each other in the output too,
+// which means something reading the file can seek straight to a transaction's rows instead of scanning the whole file:
+//
+//   txid,offset
+//   0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098,32
+//   4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b,123
+//
+// This only works for the formats that write each utxo as it comes (csv, template, json, cbor and ndjson-typed).
+type offsetIndex struct {
+    f       *os.File
+    w       *bufio.Writer
+    out     *countingWriter // the output file (to know how many bytes have been written to it)
+    buffer  *bufio.Writer   // the output file's buffer (bytes in here haven't reached the file yet)
+    lastKey []byte          // txid (as it is in the key) of the last row
+}
+
+// countingWriter counts the bytes that get written through it
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.n += int64(n)
+    return n, err
+}
+
+func newOffsetIndex(file string, out *countingWriter, buffer *bufio.Writer) (*offsetIndex, error) {
+    f, err := os.Create(file)
+    if err != nil {
+        return nil, err
+    }
+    x := &offsetIndex{f: f, w: bufio.NewWriter(f), out: out, buffer: buffer}
+    if _, err := fmt.Fprintln(x.w, "txid,offset"); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return x, nil
+}
+
+// offset is where the next byte written to the output will end up in the file
+func (x *offsetIndex) offset() int64 {
+    return x.out.n + int64(x.buffer.Buffered())
+}
+
+// record is called just before a row gets written, and adds a line to the index if it's the start of a new txid
+func (x *offsetIndex) record(key []byte) error {
+    txidKey := key[1:33]
+    if x.lastKey != nil && string(txidKey) == string(x.lastKey) { // (string comparison doesn't allocate)
+        return nil
+    }
+    x.lastKey = append(x.lastKey[:0], txidKey...) // copy (the iterator reuses the key's memory)
+
+    // the txid is stored little-endian in the key, so reverse it to get the txid people use (same as the txid field)
+    txid := make([]byte, len(txidKey))
+    for i := range txidKey {
+        txid[i] = txidKey[len(txidKey)-1-i]
+    }
+    _, err := fmt.Fprintf(x.w, "%s,%d\n", hex.EncodeToString(txid), x.offset())
+    return err
+}
+
+func (x *offsetIndex) Close() error {
+    if err := x.w.Flush(); err != nil {
+        x.f.Close()
+        return err
+    }
+    return x.f.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import "github.com/syndtr/goleveldb/leveldb"
+import "github.com/syndtr/goleveldb/leveldb/opt"
+import "github.com/syndtr/goleveldb/leveldb/storage"
+
+import "errors"
+import "fmt"
+import "log/slog"
+import "syscall" // lock errors
+import "time"
+
+// Open LevelDB (-open-retries, -open-retry-delay)
+// -------------
+// If bitcoind has only just been stopped, the LOCK file in the chainstate folder can still be held for a moment, so opening
+// the database fails straight away. Lock errors are worth waiting for, so we try again a few times (doubling the delay each
+// time). Anything else (e.g. a corrupted database or a wrong path) isn't going to fix itself, so that gets returned straight away.
+func openDB(path string, opts *opt.Options, force bool, retries int, delay time.Duration, logger *slog.Logger) (*leveldb.DB, error) {
+    for attempt := 0; ; attempt++ {
+        var db *leveldb.DB
+        var err error
+        if opts.ReadOnly {
+            db, err = openReadOnly(path, opts, force) // -readonly
+        } else {
+            db, err = leveldb.OpenFile(path, opts)
+        }
+        if err == nil || !isLockError(err) || attempt >= retries {
+            return db, err
+        }
+
+        fmt.Printf("LevelDB is locked (is bitcoind still shutting down?), trying again in %s...\n", delay)
+        logger.Warn("db locked, retrying", "path", path, "attempt", attempt+1, "delay", delay.String(), "error", err.Error())
+        time.Sleep(delay)
+        delay *= 2 // backoff
+    }
+}
+
+// leveldbOptions are the options the chainstate gets opened with (-readonly, -max-open-files, -block-cache,
+// -disable-block-cache, -iterator-sampling-rate)
+func leveldbOptions(readOnly bool, maxOpenFiles int, blockCache int, disableBlockCache bool, iteratorSamplingRate int) *opt.Options {
+    opts := &opt.Options{
+        Compression: opt.NoCompression,
+        ErrorIfMissing: true, // don't create an empty database if the folder isn't a chainstate
+        OpenFilesCacheCapacity: maxOpenFiles, // 0 uses the goleveldb default (500)
+        ReadOnly: readOnly, // -readonly (see readonly.go)
+        BlockCacheCapacity: blockCache * opt.MiB, // 0 uses the goleveldb default (8 MB)
+        DisableBlockCache: disableBlockCache,
+        IteratorSamplingRate: iteratorSamplingRate, // 0 uses the goleveldb default (1 MB)
+    }
+    if blockCache < 0 {
+        opts.BlockCacheCapacity = -1 // (no cache)
+    }
+    return opts
+}
+
+// isLockError tells us if the database couldn't be opened because something else has the LOCK file
+func isLockError(err error) bool {
+    return errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, storage.ErrLocked) // flock returns EWOULDBLOCK if another process has the lock
+}
+
+// isTooManyFilesError tells us if we've run out of file descriptors (LevelDB keeps lots of .ldb files open at the same time)
+func isTooManyFilesError(err error) bool {
+    return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// tooManyFilesHelp explains what to do if we've hit the limit on open files
+const tooManyFilesHelp = "Too many open files. Either raise the limit (e.g. ulimit -n 4096) or use a lower -max-open-files."
@@ -0,0 +1,54 @@
+package main
+
+import "github.com/syndtr/goleveldb/leveldb"
+
+import "fmt"
+import "testing"
+
+// The scan with the leveldb tuning flags (-block-cache, -disable-block-cache, -iterator-sampling-rate, with and without
+// -readonly), e.g.
+//
+//   go test -bench LevelDBOptions
+//
+// The test database is written to TMPDIR, so set that to somewhere on the same disk as your chainstate. It'll probably be
+// in the page cache after the first run, so this mostly shows the cost of the options themselves and not the disk.
+func BenchmarkLevelDBOptions(b *testing.B) {
+    const entries = 50000
+    path := newBenchmarkChainstate(b, entries)
+
+    caches := []struct {
+        name    string
+        size    int
+        disable bool
+    }{
+        {"block-cache=default", 0, false},
+        {"block-cache=64", 64, false},
+        {"disable-block-cache", 0, true},
+    }
+    for _, readOnly := range []bool{false, true} {
+        for _, cache := range caches {
+            for _, samplingRate := range []int{0, 64 * 1024, 16 * 1024 * 1024} {
+                b.Run(fmt.Sprintf("readonly=%v/%s/iterator-sampling-rate=%d", readOnly, cache.name, samplingRate), func(b *testing.B) {
+                    opts := leveldbOptions(readOnly, 0, cache.size, cache.disable, samplingRate)
+                    var db *leveldb.DB
+                    var err error
+                    if readOnly {
+                        db, err = openReadOnly(path, opts, false)
+                    } else {
+                        db, err = leveldb.OpenFile(path, opts)
+                    }
+                    if err != nil {
+                        b.Fatal(err)
+                    }
+                    defer db.Close()
+                    b.ResetTimer()
+                    for i := 0; i < b.N; i++ {
+                        if n := benchmarkScan(b, db, 0, 0); n != entries {
+                            b.Fatalf("scanned %d entries, want %d", n, entries)
+                        }
+                    }
+                })
+            }
+        }
+    }
+}
@@ -0,0 +1,208 @@
+package main
+
+import "bufio"   // buffered writer that every output format writes through
+import "encoding/json" // escaping strings in json output
+import "fmt"
+import "strings" // joining fields in to csv lines
+import "text/template" // -format template
+
+// Output Formats
+// --------------
+// Each -format option has a rowWriter that takes the output map for each utxo and writes it to the file.
+type rowWriter interface {
+    Header(fields []string) error            // called once before the first row
+    Row(output map[string]string) error      // called once per utxo
+    Close() error                            // write anything still buffered (does not close the file)
+}
+
+// The type of each field, so that typed formats (e.g. arrow) know how to store them.
+// Any field not in this map is stored as a string. The decimal ones are stored as strings too (they can have more digits
+// than a float can hold exactly, and coindays can be too big for an int64), but get sorted as numbers (-sort).
+var fieldTypes = map[string]string{
+    "count":              "int",
+    "vout":               "int",
+    "height":             "int",
+    "coinbase":           "int",
+    "amount":             "int",
+    "nsize":              "int",
+    "sweepable":          "int",
+    "epoch":              "int",
+    "block_subsidy":      "int",
+    "reused":             "int",
+    "value_len":          "int",
+    "height_code":        "int",
+    "amount_exp":         "int",
+    "amount_mantissa":    "int",
+    "witness_future":     "int",
+    "tx_output_index":    "int",
+    "tx_output_count":    "int",
+    "amount_compressed":  "int",
+    "hash_leading_zeros": "int",
+    "amount_btc":         "decimal",
+    "value_usd":          "decimal",
+    "dust_ratio":         "decimal",
+    "coindays":           "decimal",
+}
+
+// csv (default)
+type csvWriter struct {
+    w          *bufio.Writer
+    fields     []string
+    newline    string
+    skipHeader bool
+}
+
+func (c *csvWriter) Header(fields []string) error {
+    c.fields = fields
+    if c.skipHeader {
+        return nil
+    }
+    _, err := fmt.Fprint(c.w, strings.Join(fields, ","), c.newline) // count,txid,vout,...
+    return err
+}
+
+func (c *csvWriter) Row(output map[string]string) error {
+    _, err := fmt.Fprint(c.w, csvLine(output, c.fields), c.newline)
+    return err
+}
+
+func (c *csvWriter) Close() error {
+    return nil
+}
+
+// csvLine builds a line of output from the given fields (also used for printing results to the terminal)
+func csvLine(output map[string]string, fields []string) string {
+    csvline := ""
+    for _, v := range fields {
+        csvline += output[v]
+        csvline += ","
+    }
+    return csvline[:len(csvline)-1] // remove trailing ,
+}
+
+// formatBTC converts satoshis to a BTC amount with 8 decimal places (e.g. 546 to "0.00000546")
+// This uses integer division instead of a float, so that big amounts don't pick up any rounding errors.
+func formatBTC(satoshis int) string {
+    sign := ""
+    if satoshis < 0 {
+        sign = "-"
+        satoshis = -satoshis
+    }
+    return fmt.Sprintf("%s%d.%08d", sign, satoshis / 100000000, satoshis % 100000000)
+}
+
+// jsonObject builds a json object from the given fields (keeping them in the same order as -f)
+// int fields are written as numbers (or null if they're empty, e.g. hash_leading_zeros for a p2pk), everything else as strings
+func jsonObject(output map[string]string, fields []string) string {
+    object := "{"
+    for i, v := range fields {
+        if i > 0 {
+            object += ","
+        }
+        key, _ := json.Marshal(v)
+        object += string(key) + ":"
+        if fieldTypes[v] == "int" {
+            if output[v] == "" {
+                object += "null"
+            } else {
+                object += output[v]
+            }
+        } else {
+            value, _ := json.Marshal(output[v])
+            object += string(value)
+        }
+    }
+    return object + "}"
+}
+
+// template (a Go text/template executed for each utxo, with the output map as its data, e.g. {{.txid}}:{{.vout}})
+type templateWriter struct {
+    w       *bufio.Writer
+    tmpl    *template.Template
+    newline string
+}
+
+func (t *templateWriter) Header(fields []string) error {
+    return nil // no header, the template is the whole line
+}
+
+func (t *templateWriter) Row(output map[string]string) error {
+    if err := t.tmpl.Execute(t.w, output); err != nil {
+        return err
+    }
+    _, err := t.w.WriteString(t.newline)
+    return err
+}
+
+func (t *templateWriter) Close() error {
+    return nil
+}
+
+// Options for the output formats (from the command line flags)
+type outputOptions struct {
+    batchSize       int    // -batch-size
+    template        string // -template
+    table           string // -table
+    maxRows         int    // -xlsx-max-rows
+    crlf            bool   // -crlf
+    redisAddr       string // -redis-addr
+    redisSet        string // -redis-address-set
+    importTimestamp string // -import-timestamp
+    skipHeader      bool   // the header is already in the file (-append)
+    jsonScript      bool   // -json-script
+    path            string // -o (the folder for -format leveldb)
+    groupBy         string // -group-by (for -format grouped-json)
+}
+
+// newRowWriter returns the rowWriter for the given -format
+func newRowWriter(format string, w *bufio.Writer, options outputOptions) (rowWriter, error) {
+    newline := "\n" // line endings for the text formats (the binary formats don't have lines)
+    if options.crlf {
+        newline = "\r\n"
+    }
+
+    switch format {
+    case "csv":
+        return &csvWriter{w: w, newline: newline, skipHeader: options.skipHeader}, nil
+    case "arrow":
+        return &arrowWriter{w: w, batchSize: options.batchSize}, nil
+    case "template":
+        if options.template == "" {
+            return nil, fmt.Errorf("-format template needs a -template to execute for each utxo (e.g. -template '{{.txid}}:{{.vout}}')")
+        }
+        tmpl, err := template.New("utxo").Option("missingkey=zero").Parse(options.template) // parse once at the start
+        if err != nil {
+            return nil, fmt.Errorf("couldn't parse -template: %v", err)
+        }
+        return &templateWriter{w: w, tmpl: tmpl, newline: newline}, nil
+    case "json":
+        return &jsonWriter{w: w, nestScript: options.jsonScript, newline: newline}, nil
+    case "grouped-json":
+        if options.groupBy == "address" {
+            return &groupedJSONWriter{w: w, by: "address", totals: true, newline: newline}, nil
+        }
+        return &groupedJSONWriter{w: w, by: "txid", newline: newline}, nil
+    case "sql-insert":
+        if options.table == "" {
+            return nil, fmt.Errorf("-format sql-insert needs a -table to insert in to")
+        }
+        return &sqlWriter{w: w, table: options.table, batchSize: options.batchSize, newline: newline}, nil
+    case "xlsx":
+        return &xlsxWriter{w: w, maxRows: options.maxRows}, nil
+    case "cbor":
+        return &cborWriter{w: w}, nil
+    case "ndjson-typed":
+        return &typedJSONWriter{w: w, newline: newline}, nil
+    case "redis":
+        return newRedisWriter(w, options.redisAddr, options.redisSet, options.batchSize)
+    case "importdescriptors":
+        return newImportDescriptorsWriter(w, options.importTimestamp)
+    case "amount-map":
+        return &amountMapWriter{w: w, newline: newline}, nil
+    case "amount-map-binary":
+        return &amountMapWriter{w: w, binary: true}, nil
+    case "leveldb":
+        return newLevelDBWriter(options.path, options.batchSize)
+    }
+    return nil, fmt.Errorf("'%s' is not an output format you can use. Choose from the following: csv,arrow,template,json,grouped-json,sql-insert,xlsx,cbor,ndjson-typed,redis,importdescriptors,amount-map,amount-map-binary,leveldb", format)
+}
@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+import "path/filepath"
+import "strings"
+
+// Output Path Check
+// -----------------
+// Everything else is careful not to write to the chainstate folder, so make sure the output files don't end up in there
+// either (e.g. -o ~/.bitcoin/chainstate/utxos.csv), where they could get mixed up with the database files.
+func checkOutsideChainstate(chainstate string, files map[string]string) error {
+    dir := resolvePath(chainstate)
+    for flagName, file := range files {
+        if file == "" {
+            continue
+        }
+        rel, err := filepath.Rel(dir, resolvePath(file))
+        if err != nil {
+            continue // (e.g. on a different drive on windows, so can't be inside it)
+        }
+        if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))) {
+            return fmt.Errorf("-%s %s is inside the chainstate folder (%s). Write it somewhere else so the database doesn't get touched.", flagName, file, chainstate)
+        }
+    }
+    return nil
+}
+
+// resolvePath gets the absolute path with any symlinks followed, so two different ways of getting to the same folder match
+// (the file itself doesn't need to exist yet, only the folder it's going in)
+func resolvePath(path string) string {
+    abs, err := filepath.Abs(path)
+    if err != nil {
+        return filepath.Clean(path)
+    }
+    if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+        return resolved
+    }
+    if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+        return filepath.Join(dir, filepath.Base(abs))
+    }
+    return abs
+}
@@ -0,0 +1,87 @@
+package main
+
+import "encoding/hex"
+import "fmt"
+import "log/slog"
+import "runtime/debug" // stack trace for -debug
+
+// Panic Recovery (-max-panics, -strict)
+// -------------------------------------
+// A malformed entry (e.g. a value that's been cut short, so the script is shorter than the nsize says) can make the
+// decode index past the end of a slice and panic, and without this that one entry would kill a scan that might have been
+// running for half an hour. So each utxo gets decoded inside a recover(): a panic is printed (the first one) and logged
+// with the key of the entry, the entry is skipped, and the scan carries on with the next one.
+//
+// If lots of entries are panicking it's more likely to be a bug (or the wrong database) than a few bad entries, so after
+// -max-panics of them the scan stops instead (-1 for no limit).
+//
+// This only covers the decode in the main loop. With -parallel-encode the addresses get encoded in other goroutines,
+// and a panic there still stops the program.
+//
+// The decoder also spots some malformed entries itself without panicking (e.g. a varint that runs off the end of the
+// value), and these get skipped (and counted) the same way. With -strict the scan stops at the first malformed entry
+// (or panic) instead.
+type panicGuard struct {
+    max            int          // -max-panics
+    count          int          // entries skipped because they panicked
+    strict         bool         // stop at the first malformed entry (-strict)
+    malformedCount int          // entries skipped because they were malformed
+    stack          bool         // print the stack trace of each panic (-debug)
+    trace          *decodeTrace // print everything about each entry that fails (-verbose-errors)
+    logger         *slog.Logger
+}
+
+func newPanicGuard(max int, strict bool, stack bool, logger *slog.Logger) *panicGuard {
+    return &panicGuard{max: max, strict: strict, stack: stack, logger: logger}
+}
+
+// malformed is for an entry the decoder couldn't make sense of. Returns nil so the scan skips it, or the error with -strict
+func (g *panicGuard) malformed(key []byte, value []byte, err error) error {
+    g.malformedCount++
+    g.logger.Error("malformed entry", "key", hex.EncodeToString(key), "value", hex.EncodeToString(value), "error", err.Error())
+    if g.strict {
+        fmt.Printf("Malformed entry %x: %v (stopping, -strict)\n", key, err)
+    } else if g.malformedCount == 1 {
+        fmt.Printf("Malformed entry %x: %v (skipped)\n", key, err)
+    }
+    if g.trace != nil {
+        g.trace.report(key, value, err.Error(), g.logger)
+    }
+    if g.strict {
+        return err
+    }
+    return nil
+}
+
+// run decodes the entry, and if it panics returns nil so the scan skips it (or an error once there have been too many)
+func (g *panicGuard) run(decode func(key []byte, value []byte) error, key []byte, value []byte) (err error) {
+    defer func() {
+        r := recover()
+        if r == nil {
+            return
+        }
+        g.count++
+        stop := g.max >= 0 && g.count > g.max
+        if g.count == 1 || g.stack || stop {
+            if stop {
+                fmt.Printf("Panic decoding %x: %v\n", key, r)
+            } else {
+                fmt.Printf("Panic decoding %x: %v (skipped)\n", key, r)
+            }
+        }
+        if g.stack {
+            fmt.Printf("%s\n", debug.Stack())
+        }
+        g.logger.Error("panic decoding entry", "key", hex.EncodeToString(key), "value", hex.EncodeToString(value), "panic", fmt.Sprint(r))
+        if g.trace != nil {
+            g.trace.report(key, value, fmt.Sprint(r), g.logger)
+        }
+
+        if stop {
+            err = fmt.Errorf("too many entries panicked (more than -max-panics %d), stopping", g.max)
+            fmt.Println(err)
+            g.logger.Error("too many panics", "panics", g.count, "max", g.max)
+        }
+    }()
+    return decode(key, value)
+}
@@ -0,0 +1,136 @@
+package main
+
+// Prefetch (-prefetch)
+// --------------------
+// Reading from leveldb and decoding the utxos normally take turns in the main loop, so while an entry is being decoded
+// nothing is being read (and while the disk is busy nothing is being decoded). With -prefetch a goroutine of its own
+// reads ahead and copies the entries in to batches, and the main loop takes them off a channel that holds up to
+// -prefetch batches:
+//
+//   iterator --> reader goroutine --> [batch][batch][batch]... (-prefetch) --> main loop --> -parallel-encode
+//
+// The batches go back to the reader once the main loop has finished with them, so the same few buffers get used over
+// and over. The key and value are only good until the next entry, the same as they are straight from the iterator.
+type prefetcher struct {
+    batches chan *prefetchBatch
+    free    chan *prefetchBatch // batches the main loop has finished with
+    stop    chan struct{}
+    done    chan struct{}       // closed when the reader has finished
+    err     error               // from the iterator (only read after done)
+    batch   *prefetchBatch      // the batch the main loop is on
+    pos     int
+}
+
+type prefetchBatch struct {
+    data    []byte   // the keys and values, one after the other
+    entries [][2]int // start of the key, and start of the value (each one ends where the next begins)
+}
+
+const prefetchBatchSize = 1000 // entries in each batch
+
+// entryIterator is what the main loop needs from the leveldb iterator (or the prefetcher)
+type entryIterator interface {
+    Key() []byte
+    Value() []byte
+    Error() error
+}
+
+func newPrefetcher(depth int, first func() bool, next func() bool, iter entryIterator) *prefetcher {
+    p := &prefetcher{
+        batches: make(chan *prefetchBatch, depth),
+        free:    make(chan *prefetchBatch, depth+2), // (every batch there can be: the ones in the channel, the reader's, and the main loop's)
+        stop:    make(chan struct{}),
+        done:    make(chan struct{}),
+    }
+
+    go func() {
+        defer close(p.done)
+        defer close(p.batches)
+        batch := p.newBatch()
+        for ok := first(); ok; ok = next() {
+            start := len(batch.data)
+            batch.data = append(batch.data, iter.Key()...)
+            batch.entries = append(batch.entries, [2]int{start, len(batch.data)})
+            batch.data = append(batch.data, iter.Value()...)
+
+            if len(batch.entries) == prefetchBatchSize {
+                if !p.send(batch) {
+                    return
+                }
+                batch = p.newBatch()
+            }
+        }
+        p.err = iter.Error()
+        if len(batch.entries) > 0 {
+            p.send(batch)
+        }
+    }()
+    return p
+}
+
+// newBatch gets an empty batch, reusing one the main loop has finished with if there is one
+func (p *prefetcher) newBatch() *prefetchBatch {
+    select {
+    case batch := <-p.free:
+        batch.data = batch.data[:0]
+        batch.entries = batch.entries[:0]
+        return batch
+    default:
+        return &prefetchBatch{}
+    }
+}
+
+// send a full batch to the main loop (returns false if we've been told to stop)
+func (p *prefetcher) send(batch *prefetchBatch) bool {
+    select {
+    case p.batches <- batch:
+        return true
+    case <-p.stop:
+        return false
+    }
+}
+
+// next moves on to the next entry (the same as iter.Next)
+func (p *prefetcher) next() bool {
+    p.pos++
+    if p.batch != nil && p.pos < len(p.batch.entries) {
+        return true
+    }
+    if p.batch != nil {
+        select {
+        case p.free <- p.batch:
+        default:
+        }
+    }
+    batch, ok := <-p.batches
+    if !ok {
+        p.batch = nil
+        return false
+    }
+    p.batch, p.pos = batch, 0
+    return true
+}
+
+func (p *prefetcher) Key() []byte {
+    start, end := p.batch.entries[p.pos][0], p.batch.entries[p.pos][1]
+    return p.batch.data[start:end:end]
+}
+
+func (p *prefetcher) Value() []byte {
+    start, end := p.batch.entries[p.pos][1], len(p.batch.data)
+    if p.pos+1 < len(p.batch.entries) {
+        end = p.batch.entries[p.pos+1][0]
+    }
+    return p.batch.data[start:end:end]
+}
+
+func (p *prefetcher) Error() error {
+    <-p.done
+    return p.err
+}
+
+// close stops the reader (if the main loop has stopped early) and waits for it, so the iterator can be released
+func (p *prefetcher) close() {
+    close(p.stop)
+    <-p.done
+}
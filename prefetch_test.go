@@ -0,0 +1,109 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/utxo"
+import "github.com/syndtr/goleveldb/leveldb"
+
+import "bytes"
+import "encoding/binary"
+import "fmt"
+import "testing"
+
+// The scan in the main loop (read each entry, decode it, work out the address) with each combination of -prefetch and
+// -parallel-encode, over a leveldb of p2pkh and p2wpkh utxos, e.g.
+//
+//   go test -bench Prefetch -cpu 8
+//
+// How much each one helps depends on whether the disk or the cpu is the bottleneck, so it's best run on the machine the
+// chainstate is on (with TMPDIR on the same disk, as that's where the test database goes).
+func BenchmarkPrefetch(b *testing.B) {
+    const entries = 50000
+    db, err := leveldb.OpenFile(newBenchmarkChainstate(b, entries), nil)
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer db.Close()
+
+    for _, prefetch := range []int{0, 4, 16} {
+        for _, parallelEncode := range []int{0, 4} {
+            b.Run(fmt.Sprintf("prefetch=%d/parallel-encode=%d", prefetch, parallelEncode), func(b *testing.B) {
+                for i := 0; i < b.N; i++ {
+                    if n := benchmarkScan(b, db, prefetch, parallelEncode); n != entries {
+                        b.Fatalf("scanned %d entries, want %d", n, entries)
+                    }
+                }
+            })
+        }
+    }
+}
+
+// newBenchmarkChainstate writes a leveldb of p2pkh and p2wpkh utxos in a temp dir, and returns the path to it
+func newBenchmarkChainstate(b *testing.B, entries int) string {
+    path := b.TempDir()
+    db, err := leveldb.OpenFile(path, nil)
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer db.Close()
+    batch := new(leveldb.Batch)
+    for i := 0; i < entries; i++ {
+        key := make([]byte, 34) // 43 + txid + vout 0
+        key[0] = 0x43
+        binary.BigEndian.PutUint32(key[1:], uint32(i))
+        hash := bytes.Repeat([]byte{byte(i)}, 20)
+        value := append([]byte{0x02, 0x00, 0x00}, hash...) // height 1, 0 satoshis, p2pkh
+        if i % 2 == 1 {
+            value = append([]byte{0x02, 0x00, 28, 0x00, 20}, hash...) // p2wpkh (stored in full)
+        }
+        batch.Put(key, value)
+    }
+    if err := db.Write(batch, nil); err != nil {
+        b.Fatal(err)
+    }
+    return path
+}
+
+// benchmarkScan goes through the database once, the way the main loop does
+func benchmarkScan(b *testing.B, db *leveldb.DB, prefetch int, parallelEncode int) int {
+    iter := db.NewIterator(nil, nil)
+    defer iter.Release()
+
+    var entries entryIterator = iter
+    first, next := iter.First, iter.Next
+    if prefetch > 0 {
+        reader := newPrefetcher(prefetch, first, next, iter)
+        defer reader.close()
+        first, next = reader.next, reader.next
+        entries = reader
+    }
+
+    var encoder *encodePipeline
+    if parallelEncode > 0 {
+        encoder = newEncodePipeline(parallelEncode, mainnetParams, false, func(key []byte, output map[string]string) error { return nil })
+    }
+
+    n := 0
+    output := map[string]string{}
+    for ok := first(); ok; ok = next() {
+        u, err := utxo.Decode(entries.Key(), entries.Value(), nil)
+        if err != nil {
+            b.Fatal(err)
+        }
+        if encoder != nil {
+            if err := encoder.send(entries.Key(), output, u.Type, u.NSize, u.Script); err != nil {
+                b.Fatal(err)
+            }
+        } else {
+            output["address"] = encodeAddress(u.Type, u.Script, mainnetParams)
+        }
+        n++
+    }
+    if encoder != nil {
+        if err := encoder.close(); err != nil {
+            b.Fatal(err)
+        }
+    }
+    if err := entries.Error(); err != nil {
+        b.Fatal(err)
+    }
+    return n
+}
@@ -0,0 +1,41 @@
+package main
+
+import "fmt"
+import "math/bits" // 128-bit multiply so big amounts times the price don't overflow
+import "strconv"
+import "strings"
+
+// Fiat Value (-price, value_usd)
+// ----------
+// The value of each utxo at a fixed price (e.g. -price 67123.45 USD per BTC). It's all done with integers (cents and
+// satoshis) instead of floats, so there's no rounding drift when you add up the values in the results.
+//
+//   value in cents = satoshis * price in cents / 100,000,000 (rounded to the nearest cent)
+
+// parsePrice turns a price like "67123.45" in to cents (6712345)
+func parsePrice(price string) (uint64, error) {
+    whole, fraction, _ := strings.Cut(price, ".")
+    if len(fraction) > 2 {
+        return 0, fmt.Errorf("-price %s can only have 2 decimal places (cents)", price)
+    }
+    fraction += strings.Repeat("0", 2-len(fraction)) // e.g. .5 = 50 cents
+
+    dollars, err := strconv.ParseUint(whole, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("-price %s isn't a price (e.g. 67123.45)", price)
+    }
+    cents, err := strconv.ParseUint(fraction, 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("-price %s isn't a price (e.g. 67123.45)", price)
+    }
+    return dollars*100 + cents, nil
+}
+
+// formatUSD works out the value of an amount (in satoshis) at the price (in cents), formatted with 2 decimal places
+func formatUSD(satoshis int, priceCents uint64) string {
+    hi, lo := bits.Mul64(uint64(satoshis), priceCents)
+    lo, carry := bits.Add64(lo, 50000000, 0) // add half a cent so the division rounds to the nearest cent
+    hi += carry
+    cents, _ := bits.Div64(hi, lo, 100000000) // hi is always less than 100000000 (there's only 21 million btc), so this can't overflow
+    return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,61 @@
+package main
+
+import "fmt"
+import "os"
+import "time"
+
+// Compact Progress (-compact-progress)
+// ------------------------------------
+// Instead of printing a new line every 100,000 utxos (which scrolls everything else off the screen), this keeps one
+// status line on stderr and rewrites it in place with a carriage return:
+//
+//   12300000 entries processed (412345/s, 29s elapsed)
+//
+// If stderr isn't a terminal (e.g. it's been redirected to a file) the carriage returns would just make a mess, so it
+// prints a normal line every 100,000 entries instead.
+type compactProgress struct {
+    start time.Time
+    last  time.Time // when the line was last rewritten
+    tty   bool
+}
+
+func newCompactProgress() *compactProgress {
+    tty := false
+    if info, err := os.Stderr.Stat(); err == nil {
+        tty = info.Mode()&os.ModeCharDevice != 0 // (a terminal is a character device, a file or pipe isn't)
+    }
+    now := time.Now()
+    return &compactProgress{start: now, last: now, tty: tty}
+}
+
+// update is called every 10,000 entries with the number processed so far
+func (p *compactProgress) update(entries int) {
+    if p.tty {
+        now := time.Now()
+        if now.Sub(p.last) < 250*time.Millisecond { // no point redrawing faster than anyone can read it
+            return
+        }
+        p.last = now
+        fmt.Fprintf(os.Stderr, "\r%s\x1b[K", p.line(entries)) // (and clear the rest of the line, in case it got shorter)
+    } else if entries > 0 && entries % 100000 == 0 {
+        fmt.Fprintln(os.Stderr, p.line(entries))
+    }
+}
+
+// finish shows the final count, and moves on to a new line so the results don't get printed over the status line
+func (p *compactProgress) finish(entries int) {
+    if p.tty {
+        fmt.Fprintf(os.Stderr, "\r%s\x1b[K\n", p.line(entries))
+    } else {
+        fmt.Fprintln(os.Stderr, p.line(entries))
+    }
+}
+
+func (p *compactProgress) line(entries int) string {
+    elapsed := time.Since(p.start)
+    rate := 0.0
+    if elapsed > 0 {
+        rate = float64(entries) / elapsed.Seconds()
+    }
+    return fmt.Sprintf("%d entries processed (%.0f/s, %s elapsed)", entries, rate, elapsed.Round(time.Second))
+}
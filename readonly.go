@@ -0,0 +1,128 @@
+package main
+
+import "github.com/syndtr/goleveldb/leveldb"
+import "github.com/syndtr/goleveldb/leveldb/opt"
+import "github.com/syndtr/goleveldb/leveldb/storage"
+
+import "errors"
+import "fmt"
+import "os"
+import "path/filepath"
+import "strings"
+import "syscall"
+
+// Read-Only Snapshots (-readonly, -force)
+// -------------------
+// With -readonly we open LevelDB with opt.Options{ReadOnly: true}, so goleveldb doesn't write anything to the chainstate
+// folder (no LOG file, no compactions, and the journal gets replayed in to memory instead of being written out to a new table).
+//
+// But even in read-only mode goleveldb still opens (and creates, if it's missing) the LOCK file and takes a shared lock on
+// it, which fails if the chainstate is on read-only storage (e.g. an archived snapshot). So if that happens, we open the
+// database with lockFreeStorage instead, which reads the CURRENT, MANIFEST, .log, and .ldb files directly without touching
+// the LOCK file. -force always does this, even if another goleveldb program (e.g. another dump) is holding the lock, so
+// only use it if you know nothing is writing to the database.
+//
+// -from-snapshot turns on -readonly too, so the snapshot stays the same. The LOCK file doesn't stop the live chainstate from
+// being opened by mistake (goleveldb's lock and bitcoind's don't conflict on Linux), so that gets checked before it's
+// opened instead (see snapshot.go).
+func openReadOnly(path string, opts *opt.Options, force bool) (*leveldb.DB, error) {
+    if !force {
+        db, err := leveldb.OpenFile(path, opts)
+        if err == nil || !isReadOnlyFSError(err) {
+            return db, err
+        }
+    }
+
+    stor := &lockFreeStorage{path: path}
+    if _, err := stor.GetMeta(); err != nil { // check it's actually a leveldb folder first
+        return nil, err
+    }
+    return leveldb.Open(stor, opts)
+}
+
+// isReadOnlyFSError tells us if the LOCK file couldn't be opened because we can't write to the folder
+func isReadOnlyFSError(err error) bool {
+    return errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)
+}
+
+// lockFreeStorage is a read-only storage.Storage for a leveldb folder that doesn't use the LOCK file
+type lockFreeStorage struct {
+    path string
+}
+
+var errReadOnly = errors.New("read-only storage")
+
+type noLock struct{}
+
+func (noLock) Unlock() {}
+
+func (s *lockFreeStorage) Lock() (storage.Locker, error) { return noLock{}, nil }
+func (s *lockFreeStorage) Log(str string)                 {} // nowhere to write the log to
+func (s *lockFreeStorage) Close() error                   { return nil }
+
+func (s *lockFreeStorage) SetMeta(fd storage.FileDesc) error                  { return errReadOnly }
+func (s *lockFreeStorage) Create(fd storage.FileDesc) (storage.Writer, error) { return nil, errReadOnly }
+func (s *lockFreeStorage) Remove(fd storage.FileDesc) error                   { return errReadOnly }
+func (s *lockFreeStorage) Rename(oldfd, newfd storage.FileDesc) error         { return errReadOnly }
+
+// GetMeta reads the name of the current MANIFEST from the CURRENT file
+func (s *lockFreeStorage) GetMeta() (storage.FileDesc, error) {
+    data, err := os.ReadFile(filepath.Join(s.path, "CURRENT"))
+    if err != nil {
+        return storage.FileDesc{}, err
+    }
+    fd, ok := parseFileName(strings.TrimSpace(string(data)))
+    if !ok || fd.Type != storage.TypeManifest {
+        return storage.FileDesc{}, fmt.Errorf("CURRENT in %s doesn't point to a MANIFEST", s.path)
+    }
+    return fd, nil
+}
+
+func (s *lockFreeStorage) List(ft storage.FileType) ([]storage.FileDesc, error) {
+    entries, err := os.ReadDir(s.path)
+    if err != nil {
+        return nil, err
+    }
+    fds := []storage.FileDesc{}
+    for _, entry := range entries {
+        if fd, ok := parseFileName(entry.Name()); ok && fd.Type&ft != 0 {
+            fds = append(fds, fd)
+        }
+    }
+    return fds, nil
+}
+
+func (s *lockFreeStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+    f, err := os.Open(filepath.Join(s.path, fd.String()))
+    if os.IsNotExist(err) && fd.Type == storage.TypeTable { // older versions of leveldb called tables .sst
+        f, err = os.Open(filepath.Join(s.path, fmt.Sprintf("%06d.sst", fd.Num)))
+    }
+    if err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+// parseFileName works out the type and number of a leveldb file from its name (e.g. 000123.ldb or MANIFEST-000004)
+func parseFileName(name string) (storage.FileDesc, bool) {
+    var fd storage.FileDesc
+    var tail string
+    if _, err := fmt.Sscanf(name, "%d.%s", &fd.Num, &tail); err == nil {
+        switch tail {
+        case "log":
+            fd.Type = storage.TypeJournal
+        case "ldb", "sst":
+            fd.Type = storage.TypeTable
+        case "tmp":
+            fd.Type = storage.TypeTemp
+        default:
+            return fd, false
+        }
+        return fd, true
+    }
+    if n, _ := fmt.Sscanf(name, "MANIFEST-%d", &fd.Num); n == 1 {
+        fd.Type = storage.TypeManifest
+        return fd, true
+    }
+    return fd, false
+}
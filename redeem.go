@@ -0,0 +1,49 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript" // classify redeem scripts
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/crypto"    // hash160
+
+import "bufio"
+import "encoding/hex"
+import "fmt"
+import "os"
+import "strings"
+
+// Redeem Scripts (-redeem-scripts)
+// --------------------------------
+// A P2SH output only stores the hash160 of the redeem script, so from the chainstate alone we can't tell if it's really
+// wrapping segwit (P2WPKH-in-P2SH, P2WSH-in-P2SH), a multisig, or something else. The p2sh_subtype field is "unknown" for these.
+//
+// If you know some of your redeem scripts (e.g. from your wallet), put them in a file (one hex script per line) and the
+// p2sh_subtype will be set to the type of the redeem script for any P2SH output that matches its hash160:
+//
+//   0014751e76e8199196d454941c45d1b3a323f1433bd6  <- p2wpkh (nested segwit)
+//   5221...52ae                                   <- p2ms
+//
+// loadRedeemScripts returns a map of hash160 -> redeem script type
+func loadRedeemScripts(file string) (map[string]string, error) {
+    subtypes := map[string]string{}
+    if file == "" {
+        return subtypes, nil
+    }
+
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        redeemScript, err := hex.DecodeString(line)
+        if err != nil {
+            return nil, fmt.Errorf("couldn't decode redeem script %s: %v", line, err)
+        }
+        subtypes[string(crypto.Hash160(redeemScript))] = btcscript.Type(redeemScript)
+    }
+    return subtypes, scanner.Err()
+}
@@ -0,0 +1,103 @@
+package main
+
+import "bufio"
+import "fmt"
+import "net"     // -redis-addr
+import "strconv"
+import "strings"
+
+// Redis (-format redis)
+// ---------------------
+// Puts each utxo in a Redis hash keyed by its outpoint, with a field for each of the -f fields:
+//
+//   HSET utxo:0e3e2357...:1 txid 0e3e2357... vout 1 amount 546 address 1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa
+//
+// and with -redis-address-set it also adds the address to a set, so you can check if an address has any utxos with
+// SISMEMBER. With -redis-addr the commands get sent straight to the server, pipelined -batch-size commands at a time
+// (sending them all and then reading all the replies, instead of waiting for each reply in turn). Without it the
+// commands are written to the output file instead, ready to be loaded in with:
+//
+//   cat utxodump.redis | redis-cli --pipe
+type redisWriter struct {
+    w          *bufio.Writer
+    conn       net.Conn      // nil if we're writing to the file
+    replies    *bufio.Reader
+    batchSize  int
+    addressSet string        // -redis-address-set
+    fields     []string
+    pending    int           // commands sent that we haven't read the replies for yet
+}
+
+func newRedisWriter(w *bufio.Writer, addr string, addressSet string, batchSize int) (*redisWriter, error) {
+    if batchSize <= 0 {
+        batchSize = 1000
+    }
+    r := &redisWriter{w: w, addressSet: addressSet, batchSize: batchSize}
+    if addr != "" {
+        conn, err := net.Dial("tcp", addr)
+        if err != nil {
+            return nil, fmt.Errorf("couldn't connect to redis at %s: %v", addr, err)
+        }
+        r.conn = conn
+        r.w = bufio.NewWriter(conn)
+        r.replies = bufio.NewReader(conn)
+    }
+    return r, nil
+}
+
+func (r *redisWriter) Header(fields []string) error {
+    r.fields = fields
+    return nil // a hash doesn't need a header
+}
+
+func (r *redisWriter) Row(output map[string]string) error {
+    args := make([]string, 0, 2+len(r.fields)*2)
+    args = append(args, "HSET", "utxo:"+output["txid"]+":"+output["vout"])
+    for _, v := range r.fields {
+        args = append(args, v, output[v])
+    }
+    r.command(args...)
+
+    if r.addressSet != "" && output["address"] != "" {
+        r.command("SADD", r.addressSet, output["address"])
+    }
+
+    if r.conn != nil && r.pending >= r.batchSize {
+        return r.sync()
+    }
+    return nil
+}
+
+func (r *redisWriter) Close() error {
+    if r.conn == nil {
+        return nil
+    }
+    defer r.conn.Close()
+    return r.sync()
+}
+
+// command writes a command in the redis protocol (RESP), e.g. *3\r\n$4\r\nSADD\r\n$9\r\naddresses\r\n$34\r\n1A1z...\r\n
+func (r *redisWriter) command(args ...string) {
+    r.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+    for _, arg := range args {
+        r.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+    }
+    r.pending++
+}
+
+// sync sends the commands in the buffer and reads a reply for each one, stopping at the first error
+func (r *redisWriter) sync() error {
+    if err := r.w.Flush(); err != nil {
+        return err
+    }
+    for ; r.pending > 0; r.pending-- {
+        reply, err := r.replies.ReadString('\n')
+        if err != nil {
+            return fmt.Errorf("couldn't read reply from redis: %v", err)
+        }
+        if strings.HasPrefix(reply, "-") { // error reply (HSET and SADD only ever reply with an integer otherwise)
+            return fmt.Errorf("redis: %s", strings.TrimSpace(reply[1:]))
+        }
+    }
+    return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "encoding/json"
+import "os"
+import "os/exec"
+import "path/filepath"
+
+// Snapshots (-from-snapshot)
+// --------------------------
+// -from-snapshot skips the check that bitcoind isn't running, as it isn't using the snapshot. But nothing else stops the
+// -db from being the live chainstate by mistake: goleveldb's LOCK is a flock(), and bitcoin core's leveldb uses fcntl()
+// locks, which don't see each other on Linux, so opening the live chainstate works and we'd read it while bitcoind is
+// writing to it.
+//
+// So if bitcoind is running, we ask it where its data directory is (getrpcinfo has the path to its debug.log, which is in
+// the data directory for the network it's on), and refuse if the -db is the chainstate folder in there. os.SameFile
+// compares the folders themselves, so a symlink or a bind mount to the live chainstate gets caught too, and a snapshot
+// (which is on a device of its own) doesn't.
+
+// runningChainstate gets the chainstate folder the running bitcoind is using. running is false if bitcoin-cli can't get
+// an answer from bitcoind, and path is empty if it's running but we can't tell where its chainstate is.
+func runningChainstate() (path string, running bool) {
+    out, err := exec.Command("bitcoin-cli", "getrpcinfo").Output()
+    if err != nil {
+        // getrpcinfo is newer than getnetworkinfo (the check we do without -from-snapshot), so it might just be an old bitcoind
+        if exec.Command("bitcoin-cli", "getnetworkinfo").Run() == nil {
+            return "", true
+        }
+        return "", false
+    }
+    return chainstateFromRPCInfo(out), true
+}
+
+// chainstateFromRPCInfo gets the chainstate folder from the getrpcinfo result, e.g. {"logpath": "/home/user/.bitcoin/testnet3/debug.log"}
+func chainstateFromRPCInfo(out []byte) string {
+    var info struct {
+        LogPath string `json:"logpath"`
+    }
+    if err := json.Unmarshal(out, &info); err != nil || info.LogPath == "" {
+        return ""
+    }
+    return filepath.Join(filepath.Dir(info.LogPath), "chainstate")
+}
+
+// sameFolder tells us if two paths are the same folder (following symlinks)
+func sameFolder(a string, b string) bool {
+    infoA, err := os.Stat(a)
+    if err != nil {
+        return false
+    }
+    infoB, err := os.Stat(b)
+    if err != nil {
+        return false
+    }
+    return os.SameFile(infoA, infoB)
+}
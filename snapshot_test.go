@@ -0,0 +1,51 @@
+package main
+
+import "os"
+import "path/filepath"
+import "testing"
+
+func TestChainstateFromRPCInfo(t *testing.T) {
+    tests := []struct {
+        out  string
+        want string
+    }{
+        {`{"active_commands": [], "logpath": "/home/user/.bitcoin/debug.log"}`, "/home/user/.bitcoin/chainstate"},
+        {`{"active_commands": [], "logpath": "/home/user/.bitcoin/testnet3/debug.log"}`, "/home/user/.bitcoin/testnet3/chainstate"},
+        {`{"active_commands": []}`, ""},
+        {`error`, ""},
+    }
+    for _, tt := range tests {
+        if got := chainstateFromRPCInfo([]byte(tt.out)); got != filepath.FromSlash(tt.want) {
+            t.Errorf("chainstateFromRPCInfo(%s) = %q, want %q", tt.out, got, tt.want)
+        }
+    }
+}
+
+func TestSameFolder(t *testing.T) {
+    dir := t.TempDir()
+    live := filepath.Join(dir, "chainstate")
+    snapshot := filepath.Join(dir, "snapshot", "chainstate")
+    link := filepath.Join(dir, "link")
+    if err := os.MkdirAll(live, 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.MkdirAll(snapshot, 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Symlink(live, link); err != nil {
+        t.Skip("can't make a symlink:", err)
+    }
+
+    if !sameFolder(live, live + string(filepath.Separator)) {
+        t.Error("the live chainstate isn't the same folder as itself")
+    }
+    if !sameFolder(live, link) {
+        t.Error("a symlink to the live chainstate isn't the same folder")
+    }
+    if sameFolder(live, snapshot) {
+        t.Error("the snapshot is the same folder as the live chainstate")
+    }
+    if sameFolder(live, filepath.Join(dir, "missing")) {
+        t.Error("a folder that doesn't exist is the same folder as the live chainstate")
+    }
+}
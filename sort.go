@@ -0,0 +1,411 @@
+package main
+
+import "bufio"
+import "container/heap"  // k-way merge of the sorted runs
+import "encoding/json"   // rows in the run files
+import "errors"
+import "fmt"
+import "math/big"        // compare decimal fields as numbers
+import "os"
+import "path/filepath"
+import "sort"
+import "strconv"         // compare int fields as numbers
+import "strings"
+import "sync/atomic"     // -max-memory asks for a spill from the main loop (the rows can be coming from the -parallel-encode goroutine), ctrl-c stops the merge
+
+// Sort (-sort, -canonical)
+// ------------------------
+// The whole utxo set is too big to sort in memory (80 million rows), so this is an external merge sort:
+//
+//   1. collect -sort-mem rows at a time, sort them, and write each sorted run to a temp file
+//   2. at the end, k-way merge the runs (always taking the smallest row from the front of each run) in to the real output
+//
+// Each run being merged is an open file, so if there are more than mergeFanIn runs they get merged in groups of that many
+// in to bigger runs first (and again if need be), which keeps it well under the limit on open files.
+//
+// If all the rows fit in one run it never touches the disk. The temp files are removed when the sort finishes (or in cleanup()).
+//
+// With -canonical the rows are sorted by outpoint (txid, then vout as a number), or by outpoint after the -sort field if
+// there is one (so rows with the same value always come out in the same order). This way the output for the same utxo set
+// is always exactly the same, whatever order the database happens to give the utxos in. The txid and vout don't have to be
+// in the -f fields for this, they get carried along with each row for the sort (and then dropped).
+type sortWriter struct {
+    out       rowWriter   // the -format writer the sorted rows get passed on to
+    field     string      // field to sort by ("" for just -canonical)
+    desc      bool        // largest first
+    kind      string      // how to compare the values: int, decimal, or "" (as strings)
+    canonical bool        // then by txid and vout (-canonical)
+    runSize   int         // rows per run (-sort-mem)
+    fields    []string
+    columns   []string    // the fields, and the txid and vout on the end if they aren't in them (-canonical)
+    rows      [][]string  // current run (values in the same order as columns)
+    index     int         // position of the sort field in each row
+    txidAt    int         // position of the txid and vout in each row (-canonical)
+    voutAt    int
+    dir      string      // temp directory for the runs
+    runs      []string    // run files written so far
+    spillNow  atomic.Bool // write out the current run with the next row (-max-memory)
+    cancelled atomic.Bool // stop merging (ctrl-c)
+}
+
+func newSortWriter(out rowWriter, field string, desc bool, canonical bool, runSize int) *sortWriter {
+    if runSize <= 0 {
+        runSize = 1000000
+    }
+    kind := fieldTypes[field]
+    if field == "vout" {
+        kind = "int" // (the vout is still a number here with -vout-hex, it's only written in hex after the sort)
+    }
+    return &sortWriter{out: out, field: field, desc: desc, kind: kind, canonical: canonical, runSize: runSize}
+}
+
+func (s *sortWriter) Header(fields []string) error {
+    s.fields = fields
+    s.columns = fields
+    s.index = columnIndex(fields, s.field)
+    if s.field != "" && s.index == -1 {
+        return fmt.Errorf("-sort %s needs %s to be one of the -f fields", s.field, s.field)
+    }
+    if s.canonical {
+        s.columns = append([]string{}, fields...)
+        for _, v := range []string{"txid", "vout"} {
+            if columnIndex(s.columns, v) == -1 {
+                s.columns = append(s.columns, v)
+            }
+        }
+        s.txidAt = columnIndex(s.columns, "txid")
+        s.voutAt = columnIndex(s.columns, "vout")
+    }
+    return s.out.Header(fields)
+}
+
+// columnIndex is the position of a field in the columns (-1 if it isn't there)
+func columnIndex(columns []string, field string) int {
+    for i, v := range columns {
+        if v == field {
+            return i
+        }
+    }
+    return -1
+}
+
+func (s *sortWriter) Row(output map[string]string) error {
+    row := make([]string, len(s.columns))
+    for i, v := range s.columns {
+        row[i] = output[v]
+    }
+    s.rows = append(s.rows, row)
+    if s.spillNow.Swap(false) {
+        return s.spill()
+    }
+    if len(s.rows) >= s.runSize {
+        return s.writeRun()
+    }
+    return nil
+}
+
+func (s *sortWriter) Close() error {
+    defer s.cleanup()
+
+    // Everything fitted in memory, so just write it out
+    if len(s.runs) == 0 {
+        s.sortRun()
+        for _, row := range s.rows {
+            if err := s.out.Row(s.rowMap(row)); err != nil {
+                return err
+            }
+        }
+        return s.out.Close()
+    }
+
+    // Otherwise write the last run and merge them all
+    if len(s.rows) > 0 {
+        if err := s.writeRun(); err != nil {
+            return err
+        }
+    }
+    if err := s.merge(); err != nil {
+        return err
+    }
+    return s.out.Close()
+}
+
+// cleanup removes the temp files (safe to call more than once)
+func (s *sortWriter) cleanup() {
+    if s.dir != "" {
+        os.RemoveAll(s.dir)
+        s.dir = ""
+    }
+}
+
+// less compares two rows by the sort field (and then by outpoint with -canonical)
+func (s *sortWriter) less(a, b []string) bool {
+    if s.field != "" {
+        if c := compareValues(a[s.index], b[s.index], s.kind); c != 0 {
+            if s.desc {
+                return c > 0
+            }
+            return c < 0
+        }
+    }
+    if s.canonical {
+        if c := compareValues(a[s.txidAt], b[s.txidAt], ""); c != 0 {
+            return c < 0
+        }
+        return compareValues(a[s.voutAt], b[s.voutAt], "int") < 0
+    }
+    return false
+}
+
+// compareValues returns -1, 0 or 1 (comparing them as numbers for the int and decimal fields, e.g. 50.00000000 comes
+// before 21000000.00000000, which it wouldn't as strings)
+func compareValues(a string, b string, kind string) int {
+    switch kind {
+    case "int":
+        x, _ := strconv.ParseInt(a, 10, 64)
+        y, _ := strconv.ParseInt(b, 10, 64)
+        switch {
+        case x < y:
+            return -1
+        case x > y:
+            return 1
+        }
+        return 0
+    case "decimal":
+        x, xok := new(big.Rat).SetString(a)
+        y, yok := new(big.Rat).SetString(b)
+        if !xok || !yok { // empty (e.g. value_usd without a -price) comes before any number
+            return compareBool(xok, yok)
+        }
+        return x.Cmp(y)
+    }
+    return strings.Compare(a, b)
+}
+
+// compareBool puts false before true
+func compareBool(a bool, b bool) int {
+    switch {
+    case a == b:
+        return 0
+    case !a:
+        return -1
+    }
+    return 1
+}
+
+func (s *sortWriter) sortRun() {
+    sort.SliceStable(s.rows, func(i, j int) bool { return s.less(s.rows[i], s.rows[j]) }) // stable, so equal rows keep the chainstate order
+}
+
+func (s *sortWriter) rowMap(row []string) map[string]string {
+    output := map[string]string{}
+    for i, v := range s.fields {
+        output[v] = row[i]
+    }
+    return output
+}
+
+// writeRun sorts the current rows and writes them to a new temp file (one json array per line)
+func (s *sortWriter) writeRun() error {
+    if s.dir == "" {
+        dir, err := os.MkdirTemp("", "utxodump-sort-")
+        if err != nil {
+            return err
+        }
+        s.dir = dir
+    }
+    s.sortRun()
+
+    file := filepath.Join(s.dir, fmt.Sprintf("run%d", len(s.runs)))
+    f, err := os.Create(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    w := bufio.NewWriter(f)
+    encoder := json.NewEncoder(w)
+    for _, row := range s.rows {
+        if err := encoder.Encode(row); err != nil {
+            return err
+        }
+    }
+    if err := w.Flush(); err != nil {
+        return err
+    }
+
+    s.runs = append(s.runs, file)
+    s.rows = s.rows[:0]
+    return nil
+}
+
+// errSortCancelled is returned by the merge when it's been stopped with cancel()
+var errSortCancelled = errors.New("sort stopped before it finished")
+
+// cancel stops the merge (if it's running) at the next row, so the main goroutine can clean up and exit (ctrl-c)
+func (s *sortWriter) cancel() {
+    s.cancelled.Store(true)
+}
+
+// requestSpill gets the rows collected so far written out as a run when the next row comes in (-max-memory)
+func (s *sortWriter) requestSpill() error {
+    s.spillNow.Store(true)
+    return nil
+}
+
+// the smallest a run gets made by -max-memory (the memory can be over the limit before there are many rows, e.g. with a
+// small -max-memory or lots of other things in memory, and runs of a few rows each would mean tens of thousands of them)
+const minSpillRun = 100000
+
+// spill writes out the rows collected so far as a run, and keeps the runs to that size from now on (but no smaller than
+// minSpillRun, if there aren't that many yet they get written when there are)
+func (s *sortWriter) spill() error {
+    runSize := max(len(s.rows), minSpillRun)
+    if runSize < s.runSize {
+        s.runSize = runSize
+    }
+    if len(s.rows) < minSpillRun {
+        return nil
+    }
+    if err := s.writeRun(); err != nil {
+        return err
+    }
+    s.rows = nil // (let the memory for the old run go)
+    return nil
+}
+
+// most runs to have open at once when merging
+const mergeFanIn = 64
+
+// merge merges the runs (in groups first if there are too many to open at once) in to the output
+func (s *sortWriter) merge() error {
+    for pass := 0; len(s.runs) > mergeFanIn; pass++ {
+        var merged []string
+        for start := 0; start < len(s.runs); start += mergeFanIn {
+            group := s.runs[start:min(start+mergeFanIn, len(s.runs))] // (next to each other, so ties still go to the earlier run)
+            file := filepath.Join(s.dir, fmt.Sprintf("merge%d-%d", pass, len(merged)))
+            if err := s.mergeGroup(group, file); err != nil {
+                return err
+            }
+            merged = append(merged, file)
+        }
+        s.runs = merged
+    }
+
+    return s.mergeRuns(s.runs, func(row []string) error {
+        return s.out.Row(s.rowMap(row))
+    })
+}
+
+// mergeGroup merges some of the runs in to one bigger run, and removes the old ones
+func (s *sortWriter) mergeGroup(group []string, file string) error {
+    f, err := os.Create(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    w := bufio.NewWriter(f)
+    encoder := json.NewEncoder(w)
+    if err := s.mergeRuns(group, func(row []string) error { return encoder.Encode(row) }); err != nil {
+        return err
+    }
+    if err := w.Flush(); err != nil {
+        return err
+    }
+    for _, old := range group {
+        os.Remove(old)
+    }
+    return nil
+}
+
+// mergeRuns reads the front row of each run and keeps passing on the smallest one (each file gets closed as soon as its run
+// has been used up)
+func (s *sortWriter) mergeRuns(files []string, emit func(row []string) error) error {
+    h := &runHeap{s: s}
+    defer func() { // (anything still open if it stopped early)
+        for _, r := range h.runs {
+            r.file.Close()
+        }
+    }()
+    for i, file := range files {
+        f, err := os.Open(file)
+        if err != nil {
+            return err
+        }
+        r := &run{file: f, decoder: json.NewDecoder(bufio.NewReader(f)), index: i}
+        ok, err := r.next()
+        if err != nil {
+            f.Close()
+            return err
+        }
+        if !ok {
+            f.Close()
+            continue
+        }
+        heap.Push(h, r)
+    }
+
+    for h.Len() > 0 {
+        if s.cancelled.Load() {
+            return errSortCancelled
+        }
+        r := h.runs[0]
+        if err := emit(r.row); err != nil {
+            return err
+        }
+        ok, err := r.next()
+        if err != nil {
+            return err
+        }
+        if ok {
+            heap.Fix(h, 0)
+        } else {
+            heap.Pop(h)
+            r.file.Close()
+        }
+    }
+    return nil
+}
+
+// run is a sorted run file being read during the merge
+type run struct {
+    file    *os.File
+    decoder *json.Decoder
+    row     []string // current front row
+    index   int      // run number (earlier runs win ties, to keep the sort stable)
+}
+
+func (r *run) next() (bool, error) {
+    r.row = nil
+    if !r.decoder.More() {
+        return false, nil
+    }
+    if err := r.decoder.Decode(&r.row); err != nil {
+        return false, err
+    }
+    return true, nil
+}
+
+// runHeap is a min-heap of runs ordered by their front row
+type runHeap struct {
+    s    *sortWriter
+    runs []*run
+}
+
+func (h runHeap) Len() int { return len(h.runs) }
+func (h runHeap) Less(i, j int) bool {
+    a, b := h.runs[i], h.runs[j]
+    if h.s.less(a.row, b.row) {
+        return true
+    }
+    if h.s.less(b.row, a.row) {
+        return false
+    }
+    return a.index < b.index
+}
+func (h runHeap) Swap(i, j int)       { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+    r := h.runs[len(h.runs)-1]
+    h.runs = h.runs[:len(h.runs)-1]
+    return r
+}
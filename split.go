@@ -0,0 +1,105 @@
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "path/filepath"
+import "sort"
+
+// Split By Type (-split-by-type)
+// -----------------------------
+// Writes the utxos for each script type to a file of their own, named after the -o file with the type put in before the
+// extension:
+//
+//   utxodump.csv -> utxodump.p2pkh.csv, utxodump.p2wpkh.csv, utxodump.non-standard.csv, ...
+//
+// Each file gets its own -format writer (and its own header), and is only created when the first utxo of that type turns
+// up. There are only ever a handful of script types, so keeping a file open for each one is fine.
+type splitWriter struct {
+    path    string // -o
+    format  string
+    options outputOptions
+    fields  []string
+    files   map[string]*splitFile // script type = file
+}
+
+type splitFile struct {
+    path string
+    f    *os.File
+    w    *bufio.Writer
+    rows rowWriter
+}
+
+func newSplitWriter(path string, format string, options outputOptions) *splitWriter {
+    return &splitWriter{path: path, format: format, options: options, files: map[string]*splitFile{}}
+}
+
+// splitPath puts the script type in to the file name (utxodump.csv -> utxodump.p2pkh.csv)
+func splitPath(path string, scriptType string) string {
+    ext := filepath.Ext(path)
+    return path[:len(path)-len(ext)] + "." + scriptType + ext
+}
+
+func (s *splitWriter) Header(fields []string) error {
+    s.fields = fields // (written to each file when it gets created)
+    return nil
+}
+
+func (s *splitWriter) Row(output map[string]string) error {
+    scriptType := output["type"]
+    file, ok := s.files[scriptType]
+    if !ok {
+        var err error
+        file, err = s.create(scriptType)
+        if err != nil {
+            return err
+        }
+        s.files[scriptType] = file
+    }
+    return file.rows.Row(output)
+}
+
+// create opens the file for a script type and writes the header
+func (s *splitWriter) create(scriptType string) (*splitFile, error) {
+    path := splitPath(s.path, scriptType)
+    f, err := os.Create(path)
+    if err != nil {
+        return nil, fmt.Errorf("couldn't create %s: %v", path, err)
+    }
+    w := bufio.NewWriter(f)
+    rows, err := newRowWriter(s.format, w, s.options)
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    if err := rows.Header(s.fields); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return &splitFile{path: path, f: f, w: w, rows: rows}, nil
+}
+
+// Close finishes and closes every file (carrying on after an error so the rest still get written)
+func (s *splitWriter) Close() error {
+    types := make([]string, 0, len(s.files))
+    for scriptType := range s.files {
+        types = append(types, scriptType)
+    }
+    sort.Strings(types)
+
+    var firstErr error
+    for _, scriptType := range types {
+        file := s.files[scriptType]
+        err := file.rows.Close()
+        if err == nil {
+            err = file.w.Flush()
+        }
+        if closeErr := file.f.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil && firstErr == nil {
+            firstErr = fmt.Errorf("couldn't write %s: %v", file.path, err)
+        }
+    }
+    return firstErr
+}
@@ -0,0 +1,80 @@
+package main
+
+import "bufio"
+import "fmt"
+import "strings"
+
+// SQL (-format sql-insert)
+// ------------------------
+// Multi-row INSERT statements that can be piped straight in to most SQL databases:
+//
+//   INSERT INTO utxos (txid,vout,amount) VALUES
+//   ('0e3e2357...',0,5000000000),
+//   ('0e3e2357...',1,546);
+//
+// Each statement has up to -batch-size rows (big statements are faster to load, but some databases have a limit on how big
+// a statement can be). The int fields are written as numbers and everything else as a quoted string.
+type sqlWriter struct {
+    w         *bufio.Writer
+    table     string
+    batchSize int
+    fields    []string
+    rows      int // rows in the current statement
+    newline   string
+}
+
+func (s *sqlWriter) Header(fields []string) error {
+    s.fields = fields
+    if s.batchSize <= 0 {
+        s.batchSize = 1000
+    }
+    return nil // the column names go in each INSERT
+}
+
+func (s *sqlWriter) Row(output map[string]string) error {
+    if s.rows == 0 {
+        fmt.Fprintf(s.w, "INSERT INTO %s (%s) VALUES%s", s.table, strings.Join(s.fields, ","), s.newline)
+    } else {
+        s.w.WriteString("," + s.newline)
+    }
+
+    s.w.WriteByte('(')
+    for i, v := range s.fields {
+        if i > 0 {
+            s.w.WriteByte(',')
+        }
+        s.w.WriteString(sqlValue(v, output[v]))
+    }
+    s.w.WriteByte(')')
+    s.rows++
+
+    if s.rows >= s.batchSize {
+        return s.end()
+    }
+    return nil
+}
+
+func (s *sqlWriter) Close() error {
+    if s.rows > 0 {
+        return s.end()
+    }
+    return nil
+}
+
+// end finishes the current INSERT statement
+func (s *sqlWriter) end() error {
+    s.rows = 0
+    _, err := s.w.WriteString(";" + s.newline)
+    return err
+}
+
+// sqlValue quotes a value for a statement (e.g. 'p2pkh'), with any ' inside the string doubled up to escape it
+func sqlValue(field string, value string) string {
+    if fieldTypes[field] == "int" {
+        if value == "" {
+            return "NULL"
+        }
+        return value
+    }
+    return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
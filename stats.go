@@ -0,0 +1,61 @@
+package main
+
+// Scan Totals
+// -----------
+// The running totals for the utxos (the total amount, the amount in immature coinbase outputs, the count for each script
+// type, and the nsize counts) are kept together in one of these instead of in separate variables in the main loop.
+//
+// The main loop adds each utxo that gets dumped with addType and add (the same two calls anything else that decodes
+// utxos should make). It's all done in the one goroutine at the moment (-parallel-encode only works out the addresses
+// in the other goroutines, and writes the rows in one more, so none of them touch the totals). But anything that decodes
+// the utxos in more than one goroutine would race on plain ints and a map. So the idea is that each goroutine keeps its
+// own scanTotals (no locking needed in the loop), and they get merged in to one at the end:
+//
+//   totals := newScanTotals()
+//   for _, worker := range workers {
+//       totals.merge(worker.totals)
+//   }
+//
+// Everything in here is a sum, so the merged totals come out the same whatever order the utxos were split up in.
+type scanTotals struct {
+    amount      int            // satoshis
+    immature    int            // satoshis in coinbase outputs that can't be spent yet (-tip-height)
+    scriptTypes map[string]int // script type = number of utxos
+    nsizes      *nsizeHistogram
+}
+
+func newScanTotals() *scanTotals {
+    return &scanTotals{
+        scriptTypes: map[string]int{"p2pk":0, "p2pkh":0, "p2sh":0, "p2ms":0, "p2wpkh":0, "p2wsh":0, "p2tr":0, "non-standard": 0}, // (always shown, even if there aren't any)
+        nsizes:      newNsizeHistogram(),
+    }
+}
+
+// addType counts a utxo under the script type it ended up with (only called if the script type has been worked out)
+func (t *scanTotals) addType(scriptType string) {
+    t.scriptTypes[scriptType] += 1
+}
+
+// add adds a utxo's amount on to the totals, and its nsize if the value was decoded. The amount counts as immature if
+// it's from a coinbase that won't have 100 confirmations in the next block (tipHeight is -1 if it wasn't given).
+func (t *scanTotals) add(amount int, nsize int, decoded bool, coinbase int, height int, tipHeight int) {
+    t.amount += amount
+    if decoded {
+        t.nsizes.add(nsize)
+    }
+    if tipHeight >= 0 && coinbase == 1 && (tipHeight + 1) - height < 100 {
+        t.immature += amount
+    }
+}
+
+// merge adds the totals from another goroutine on to these (the other one shouldn't be used by it any more)
+func (t *scanTotals) merge(other *scanTotals) {
+    t.amount += other.amount
+    t.immature += other.immature
+    for scriptType, n := range other.scriptTypes {
+        t.scriptTypes[scriptType] += n
+    }
+    for i, n := range other.nsizes.counts {
+        t.nsizes.counts[i] += n
+    }
+}
@@ -0,0 +1,175 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/utxo"
+import "github.com/syndtr/goleveldb/leveldb"
+import "github.com/syndtr/goleveldb/leveldb/util"
+
+import "bytes"
+import "encoding/binary"
+import "reflect"
+import "sync"
+import "testing"
+
+// appendVarint128 is the reverse of btcleveldb.Varint128Read (bitcoin core's VARINT, 7 bits per byte with 1 taken off
+// each byte but the last)
+func appendVarint128(b []byte, n int) []byte {
+    var tmp [10]byte
+    i := len(tmp) - 1
+    tmp[i] = byte(n & 0x7f)
+    for n > 0x7f {
+        n = (n >> 7) - 1
+        i--
+        tmp[i] = byte(n & 0x7f) | 0x80
+    }
+    return append(b, tmp[i:]...)
+}
+
+// newStatsChainstate writes n utxos of each script type (and the obfuscate key) to a leveldb in a temp dir, and returns
+// the number of utxos it wrote for each type
+func newStatsChainstate(t *testing.T, n int) (*leveldb.DB, map[string]int) {
+    t.Helper()
+    db, err := leveldb.OpenFile(t.TempDir(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    obfuscateKey := []byte{0xb1, 0x2d, 0xce, 0xfd, 0x8f, 0x87, 0x25, 0x36}
+    batch := new(leveldb.Batch)
+    batch.Put(append([]byte{0x0e, 0x00}, "obfuscate_key"...), append([]byte{byte(len(obfuscateKey))}, obfuscateKey...))
+
+    // nsize + script for each type (0-5 are compressed, the rest are stored in full with nsize = length + 6)
+    scripts := []struct {
+        scriptType string
+        nsize      int
+        script     []byte
+    }{
+        {"p2pkh", 0, bytes.Repeat([]byte{0x11}, 20)},
+        {"p2sh", 1, bytes.Repeat([]byte{0x22}, 20)},
+        {"p2pk", 2, bytes.Repeat([]byte{0x33}, 32)},
+        {"p2wpkh", 6 + 22, append([]byte{0x00, 20}, bytes.Repeat([]byte{0x44}, 20)...)},
+        {"p2tr", 6 + 34, append([]byte{0x51, 32}, bytes.Repeat([]byte{0x55}, 32)...)},
+        {"non-standard", 6 + 3, []byte{0x6a, 0x01, 0x01}},
+    }
+
+    types := map[string]int{}
+    for i := 0; i < n * len(scripts); i++ {
+        s := scripts[i % len(scripts)]
+        types[s.scriptType]++
+
+        // spread the txids out over the keys so each range in the test gets some
+        key := make([]byte, 34)
+        key[0] = 0x43
+        binary.BigEndian.PutUint32(key[1:], uint32(i) * 2654435761)
+        key = appendVarint128(key, i % 3) // vout
+
+        height := 800000 - i % 300
+        coinbase := 0
+        if i % 7 == 0 {
+            coinbase = 1
+        }
+        value := appendVarint128(nil, height << 1 | coinbase)
+        value = appendVarint128(value, 1 + (i * 7919) % 100000) // compressed amount
+        value = appendVarint128(value, s.nsize)
+        value = append(value, s.script...)
+        batch.Put(key, btcleveldb.Deobfuscate(value, obfuscateKey)) // (xor goes both ways)
+    }
+    if err := db.Write(batch, nil); err != nil {
+        t.Fatal(err)
+    }
+    return db, types
+}
+
+// statsTotals decodes the utxos in a range of keys and adds them to a new scanTotals the way the main loop does
+func statsTotals(t *testing.T, db *leveldb.DB, keys *util.Range, tipHeight int) *scanTotals {
+    value, err := db.Get(append([]byte{0x0e, 0x00}, "obfuscate_key"...), nil)
+    if err != nil {
+        t.Error(err)
+        return nil
+    }
+    obfuscateKey, err := btcleveldb.ObfuscateKey(value)
+    if err != nil {
+        t.Error(err)
+        return nil
+    }
+
+    totals := newScanTotals()
+    iter := db.NewIterator(keys, nil)
+    defer iter.Release()
+    for iter.Next() {
+        u, err := utxo.Decode(iter.Key(), iter.Value(), obfuscateKey)
+        if err != nil {
+            t.Error(err)
+            return nil
+        }
+        coinbase := 0
+        if u.Coinbase {
+            coinbase = 1
+        }
+        totals.addType(u.Type)
+        totals.add(int(u.Amount), u.NSize, true, coinbase, u.Height, tipHeight)
+    }
+    if err := iter.Error(); err != nil {
+        t.Error(err)
+    }
+    return totals
+}
+
+func TestScanTotalsMerge(t *testing.T) {
+    const tipHeight = 800000
+    db, types := newStatsChainstate(t, 2000)
+
+    // all the utxos one after the other
+    sequential := statsTotals(t, db, util.BytesPrefix([]byte{0x43}), tipHeight)
+    if sequential == nil {
+        t.FailNow()
+    }
+    for scriptType, n := range types {
+        if sequential.scriptTypes[scriptType] != n {
+            t.Errorf("%d %s utxos, want %d", sequential.scriptTypes[scriptType], scriptType, n)
+        }
+    }
+    if sequential.amount == 0 || sequential.immature == 0 || sequential.immature >= sequential.amount {
+        t.Errorf("amount %d immature %d, want some of both (and less immature)", sequential.amount, sequential.immature)
+    }
+
+    // split up in to ranges of keys, each one decoded in its own goroutine with its own totals
+    const workers = 4
+    partial := make([]*scanTotals, workers)
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        keys := &util.Range{Start: []byte{0x43, byte(w * 256 / workers)}, Limit: []byte{0x43, byte((w + 1) * 256 / workers)}}
+        if w == workers - 1 {
+            keys.Limit = []byte{0x44}
+        }
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            partial[w] = statsTotals(t, db, keys, tipHeight)
+        }(w)
+    }
+    wg.Wait()
+    for w, p := range partial {
+        if p == nil {
+            t.Fatalf("worker %d didn't finish", w)
+        }
+    }
+
+    // merged in both directions
+    for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}} {
+        merged := newScanTotals()
+        for _, w := range order {
+            merged.merge(partial[w])
+        }
+        if merged.amount != sequential.amount || merged.immature != sequential.immature {
+            t.Errorf("merged %v: amount %d immature %d, want %d and %d", order, merged.amount, merged.immature, sequential.amount, sequential.immature)
+        }
+        if !reflect.DeepEqual(merged.scriptTypes, sequential.scriptTypes) {
+            t.Errorf("merged %v: script types %v, want %v", order, merged.scriptTypes, sequential.scriptTypes)
+        }
+        if !reflect.DeepEqual(merged.nsizes.counts, sequential.nsizes.counts) {
+            t.Errorf("merged %v: nsizes %v, want %v", order, merged.nsizes.counts, sequential.nsizes.counts)
+        }
+    }
+}
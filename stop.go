@@ -0,0 +1,23 @@
+package main
+
+import "sync/atomic" // set from the signal handler (or the watchdog goroutine), read by the main loop
+
+// Stopping Early (ctrl-c with -sort, -stall-abort)
+// ------------------------------------------------
+// Exiting straight from another goroutine skips all the deferred clean up in main (removing the -sort temp files,
+// flushing the buffered output), and races with whatever the main goroutine is in the middle of doing with them (e.g.
+// writing a run). So instead they ask the scan to stop, and the main loop checks between entries and returns the normal
+// way, with the exit code they asked for.
+type scanStop struct {
+    code atomic.Int32 // exit code to stop with (0 = keep going)
+}
+
+// request a stop (only the first one counts)
+func (s *scanStop) request(code int) {
+    s.code.CompareAndSwap(0, int32(code))
+}
+
+// requested returns the exit code to stop with, or 0 if nothing has asked the scan to stop
+func (s *scanStop) requested() int {
+    return int(s.code.Load())
+}
@@ -0,0 +1,26 @@
+package main
+
+// Block Subsidy (epoch, block_subsidy)
+// -------------
+// The block reward started at 50 BTC and halves every 210,000 blocks, so the height of a utxo tells us which halving
+// epoch it was created in, and what the subsidy was at the time:
+//
+//   epoch 0 = blocks 0-209999        50 BTC
+//   epoch 1 = blocks 210000-419999   25 BTC
+//   epoch 2 = blocks 420000-629999   12.5 BTC
+//   ...
+const halvingInterval = 210000
+const initialSubsidy = 50 * 100000000 // satoshis
+
+func halvingEpoch(height int) int {
+    return height / halvingInterval
+}
+
+// blockSubsidy returns the subsidy in satoshis (shifting right halves it, and it goes to 0 after 64 halvings like in Bitcoin Core)
+func blockSubsidy(height int) int {
+    epoch := halvingEpoch(height)
+    if epoch >= 64 {
+        return 0
+    }
+    return initialSubsidy >> uint(epoch)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "encoding/json"
+import "os"
+import "time"
+
+// Summary File (-summary-interval)
+// --------------------------------
+// Every -summary-interval the stats so far get written to -summary-file, so you can keep an eye on what the data looks
+// like during a long run (e.g. with `watch cat utxodump.csv.summary.json`). The file is written to a temp file first and
+// then renamed over the old one, so anything reading it never sees half a file.
+type summary struct {
+    Time              string         `json:"time"`
+    Entries           int            `json:"entries"`                       // leveldb entries read so far
+    UTXOs             int            `json:"utxos"`                         // utxos read so far
+    TotalAmount       int            `json:"total_amount"`                  // satoshis
+    ScriptTypes       map[string]int `json:"script_types,omitempty"`
+    DistinctTxids     int            `json:"distinct_txids,omitempty"`
+    DistinctAddresses int            `json:"distinct_addresses,omitempty"`
+    BestBlock         string         `json:"best_block,omitempty"`          // -show-tip
+    Finished          bool           `json:"finished"`
+}
+
+func writeSummary(file string, s summary) error {
+    s.Time = time.Now().UTC().Format(time.RFC3339)
+    data, err := json.MarshalIndent(s, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    tmp := file + ".tmp"
+    if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, file) // atomic
+}
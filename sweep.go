@@ -0,0 +1,34 @@
+package main
+
+// Sweepable (-feerate)
+// --------------------
+// A utxo is only worth spending if it's worth more than the fee it costs to include it as an input in a transaction.
+// The size of the input depends on the script type, so these are rough estimates (in vbytes) of a typical input:
+//
+//   p2pkh  148   = 32 txid + 4 vout + 1 scriptsig length + 107 scriptsig (72 sig + 33 compressed pubkey) + 4 sequence
+//   p2pk   114   = 32 txid + 4 vout + 1 scriptsig length + 73 scriptsig (72 sig) + 4 sequence
+//   p2sh   91    = assumes it's wrapping a p2wpkh (nested segwit), which is the most common
+//   p2ms   114   = assumes 1-of-n (OP_0 + one sig), which most bare multisig outputs are
+//   p2wpkh 68    = 41 + witness (72 sig + 33 pubkey) / 4
+//   p2wsh  104.5 = assumes 2-of-3 multisig in the witness script
+//   p2tr   57.5  = 41 + witness (64 schnorr sig) / 4 (key path spend)
+//
+// Anything else (non-standard) can't be estimated, so it never counts as sweepable.
+var inputVsize = map[string]float64{
+    "p2pkh":  148,
+    "p2pk":   114,
+    "p2sh":   91,
+    "p2ms":   114,
+    "p2wpkh": 68,
+    "p2wsh":  104.5,
+    "p2tr":   57.5,
+}
+
+// sweepable returns true if the amount (satoshis) is more than the fee (sat/vB) it would cost to spend it
+func sweepable(amount int, scriptType string, feerate float64) bool {
+    vsize, ok := inputVsize[scriptType]
+    if !ok {
+        return false
+    }
+    return float64(amount) > vsize * feerate
+}
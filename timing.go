@@ -0,0 +1,66 @@
+package main
+
+import "fmt"
+import "sort"
+import "time"
+
+// Timing (-timing)
+// ----------------
+// Shows how long each part of decoding a utxo takes, so we know where to focus when making things faster.
+// Calling time.Now() between every step would slow everything down (and skew the results), so only one in every
+// -timing-sample utxos gets timed.
+type phaseTimer struct {
+    every    int                      // time one utxo in every n
+    sampling bool                     // is the current utxo being timed?
+    samples  int                      // number of utxos timed
+    last     time.Time                // end of the last phase
+    totals   map[string]time.Duration // total time spent in each phase
+}
+
+func newPhaseTimer(enabled bool, every int) *phaseTimer {
+    if !enabled {
+        every = 0
+    } else if every < 1 {
+        every = 1
+    }
+    return &phaseTimer{every: every, totals: map[string]time.Duration{}}
+}
+
+// begin starts timing the utxo (if it's one of the sampled ones)
+func (t *phaseTimer) begin(i int) {
+    t.sampling = t.every > 0 && i % t.every == 0
+    if t.sampling {
+        t.samples++
+        t.last = time.Now()
+    }
+}
+
+// mark adds the time since the last mark to the given phase
+func (t *phaseTimer) mark(phase string) {
+    if !t.sampling {
+        return
+    }
+    now := time.Now()
+    t.totals[phase] += now.Sub(t.last)
+    t.last = now
+}
+
+// report prints the percentage of time spent in each phase (biggest first)
+func (t *phaseTimer) report() {
+    if t.every == 0 || t.samples == 0 {
+        return
+    }
+
+    var total time.Duration
+    phases := []string{}
+    for phase, d := range t.totals {
+        total += d
+        phases = append(phases, phase)
+    }
+    sort.Slice(phases, func(i, j int) bool { return t.totals[phases[i]] > t.totals[phases[j]] })
+
+    fmt.Printf("Timing (%d utxos sampled, 1 in %d):\n", t.samples, t.every)
+    for _, phase := range phases {
+        fmt.Printf(" %-12s %5.1f%%  %v per utxo\n", phase, 100 * float64(t.totals[phase]) / float64(total), t.totals[phase] / time.Duration(t.samples))
+    }
+}
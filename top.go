@@ -0,0 +1,92 @@
+package main
+
+import "container/heap" // min-heap of the largest utxos so far
+import "sort"
+import "strconv"
+
+// Top UTXOs (-top-utxos)
+// ----------------------
+// Only writes the N individual utxos with the biggest amounts, largest first. This keeps a min-heap of the N biggest
+// utxos seen so far (the smallest of them is always at the top), so each utxo only has to be compared with that one
+// to see if it makes the cut, and it never holds more than N rows in memory (instead of sorting the whole set).
+type topWriter struct {
+    out    rowWriter // the writer the top rows get passed on to at the end
+    n      int
+    fields []string
+    rows   topHeap
+    seen   int // number of rows so far (to keep the chainstate order between equal amounts)
+}
+
+type topRow struct {
+    amount int
+    seen   int
+    output map[string]string
+}
+
+// topHeap is a min-heap by amount (and later rows count as smaller, so the first of the equal amounts get kept)
+type topHeap []topRow
+
+func (h topHeap) Len() int            { return len(h) }
+func (h topHeap) Less(i, j int) bool  { return topLess(h[i], h[j]) }
+func (h topHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x interface{}) { *h = append(*h, x.(topRow)) }
+func (h *topHeap) Pop() interface{} {
+    old := *h
+    x := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return x
+}
+
+func topLess(a, b topRow) bool {
+    if a.amount != b.amount {
+        return a.amount < b.amount
+    }
+    return a.seen > b.seen
+}
+
+func newTopWriter(out rowWriter, n int) *topWriter {
+    return &topWriter{out: out, n: n}
+}
+
+func (t *topWriter) Header(fields []string) error {
+    t.fields = fields
+    return t.out.Header(fields)
+}
+
+func (t *topWriter) Row(output map[string]string) error {
+    amount, _ := strconv.Atoi(output["amount"])
+    row := topRow{amount: amount, seen: t.seen}
+    t.seen++
+
+    if len(t.rows) == t.n {
+        if !topLess(t.rows[0], row) { // not bigger than the smallest we've got
+            return nil
+        }
+        row.output = t.copyRow(output)
+        t.rows[0] = row
+        heap.Fix(&t.rows, 0)
+        return nil
+    }
+    row.output = t.copyRow(output)
+    heap.Push(&t.rows, row)
+    return nil
+}
+
+// copyRow copies the fields we need (the main loop reuses the output map)
+func (t *topWriter) copyRow(output map[string]string) map[string]string {
+    row := make(map[string]string, len(t.fields))
+    for _, v := range t.fields {
+        row[v] = output[v]
+    }
+    return row
+}
+
+func (t *topWriter) Close() error {
+    sort.Slice(t.rows, func(i, j int) bool { return t.rows.Less(j, i) }) // largest first
+    for _, row := range t.rows {
+        if err := t.out.Row(row.output); err != nil {
+            return err
+        }
+    }
+    return t.out.Close()
+}
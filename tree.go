@@ -0,0 +1,97 @@
+package main
+
+import "fmt"
+import "sort"
+
+// Tree Summary (-tree-summary)
+// ----------------------------
+// A quick overview of what the utxo set is made of, printed at the end as a tree: the total, then each script type
+// (biggest share of the value first), then the biggest few amounts for each type:
+//
+//   UTXO Set: 81234567 utxos, 19400000.00000000 BTC
+//    ├─ p2wpkh       40000000 utxos (49.24%)   7000000.00000000 BTC (36.08%)
+//    │   ├─ 94643.29127000 BTC
+//    │   ├─ 69370.10368000 BTC
+//    │   └─ 39855.10434000 BTC
+//    └─ p2pkh        ...
+//
+// Only the counts, the totals, and the top few amounts for each type are kept, so it doesn't use much memory.
+type treeSummary struct {
+    types map[string]*treeBranch
+}
+
+type treeBranch struct {
+    count  int
+    amount int   // satoshis
+    top    []int // the biggest amounts, largest first
+}
+
+const treeTopAmounts = 3 // number of amounts shown under each type
+
+func newTreeSummary() *treeSummary {
+    return &treeSummary{types: map[string]*treeBranch{}}
+}
+
+func (t *treeSummary) add(scriptType string, amount int) {
+    branch, ok := t.types[scriptType]
+    if !ok {
+        branch = &treeBranch{}
+        t.types[scriptType] = branch
+    }
+    branch.count++
+    branch.amount += amount
+
+    // keep the top few in order (there are only a few, so just insert it in the right place)
+    if len(branch.top) == treeTopAmounts && amount <= branch.top[len(branch.top)-1] {
+        return
+    }
+    i := sort.Search(len(branch.top), func(i int) bool { return branch.top[i] < amount })
+    branch.top = append(branch.top, 0)
+    copy(branch.top[i+1:], branch.top[i:])
+    branch.top[i] = amount
+    if len(branch.top) > treeTopAmounts {
+        branch.top = branch.top[:treeTopAmounts]
+    }
+}
+
+func (t *treeSummary) print(human humanFormat) {
+    totalCount, totalAmount := 0, 0
+    types := make([]string, 0, len(t.types))
+    for scriptType, branch := range t.types {
+        totalCount += branch.count
+        totalAmount += branch.amount
+        types = append(types, scriptType)
+    }
+    sort.Slice(types, func(i, j int) bool { // biggest value first (then by name, so it's the same every time)
+        a, b := t.types[types[i]], t.types[types[j]]
+        if a.amount != b.amount {
+            return a.amount > b.amount
+        }
+        return types[i] < types[j]
+    })
+
+    fmt.Printf("UTXO Set: %s utxos, %s BTC\n", human.count(totalCount), human.number(formatBTC(totalAmount)))
+    for i, scriptType := range types {
+        branch := t.types[scriptType]
+        fork, trunk := "├─", "│ "
+        if i == len(types)-1 {
+            fork, trunk = "└─", "  "
+        }
+        fmt.Printf(" %s %-12s %12s utxos (%6.2f%%) %20s BTC (%6.2f%%)\n", fork, scriptType, human.count(branch.count), percent(branch.count, totalCount), human.number(formatBTC(branch.amount)), percent(branch.amount, totalAmount))
+        for j, amount := range branch.top {
+            leaf := "├─"
+            if j == len(branch.top)-1 {
+                leaf = "└─"
+            }
+            fmt.Printf(" %s  %s %s BTC\n", trunk, leaf, human.number(formatBTC(amount)))
+        }
+    }
+}
+
+// percent of the total (0 if the total is 0)
+func percent(n int, total int) float64 {
+    if total == 0 {
+        return 0
+    }
+    return float64(n) / float64(total) * 100
+}
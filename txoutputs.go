@@ -0,0 +1,79 @@
+package main
+
+import "strconv"
+
+// Transaction Outputs (tx_output_index, tx_output_count)
+// ------------------------------------------------------
+// The chainstate is sorted by txid, so the unspent outputs of a transaction all come one after the other. This numbers
+// each output by where it comes among the outputs of its transaction (tx_output_index, from 0), which isn't the same as
+// the vout when some of the outputs have already been spent (or filtered out):
+//
+//   txid   vout  tx_output_index  tx_output_count
+//   0e3e…  0     0                2
+//   0e3e…  1     1                2
+//   4a5e…  0     0                1
+//
+// The tx_output_count isn't known until the txid changes, so for that the rows for the current transaction are held
+// back (copied) and only passed on when the next txid turns up (or at the end). That's only ever one transaction's
+// worth of rows, which is usually just a few, but can be a few thousand for the biggest batch payouts.
+//
+// This wraps everything else (before any -top-utxos or -sort), so the outputs are numbered in the order of the scan.
+type txOutputsWriter struct {
+    out   rowWriter
+    count bool                // hold the rows back to fill in tx_output_count (otherwise each row gets passed straight on)
+    txid  string              // the transaction the held back rows are for
+    index int                 // number of rows for this txid so far
+    held  []map[string]string // (only with count)
+}
+
+func newTxOutputsWriter(out rowWriter, count bool) *txOutputsWriter {
+    return &txOutputsWriter{out: out, count: count}
+}
+
+func (t *txOutputsWriter) Header(fields []string) error {
+    return t.out.Header(fields)
+}
+
+func (t *txOutputsWriter) Row(output map[string]string) error {
+    if output["txid"] != t.txid {
+        if err := t.flush(); err != nil {
+            return err
+        }
+        t.txid = output["txid"]
+        t.index = 0
+    }
+    output["tx_output_index"] = strconv.Itoa(t.index)
+    t.index++
+
+    if !t.count {
+        return t.out.Row(output)
+    }
+
+    // copy the row (the main loop reuses the output map), all of it as -top-utxos needs the amount even if it's not a -f field
+    row := make(map[string]string, len(output))
+    for k, v := range output {
+        row[k] = v
+    }
+    t.held = append(t.held, row)
+    return nil
+}
+
+// flush passes on the rows held back for the current transaction, now that we know how many there are
+func (t *txOutputsWriter) flush() error {
+    count := strconv.Itoa(len(t.held))
+    for _, row := range t.held {
+        row["tx_output_count"] = count
+        if err := t.out.Row(row); err != nil {
+            return err
+        }
+    }
+    t.held = t.held[:0]
+    return nil
+}
+
+func (t *txOutputsWriter) Close() error {
+    if err := t.flush(); err != nil {
+        return err
+    }
+    return t.out.Close()
+}
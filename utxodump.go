@@ -2,84 +2,403 @@ package main
 
 // local packages
 import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb" // chainstate leveldb decoding functions
-import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/keys"   // bitcoin addresses
-import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/bech32" // segwit bitcoin addresses
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript" // script templates
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/muhash" // utxo set hash
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/utxo" // splitting up the keys and values
 
 import "github.com/syndtr/goleveldb/leveldb" // go get github.com/syndtr/goleveldb/leveldb
-import "github.com/syndtr/goleveldb/leveldb/opt" // set no compression when opening leveldb
 import "flag"         // command line arguments
 import "fmt"
 import "os"           // open file for writing
+import "io"           // -split-by-type doesn't write to the -o file
 import "os/exec"      // execute shell command (check bitcoin isn't running)
 import "bufio"        // bulk writing to file
+import "bytes"        // compare txids
 import "encoding/hex" // convert byte slice to hexadecimal
 import "strings"      // parsing flags from command line
+import "os/signal"    // clean up temp files if we get interrupted
+import "time"         // -summary-interval
+import "math/big"     // coindays total
 
 
 func main() {
 
-    // Check bitcoin isn't running first
-    cmd := exec.Command("bitcoin-cli", "getnetworkinfo")
-    _, err := cmd.Output()
-    if err == nil {
-        fmt.Println("Bitcoin is running, shutdown with `bitcoin-cli stop` first. We don't want to access the chainstate LevelDB while Bitcoin is running.")
-        return
-    }
-
     // Set default chainstate LevelDB and output file
     defaultfolder := fmt.Sprintf("%s/.btcprivate/chainstate/", os.Getenv("HOME")) // %s = string
     defaultfile := "utxodump.csv"
 
     // Command Line Options (Flags)
     chainstate := flag.String("db", defaultfolder, "Location of bitcoin chainstate db.") // chainstate folder
+    datadir := flag.String("datadir", "", "Bitcoin Core data directory to find the chainstate in (instead of giving the chainstate folder with -db).")
     file := flag.String("o", defaultfile, "Name of file to dump utxo list to.") // output file
-    fields := flag.String("f", "count,txid,vout,amount,type,address", "Fields to include in output. [count,txid,vout,height,amount,coinbase,nsize,script,type,address]")
+    fields := flag.String("f", "count,txid,vout,amount,type,address", "Fields to include in output. [count,txid,vout,height,amount,coinbase,nsize,script,type,address,p2sh_subtype,sweepable,epoch,block_subsidy,amount_btc,descriptor,reused,outpoint,value_len,value_usd,row_hash,coindays,height_code,pubkey_uncompressed,amount_exp,amount_mantissa,witness_future,tx_output_index,tx_output_count,address_payload,amount_compressed,address_legacy,dust_ratio,core_serialization,hash_leading_zeros,pubkey_parity,outpoint_bin]")
+    compute := flag.String("compute", "", "Extra fields to work out for each utxo without writing them to the file (e.g. for use in a -template). The fields in -f always get worked out.")
+    preset := flag.String("preset", "", "Use a preset list of fields (extra -f fields get added to it). [minimal = txid,vout | addresses = address,amount | analysis = height,amount,type,address | full = every field]")
     testnetflag := flag.Bool("testnet", false, "Is the chainstate leveldb for testnet?") // true/false
+    networkFlag := flag.String("network", "", "Network the chainstate is for, which sets the address prefixes and the folder -datadir looks in. [mainnet | testnet3 | testnet4] (default mainnet, or testnet if the -db path has testnet in it)")
+    noAmountDecode := flag.Bool("no-amount-decode", false, "Don't decompress the amounts, for chains that store them differently (e.g. sidechains). Use the amount_compressed field to get the amount as it's stored, none of the other amount fields or stats can be used.")
+    networkParamsFlag := flag.String("network-params", "", "Address prefixes for other coins that use the same chainstate format (e.g. 'p2pkh=0x3a,p2sh=0x32,hrp=qc').")
+    verboseErrors := flag.Bool("verbose-errors", false, "Print (and log) everything about each entry that fails to decode: the key, the value, the obfuscate key, the deobfuscated value, the offset it failed at, and what had been decoded up to then.")
+    debug := flag.Bool("debug", false, "Print every key in the chainstate that isn't a utxo or one of the known housekeeping keys (these are always counted, and the first of each is logged). Also prints the stack trace of any entry that panics.")
+    compactProgressFlag := flag.Bool("compact-progress", false, "Show the progress on one line on stderr that gets updated in place (with the rate and time elapsed), instead of a new line every 100,000 utxos.")
+    treeSummaryFlag := flag.Bool("tree-summary", false, "Print a tree of what the utxo set is made of at the end (the count and value for each script type, and the biggest amounts for each one).")
+    humanFlag := flag.Bool("human", false, "Put thousands separators in the numbers in the stats at the end (e.g. 81,234,567). The rows in the file are always plain numbers.")
+    noBuffer := flag.Bool("no-buffer", false, "Write each row to the file straight away instead of buffering them, so the file shows exactly how far it got if it crashes (a lot slower).")
     verbose := flag.Bool("v", false, "Print utxos as we process them (will be about 3 times slower with this though).")
+    format := flag.String("format", "csv", "Format of the output file. [csv,arrow,template,json,grouped-json,sql-insert,xlsx,cbor,ndjson-typed,redis,importdescriptors,amount-map,amount-map-binary,leveldb]")
+    jsonScript := flag.Bool("json-script", false, "Put the script fields in a script object with -format json, along with the hash160, the full scriptPubKey, and its asm.")
+    batchSize := flag.Int("batch-size", 0, "Number of rows in each record batch when using -format arrow (default 65536), or in each INSERT when using -format sql-insert (default 1000), or commands in each pipeline when using -format redis (default 1000), or utxos in each write when using -format leveldb (default 10000).")
+    xlsxMaxRows := flag.Int("xlsx-max-rows", 10000, "Stop with an error if -format xlsx gets more than this many rows (Excel can't go over 1048575).")
+    crlf := flag.Bool("crlf", false, "End each line with \\r\\n (Windows line endings) instead of \\n in the text formats (csv, template, json, grouped-json, sql-insert, ndjson-typed, amount-map).")
+    redisAddr := flag.String("redis-addr", "", "Send the utxos straight to the redis server at this address (e.g. localhost:6379) when using -format redis, instead of writing the commands to the file.")
+    redisSet := flag.String("redis-address-set", "", "Also add the address of each utxo to the redis set with this name when using -format redis.")
+    importTimestamp := flag.String("import-timestamp", "height", "Timestamp for each descriptor when using -format importdescriptors. [height = estimated from the height of its oldest utxo, so the wallet rescans from there | now = don't rescan]")
+    table := flag.String("table", "utxos", "Table name to INSERT INTO when using -format sql-insert.")
+    tmpl := flag.String("template", "", "Go text/template to write for each utxo when using -format template (e.g. '{{.txid}}:{{.vout}} has {{.amount}} sats').")
+    sortField := flag.String("sort", "", "Sort the output by this field (must be one of the -f fields).")
+    groupBy := flag.String("group-by", "", "What to group the utxos by with -format grouped-json. [txid (default) | address = sorts by address first, and adds the number of utxos and the total amount for each address]")
+    sortDesc := flag.Bool("sort-desc", false, "Sort largest first when using -sort.")
+    voutHex := flag.Bool("vout-hex", false, "Write the vout as 8 hex digits (e.g. 00000001) instead of a decimal number.")
+    canonical := flag.Bool("canonical", false, "Sort the output by outpoint (txid, then vout), or by outpoint after the -sort field, so the same utxo set always gives exactly the same file.")
+    topUTXOs := flag.Int("top-utxos", 0, "Only write the N utxos with the biggest amounts (largest first).")
+    sortMem := flag.Int("sort-mem", 1000000, "Number of rows to sort in memory at a time when using -sort or -canonical (bigger runs use more memory but fewer temp files).")
+    offsetIndexFile := flag.String("offset-index", "", "Also write a txid,offset index to this file, giving the byte in the output file where each transaction's rows start (csv, template, json, cbor and ndjson-typed formats only).")
+    logFile := flag.String("log", "", "Write a log of events (db opened, checkpoints, errors, final stats) as json lines to this file.")
+    tipHeight := flag.Int("tip-height", -1, "Height of the chain tip the chainstate is at (used to work out which coinbase outputs are still immature in the stats).")
+    sinceHeight := flag.Int("since-height", -1, "Only dump utxos created at this height or later (for incremental dumps).")
+    redeemScriptsFile := flag.String("redeem-scripts", "", "File of known redeem scripts (one hex script per line) for working out the p2sh_subtype of P2SH outputs.")
+    timing := flag.Bool("timing", false, "Show how much time is spent in each part of decoding (deobfuscating, varints, addresses, writing).")
+    timingSample := flag.Int("timing-sample", 100, "Only time one in every n utxos when using -timing (so that the timing doesn't slow everything down).")
+    verifyDecodeSample := flag.Int("verify-decode-sample", 0, "Decode one in every n utxos again from scratch (twice) and stop with an error if it doesn't match the row, to check nothing is going wrong with the reused buffers.")
+    noObfuscation := flag.Bool("no-obfuscation", false, "Read the values as they are, without XORing them with the obfuscate key (for chainstates that aren't obfuscated).")
+    reverse := flag.Bool("reverse", false, "Go through the chainstate backwards (largest key first).")
+    summaryInterval := flag.Duration("summary-interval", 0, "Write the stats so far to -summary-file at this interval (e.g. 5m).")
+    stallTimeout := flag.Duration("stall-timeout", 0, "Warn if nothing has been processed for this long (e.g. 60s), to catch a scan that has hung.")
+    strict := flag.Bool("strict", false, "Stop at the first malformed entry (e.g. a value that's been cut short, or one that panics) instead of skipping it.")
+    maxPanics := flag.Int("max-panics", 100, "Stop the scan after this many entries have panicked while being decoded (they get logged and skipped until then). [0 = stop at the first one, -1 = no limit]")
+    stallAbort := flag.Bool("stall-abort", false, "Exit with an error (status 3) instead of just warning when -stall-timeout is reached.")
+    summaryFile := flag.String("summary-file", "", "File for -summary-interval to write to (default is the output file with .summary.json on the end).")
+    price := flag.String("price", "", "Price of 1 BTC in USD (e.g. 67123.45) for the value_usd field and the total.")
+    feerate := flag.Float64("feerate", 1, "Fee rate (sat/vB) for working out if each utxo is worth spending (the sweepable field).")
+    prefetch := flag.Int("prefetch", 0, "Read ahead from the database on another goroutine, keeping up to this many batches of 1000 entries ready for decoding (0 reads and decodes in turn).")
+    parallelEncode := flag.Int("parallel-encode", 0, "Number of goroutines to work out the addresses with (0 works them out one at a time in the main loop).")
+    readOnly := flag.Bool("readonly", false, "Open the chainstate read-only, so nothing in the folder gets written to (works on read-only storage too).")
+    fromSnapshot := flag.Bool("from-snapshot", false, "The -db is a filesystem snapshot (LVM, ZFS, btrfs) of the chainstate, so it's fine for bitcoind to be running. Opens it -readonly.")
+    force := flag.Bool("force", false, "Open the chainstate without using its LOCK file at all (with -readonly). Only use this if nothing else is using the chainstate.")
+    maxOpenFiles := flag.Int("max-open-files", 0, "Maximum number of LevelDB files to keep open at the same time (default 500). Use a lower number if you have a low ulimit -n.")
+    blockCache := flag.Int("block-cache", 0, "Size of the LevelDB block cache in MB (default 8). A full dump reads every block once, so a bigger cache doesn't usually help.")
+    disableBlockCache := flag.Bool("disable-block-cache", false, "Don't cache LevelDB blocks at all (for a straight read through the database, where blocks never get read twice).")
+    iteratorSamplingRate := flag.Int("iterator-sampling-rate", 0, "Bytes between LevelDB's read samples, which it uses to decide when to compact (default 1048576). Use -readonly if you don't want reads to cause compactions.")
+    openRetries := flag.Int("open-retries", 5, "Number of times to try opening LevelDB again if it's locked (e.g. bitcoind has only just stopped).")
+    openRetryDelay := flag.Duration("open-retry-delay", 500*time.Millisecond, "Delay before the first retry if LevelDB is locked (doubles after each retry).")
+    showTip := flag.Bool("show-tip", false, "Show the hash of the block the chainstate is at (the best block), so you know exactly which block the dump is for.")
+    countTxids := flag.Bool("count-txids", false, "Count the number of distinct transactions the utxos belong to.")
+    muhashFlag := flag.Bool("muhash", false, "Work out the MuHash3072 of the utxo set, to compare with the muhash from bitcoin-cli gettxoutsetinfo muhash.")
+    chainHash := flag.Bool("chain-hash", false, "Add a row_hash field that chains each row to the one before it (sha256), and show the final hash at the end, so the file can be checked for changes.")
+    verifyTypes := flag.Bool("verify-types", false, "Check the type worked out from the nsize against the type of the full script (matched against the standard templates), and report any that don't match.")
+    countAddresses := flag.Bool("count-addresses", false, "Count the number of distinct addresses the utxos are locked to.")
+    distinctMethod := flag.String("distinct-method", "exact", "How to remember the addresses we've seen for -count-addresses and the reused field (and the outpoints for -detect-duplicates full). [exact = uses more memory | bloom = bounded memory, but can undercount by the -bloom-fp rate]")
+    bloomFP := flag.Float64("bloom-fp", 0.001, "Target false positive rate for -distinct-method bloom.")
+    maxMemory := flag.Int("max-memory", 0, "Try to keep the memory used under this many MB, by switching -sort, -count-addresses, the reused field, and -detect-duplicates full over to versions that use less memory (on disk or approximate) when it gets close. [0 = no limit]")
+    detectDuplicates := flag.String("detect-duplicates", "", "Check that no outpoint (txid:vout) comes out twice, which would mean a decoding bug or a corrupted database. [adjacent = compare with the one before (no extra memory) | full = remember them all, using the -distinct-method]")
+    addressPrefix := flag.String("address-prefix", "", "Only dump utxos where the hash160 or witness program of the address starts with these bytes (hex).")
+    includeAddresses := flag.String("address", "", "Only dump utxos locked to these addresses (comma-separated).")
+    includeAddressesFile := flag.String("addresses-file", "", "Only dump utxos locked to the addresses in this file (one per line).")
+    addressPrefixMatch := flag.Bool("address-prefix-match", false, "Treat the -address and -exclude-address addresses (and files) as prefixes, e.g. bc1qxyz matches every address that starts with bc1qxyz.")
+    excludeAddresses := flag.String("exclude-address", "", "Skip utxos locked to these addresses (comma-separated).")
+    appendFlag := flag.Bool("append", false, "Add the rows to the end of the -o file instead of overwriting it (csv only). The header in the file has to match the -f fields, and doesn't get written again.")
+    splitByType := flag.Bool("split-by-type", false, "Write the utxos for each script type to their own file, named after the -o file (e.g. utxodump.p2wpkh.csv).")
+    nonStandardOnly := flag.Bool("non-standard-only", false, "Only dump utxos with a script that doesn't match any of the standard types (the script field gets added to the output).")
+    scriptOpcodeStats := flag.Bool("script-opcode-stats", false, "Split the non-standard scripts up in to opcodes, and show the most common opcodes (and how many of the scripts use each one) at the end.")
+    excludeAddressesFile := flag.String("exclude-addresses-file", "", "Skip utxos locked to the addresses in this file (one per line).")
+    atomicFlag := flag.Bool("atomic", false, "Write the output to the -o file with .tmp on the end, and only rename it to the -o file once the dump has finished (if it doesn't finish, the .tmp file is left and the exit status is 1).")
     flag.Parse() // execute command line parsing for all declared flags
 
+    // Exit status (this runs after all the other deferred clean up) - anything that stops the dump early sets this to 1, so a
+    // script or cron job can tell it didn't finish
+    exitCode := 0
+    defer func() {
+        if exitCode != 0 {
+            os.Exit(exitCode)
+        }
+    }()
+
+    // Check bitcoin isn't running first (unless we're reading a snapshot, which bitcoind isn't using)
+    if !*fromSnapshot {
+        cmd := exec.Command("bitcoin-cli", "getnetworkinfo")
+        _, err := cmd.Output()
+        if err == nil {
+            fmt.Println("Bitcoin is running, shutdown with `bitcoin-cli stop` first. We don't want to access the chainstate LevelDB while Bitcoin is running.")
+            fmt.Println("(If the chainstate you're dumping is a snapshot, use -from-snapshot.)")
+            exitCode = 1
+            return
+        }
+    }
+
+    // A snapshot is a copy that's supposed to stay the same, so don't write anything to it (see readonly.go)
+    if *fromSnapshot {
+        *readOnly = true
+    }
+
+    // Network (-network, or -testnet for either testnet)
+    networkName := *networkFlag
+    if networkName == "" {
+        networkName = "mainnet"
+        if *testnetflag {
+            networkName = "testnet"
+        }
+    } else if _, ok := networks[networkName]; !ok || networkName == "testnet" {
+        fmt.Printf("'%s' is not a network you can use. Choose from the following: mainnet,testnet3,testnet4\n", networkName)
+        exitCode = 1
+        return
+    } else if *testnetflag && networkName == "mainnet" {
+        fmt.Println("Use either -testnet or -network mainnet, not both.")
+        exitCode = 1
+        return
+    }
+
+    // Find the chainstate in the data directory (-datadir)
+    if *datadir != "" {
+        dbGiven := false
+        flag.Visit(func(f *flag.Flag) {
+            if f.Name == "db" {
+                dbGiven = true
+            }
+        })
+        if dbGiven {
+            fmt.Println("Use either -db or -datadir, not both.")
+            exitCode = 1
+            return
+        }
+
+        path, err := chainstateFromDatadir(*datadir, networkName)
+        if err != nil {
+            fmt.Println(err)
+            exitCode = 1
+            return
+        }
+        *chainstate = path
+    }
+
+    // Make sure a -from-snapshot isn't actually the chainstate bitcoind is using (see snapshot.go)
+    if *fromSnapshot {
+        if live, running := runningChainstate(); running && live == "" {
+            fmt.Println("Warning: Bitcoin is running, and we couldn't find out where its chainstate is to check the -db isn't it. Nothing stops the live chainstate from being read, so make sure the -db is the snapshot.")
+        } else if running && sameFolder(live, *chainstate) {
+            fmt.Printf("%s is the chainstate Bitcoin is using right now, not a snapshot. Point -db at the snapshot, or shutdown with `bitcoin-cli stop` first.\n", *chainstate)
+            exitCode = 1
+            return
+        }
+    }
+
+    // Don't write any of the output files in to the chainstate folder
+    if err := checkOutsideChainstate(*chainstate, map[string]string{"o": *file, "log": *logFile, "summary-file": *summaryFile, "offset-index": *offsetIndexFile}); err != nil {
+        fmt.Println(err)
+        exitCode = 1
+        return
+    }
+
+    // Log File
+    logger, logCloser, err := newLogger(*logFile, *verbose)
+    if err != nil {
+        fmt.Println("Couldn't create log file.")
+        fmt.Println(err)
+        exitCode = 1
+        return
+    }
+    defer logCloser.Close()
+
     // Mainnet or Testnet (for encoding addresses correctly)
-    testnet := false
-    if *testnetflag == true { // check testnet flag
-        testnet = true
-    } else { // only check the chainstate path if testnet flag has not been explicitly set to true
-        if strings.Contains(*chainstate, "testnet") { // check the chainstate path
+    testnet := networks[networkName].testnet
+    if *networkFlag == "" && !*testnetflag { // only check the chainstate path if the network hasn't been given
+        if strings.Contains(*chainstate, "testnet") { // check the chainstate path (testnet3 or testnet4)
             testnet = true
         }
     }
 
+    // Address prefixes for the network (-testnet, -network, -network-params)
+    params := mainnetParams
+    if testnet {
+        params = testnetParams
+    }
+    if *networkParamsFlag != "" {
+        params, err = parseNetworkParams(params, *networkParamsFlag)
+        if err != nil {
+            fmt.Println(err)
+            exitCode = 1
+            return
+        }
+    }
+
+    // Address filters (decode the addresses once up front)
+    filter, err := newAddressFilter(*includeAddresses, *includeAddressesFile, *excludeAddresses, *excludeAddressesFile, *addressPrefixMatch, params)
+    if err != nil {
+        fmt.Println(err)
+        exitCode = 1
+        return
+    }
+
+    // Fiat price (-price)
+    var priceCents uint64
+    if *price != "" {
+        priceCents, err = parsePrice(*price)
+        if err != nil {
+            fmt.Println(err)
+            exitCode = 1
+            return
+        }
+    }
+
+    // Address hash prefix (-address-prefix)
+    hashPrefix, err := hex.DecodeString(*addressPrefix)
+    if err != nil {
+        fmt.Println("-address-prefix needs to be hex (e.g. 62e907).")
+        exitCode = 1
+        return
+    }
+
+    // Distinct addresses (-count-addresses)
+    var addressSet distinctSet
+    if *countAddresses {
+        addressSet, err = newDistinctSet(*distinctMethod, *bloomFP)
+        if err != nil {
+            fmt.Println(err)
+            exitCode = 1
+            return
+        }
+    }
+
+    // Outpoints we've seen so far (-detect-duplicates)
+    var duplicates *duplicateDetector
+    if *detectDuplicates != "" {
+        duplicates, err = newDuplicateDetector(*detectDuplicates, *distinctMethod, *bloomFP, logger)
+        if err != nil {
+            fmt.Println(err)
+            exitCode = 1
+            return
+        }
+    }
+
+    // Scripts we've seen so far (the reused field)
+    // This is set up after the fields have been checked (see below)
+    var seenScripts distinctSet
+
+    // Redeem scripts we know about (for the p2sh_subtype field)
+    redeemScripts, err := loadRedeemScripts(*redeemScriptsFile)
+    if err != nil {
+        fmt.Println(err)
+        exitCode = 1
+        return
+    }
+
     // Check chainstate LevelDB folder exists
     if _, err := os.Stat(*chainstate); os.IsNotExist(err) {
         fmt.Println("Couldn't find", *chainstate)
+        exitCode = 1
+        return
+    }
+
+    if *force && !*readOnly {
+        fmt.Println("-force can only be used with -readonly.")
+        exitCode = 1
+        return
+    }
+
+    // goleveldb v1.0.0 uses the default for anything under 1 (only later versions turn sampling off for a negative number),
+    // so don't pretend it does something different
+    if *iteratorSamplingRate < 0 {
+        fmt.Println("-iterator-sampling-rate has to be a number of bytes (or 0 for the default). To stop reads from causing compactions, use -readonly.")
+        exitCode = 1
         return
     }
 
     // Select bitcoin chainstate leveldb folder
     // open leveldb without compression to avoid corrupting the database for bitcoin
-    opts := &opt.Options{
-        Compression: opt.NoCompression,
-    }
+    opts := leveldbOptions(*readOnly, *maxOpenFiles, *blockCache, *disableBlockCache, *iteratorSamplingRate)
     // https://bitcoin.stackexchange.com/questions/52257/chainstate-leveldb-corruption-after-reading-from-the-database
     // https://github.com/syndtr/goleveldb/issues/61
     // https://godoc.org/github.com/syndtr/goleveldb/leveldb/opt
 
-    db, err := leveldb.OpenFile(*chainstate, opts) // You have got to dereference the pointer to get the actual value
+    db, err := openDB(*chainstate, opts, *force, *openRetries, *openRetryDelay, logger) // You have got to dereference the pointer to get the actual value
     if err != nil {
         fmt.Println("Couldn't open LevelDB.")
         fmt.Println(err)
+        if isTooManyFilesError(err) {
+            fmt.Println(tooManyFilesHelp)
+        }
+        logger.Error("couldn't open db", "path", *chainstate, "error", err.Error())
+        exitCode = 1
         return
     }
     defer db.Close()
+    logger.Info("db opened", "path", *chainstate, "network", networkName, "testnet", testnet)
 
     // Output Fields - build output from flags passed in
     output := map[string]string{} // we will add to this as we go through each utxo in the database
-    fieldsAllowed := []string{"count", "txid", "vout", "height", "coinbase", "amount", "nsize", "script", "type", "address"}
+    fieldsAllowed := []string{"count", "txid", "vout", "height", "coinbase", "amount", "nsize", "script", "type", "address", "p2sh_subtype", "sweepable", "epoch", "block_subsidy", "amount_btc", "descriptor", "reused", "outpoint", "value_len", "value_usd", "row_hash", "coindays", "height_code", "pubkey_uncompressed", "amount_exp", "amount_mantissa", "witness_future", "tx_output_index", "tx_output_count", "address_payload", "amount_compressed", "address_legacy", "dust_ratio", "core_serialization", "hash_leading_zeros", "pubkey_parity", "outpoint_bin"}
+
+    // Presets - expand to a list of fields, and any fields given with -f get added on the end
+    if *preset != "" {
+        presets := map[string]string{
+            "minimal":   "txid,vout",
+            "addresses": "address,amount",
+            "analysis":  "height,amount,type,address",
+            "full":      strings.Join(fieldsAllowed, ","),
+        }
+        presetFields, ok := presets[*preset]
+        if !ok {
+            fmt.Printf("'%s' is not a preset you can use. Choose from the following: minimal,addresses,analysis,full\n", *preset)
+            exitCode = 1
+            return
+        }
+
+        // check if -f was actually given (otherwise it's just the default fields)
+        fieldsGiven := false
+        flag.Visit(func(f *flag.Flag) {
+            if f.Name == "f" {
+                fieldsGiven = true
+            }
+        })
+        if fieldsGiven {
+            for _, v := range strings.Split(*fields, ",") {
+                if !strings.Contains(","+presetFields+",", ","+v+",") { // don't add fields that are already in the preset
+                    presetFields += "," + v
+                }
+            }
+        }
+        *fields = presetFields
+    }
+
+    // The script is the interesting bit of a non-standard utxo, so make sure it's in the output (-non-standard-only)
+    if *nonStandardOnly && !strings.Contains(","+*fields+",", ",script,") {
+        *fields += ",script"
+    }
+
+    // Grouping by address needs the address to sort by, and the amount for the totals (-group-by address)
+    if *groupBy == "address" {
+        for _, v := range []string{"address", "amount"} {
+            if !strings.Contains(","+*fields+",", ","+v+",") {
+                *fields += "," + v
+            }
+        }
+    }
+
+    // Chain hash goes on the end of the fields (-chain-hash)
+    if *chainHash && !strings.Contains(","+*fields+",", ",row_hash,") {
+        *fields += ",row_hash"
+    }
 
     // Create a map of selected fields
-    fieldsSelected := map[string]bool{"count":false, "txid":false, "vout":false, "height":false, "coinbase":false, "amount":false, "nsize":false, "script":false, "type":false, "address":false}
+    fieldsSelected := map[string]bool{"count":false, "txid":false, "vout":false, "height":false, "coinbase":false, "amount":false, "nsize":false, "script":false, "type":false, "address":false, "p2sh_subtype":false, "sweepable":false, "epoch":false, "block_subsidy":false, "amount_btc":false, "descriptor":false, "reused":false, "outpoint":false, "value_len":false, "value_usd":false, "row_hash":false, "coindays":false, "height_code":false, "pubkey_uncompressed":false, "amount_exp":false, "amount_mantissa":false, "witness_future":false, "tx_output_index":false, "tx_output_count":false, "address_payload":false, "amount_compressed":false, "address_legacy":false, "dust_ratio":false, "core_serialization":false, "hash_leading_zeros":false, "pubkey_parity":false, "outpoint_bin":false}
+
+    // Fields to work out = the fields to output (-f, in that order) + any extra fields to work out but not output (-compute)
+    fieldsComputed := strings.Split(*fields, ",")
+    if *compute != "" {
+        fieldsComputed = append(fieldsComputed, strings.Split(*compute, ",")...)
+    }
 
     // Check that all the given fields are included in the fieldsAllowed array
-    for _, v := range strings.Split(*fields, ",") {
+    for _, v := range fieldsComputed {
         exists := false
         for _, w := range fieldsAllowed {
             if v == w { // check each field against every element in the fieldsAllowed array
@@ -95,6 +414,7 @@ func main() {
             }
             fieldsList = fieldsList[:len(fieldsList)-1] // remove trailing comma
             fmt.Printf("Choose from the following: %s\n", fieldsList)
+            exitCode = 1
             return
         }
         // Set field in fieldsSelected map - helps to determine what and what not to calculate later on (to speed processing up)
@@ -103,387 +423,1212 @@ func main() {
         }
     }
 
-    // Open file to write results to.
-    f, err := os.Create(*file) // os.OpenFile("filename.txt", os.O_APPEND, 0666)
+    // Remembering every script for the reused field (same choice of exact or bloom as -count-addresses)
+    if fieldsSelected["reused"] {
+        seenScripts, err = newDistinctSet(*distinctMethod, *bloomFP)
+        if err != nil {
+            fmt.Println(err)
+            exitCode = 1
+            return
+        }
+    }
+
+    // The redis keys are made from the outpoint (and -redis-address-set needs the addresses)
+    if *format == "redis" {
+        fieldsSelected["txid"] = true
+        fieldsSelected["vout"] = true
+        if *redisSet != "" {
+            fieldsSelected["address"] = true
+        }
+    }
+
+    // The outputs are numbered by watching for the txid to change
+    if fieldsSelected["tx_output_index"] || fieldsSelected["tx_output_count"] {
+        fieldsSelected["txid"] = true
+    }
+
+    // Each row goes to the file for its type (-split-by-type)
+    if *splitByType {
+        fieldsSelected["type"] = true
+        if *format == "redis" || *offsetIndexFile != "" || *noBuffer || fieldsSelected["row_hash"] {
+            fmt.Println("-split-by-type can't be used with -format redis, -offset-index, -no-buffer, or -chain-hash (row_hash).")
+            exitCode = 1
+            return
+        }
+    }
+
+    // The script object is made from the nsize, type, and script (-json-script)
+    if *jsonScript {
+        if *format != "json" {
+            fmt.Println("-json-script can only be used with -format json.")
+            exitCode = 1
+            return
+        }
+        fieldsSelected["nsize"] = true
+        fieldsSelected["type"] = true
+        fieldsSelected["script"] = true
+    }
+
+    // The canonical order is by outpoint, so every row needs its txid and vout (they don't have to be in the output)
+    if *canonical {
+        fieldsSelected["txid"] = true
+        fieldsSelected["vout"] = true
+    }
+
+    // The vout is written as hex (-vout-hex), so it's a string in the typed formats
+    if *voutHex {
+        if *format == "amount-map-binary" || *format == "leveldb" {
+            fmt.Println("-vout-hex can't be used with -format amount-map-binary or leveldb (they write the vout as a uint32).")
+            exitCode = 1
+            return
+        }
+        delete(fieldTypes, "vout")
+    }
+
+    // The amount map is just the outpoint and amount of each utxo
+    if *format == "amount-map" || *format == "amount-map-binary" {
+        fieldsSelected["txid"] = true
+        fieldsSelected["vout"] = true
+        fieldsSelected["amount"] = true
+    }
+
+    // The leveldb entries have everything apart from the address, and get written to a folder instead of a file
+    if *format == "leveldb" {
+        for _, v := range []string{"txid", "vout", "height", "coinbase", "amount", "nsize", "script"} {
+            fieldsSelected[v] = true
+        }
+        if *splitByType {
+            fmt.Println("-format leveldb can't be used with -split-by-type.")
+            exitCode = 1
+            return
+        }
+    }
+
+    // Without decompressing the amounts (-no-amount-decode) nothing that uses them would be right, so don't allow any of it
+    if *noAmountDecode {
+        for _, v := range []string{"amount", "amount_btc", "amount_exp", "amount_mantissa", "value_usd", "sweepable", "coindays", "dust_ratio"} {
+            if fieldsSelected[v] {
+                fmt.Printf("The %s field can't be used with -no-amount-decode (use amount_compressed for the amount as it's stored).\n", v)
+                exitCode = 1
+                return
+            }
+        }
+        if *topUTXOs > 0 || *treeSummaryFlag || *muhashFlag || *tipHeight >= 0 {
+            fmt.Println("-no-amount-decode can't be used with -top-utxos, -tree-summary, -muhash, or -tip-height (they all need the amounts).")
+            exitCode = 1
+            return
+        }
+    }
+
+    // The descriptors get imported with a timestamp worked out from the height
+    if *format == "importdescriptors" {
+        fieldsSelected["descriptor"] = true
+        fieldsSelected["height"] = true
+    }
+
+    // Grouping by transaction needs the txid for every utxo
+    if *groupBy != "" && (*format != "grouped-json" || (*groupBy != "txid" && *groupBy != "address")) {
+        fmt.Println("-group-by can only be txid or address, and only with -format grouped-json.")
+        exitCode = 1
+        return
+    }
+    if *format == "grouped-json" {
+        if *sortField != "" || *topUTXOs > 0 { // the outputs for each txid (or address) have to stay next to each other
+            fmt.Println("-format grouped-json can't be used with -sort or -top-utxos.")
+            exitCode = 1
+            return
+        }
+        if *groupBy == "address" {
+            *sortField = "address" // (the address isn't in the chainstate order, so it has to be sorted)
+        } else {
+            fieldsSelected["txid"] = true
+        }
+    }
+
+    // The offsets in the index are only any use if each row is written where it comes (in txid order)
+    if *offsetIndexFile != "" {
+        if *format != "csv" && *format != "template" && *format != "json" && *format != "cbor" && *format != "ndjson-typed" {
+            fmt.Printf("-offset-index can't be used with -format %s (only csv, template, json, cbor and ndjson-typed write each row as it comes).\n", *format)
+            exitCode = 1
+            return
+        }
+        if *sortField != "" || *topUTXOs > 0 || *canonical {
+            fmt.Println("-offset-index can't be used with -sort, -canonical, or -top-utxos (the offsets are worked out as each row is written).")
+            exitCode = 1
+            return
+        }
+        if fieldsSelected["tx_output_count"] {
+            fmt.Println("-offset-index can't be used with the tx_output_count field (the rows get held back until the txid changes).")
+            exitCode = 1
+            return
+        }
+    }
+
+    // Can only sort by a field that's in the output
+    if *sortField != "" && !strings.Contains(","+*fields+",", ","+*sortField+",") { // (not just -compute)
+        fmt.Printf("-sort %s needs %s to be one of the -f fields.\n", *sortField, *sortField)
+        exitCode = 1
+        return
+    }
+
+    // Create file buffer to speed up writing to the file (the file gets attached to it once it has been opened).
+    writer := bufio.NewWriter(nil)
+
+    // Select the output format (csv, arrow, template, json, grouped-json, sql-insert, xlsx, cbor, ndjson-typed, redis, importdescriptors, amount-map, amount-map-binary, leveldb) that will write each utxo to the buffer.
+    // Appending to an existing file (-append) - check it has the same fields, and don't write the header again
+    appendSize := int64(0)
+    if *appendFlag {
+        if *format != "csv" || *splitByType {
+            fmt.Println("-append can only be used with -format csv (and not with -split-by-type).")
+            exitCode = 1
+            return
+        }
+        header, size, err := existingHeader(*file)
+        if err != nil {
+            fmt.Println(err)
+            exitCode = 1
+            return
+        }
+        if size > 0 && header != *fields {
+            fmt.Printf("Can't append to %s, the fields in its header (%s) don't match the -f fields (%s).\n", *file, header, *fields)
+            exitCode = 1
+            return
+        }
+        appendSize = size
+    }
+
+    // Writing to a temp file and renaming it (-atomic) only works for a single new file
+    if *atomicFlag && (*appendFlag || *splitByType || *format == "leveldb") {
+        fmt.Println("-atomic can't be used with -append, -split-by-type, or -format leveldb.")
+        exitCode = 1
+        return
+    }
+
+    options := outputOptions{batchSize: *batchSize, template: *tmpl, table: *table, maxRows: *xlsxMaxRows, crlf: *crlf, redisAddr: *redisAddr, redisSet: *redisSet, importTimestamp: *importTimestamp, skipHeader: appendSize > 0, jsonScript: *jsonScript, path: *file, groupBy: *groupBy}
+    rows, err := newRowWriter(*format, writer, options) // check the format is valid before we create the file
     if err != nil {
-        panic(err)
+        fmt.Println(err)
+        exitCode = 1
+        return
+    }
+
+    // Or a writer for each script type, each with its own file (-split-by-type)
+    if *splitByType {
+        rows = newSplitWriter(*file, *format, options)
+    }
+
+    // Chain the rows together with a hash (-chain-hash, or the row_hash field)
+    var chainHasher *chainHashWriter
+    if fieldsSelected["row_hash"] {
+        chainHasher = &chainHashWriter{out: rows}
+        rows = chainHasher
+    }
+
+    // Write the vout in hex (-vout-hex), after the sort but before the row_hash (so it hashes what gets written)
+    if *voutHex {
+        rows = &voutHexWriter{out: rows}
+    }
+
+    // Sort the rows before they get written (external merge sort using temp files)
+    var stop scanStop // ctrl-c, -stall-abort (see stop.go)
+    var sorter *sortWriter
+    if *sortField != "" || *canonical {
+        sorter = newSortWriter(rows, *sortField, *sortDesc, *canonical, *sortMem)
+        defer sorter.cleanup()
+
+        // remove the temp files if we get stopped with ctrl-c (by stopping the scan or the merge, and returning as normal)
+        interrupt := make(chan os.Signal, 1)
+        signal.Notify(interrupt, os.Interrupt)
+        go func() {
+            <-interrupt
+            signal.Stop(interrupt) // (a second ctrl-c exits straight away, in case it's stuck)
+            fmt.Println("Interrupted, stopping...")
+            stop.request(1)
+            sorter.cancel()
+        }()
+
+        rows = sorter
+    }
+
+    // Only keep the biggest utxos (-top-utxos), these get passed on to the sort (if there is one) at the end
+    if *topUTXOs > 0 {
+        rows = newTopWriter(rows, *topUTXOs)
+    }
+
+    // Number the outputs of each transaction (tx_output_index, tx_output_count), in the order of the scan
+    if fieldsSelected["tx_output_index"] || fieldsSelected["tx_output_count"] {
+        rows = newTxOutputsWriter(rows, fieldsSelected["tx_output_count"])
+    }
+
+    // Open file to write results to (-split-by-type opens a file for each type instead, as they turn up, and -format leveldb has already opened its database)
+    var out io.Writer = io.Discard
+    var pending *atomicFile // -atomic
+    if *splitByType {
+        fmt.Printf("Processing %s and writing results to %s\n", *chainstate, splitPath(*file, "<type>"))
+    } else if *format == "leveldb" {
+        fmt.Printf("Processing %s and writing results to the leveldb at %s\n", *chainstate, *file)
+    } else {
+        flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+        if *appendFlag {
+            flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+        }
+        path := *file
+        if *atomicFlag {
+            path = atomicTempPath(*file) // (renamed to the -o file at the end)
+        }
+        f, err := os.OpenFile(path, flags, 0666)
+        if err != nil {
+            panic(err)
+        }
+        defer f.Close()
+        out = f
+        fmt.Printf("Processing %s and writing results to %s\n", *chainstate, path)
+
+        if *atomicFlag {
+            pending = &atomicFile{path: *file, tmp: path, f: f}
+            defer func() {
+                if pending.abandoned() && exitCode == 0 { // (keep the status from whatever stopped it, e.g. 3 for -stall-abort)
+                    exitCode = 1
+                }
+            }()
+        }
     }
-    defer f.Close()
-    fmt.Printf("Processing %s and writing results to %s\n", *chainstate, *file)
 
-    // Create file buffer to speed up writing to the file.
-    writer := bufio.NewWriter(f)
+    // Write to the file through the buffer.
+    counter := &countingWriter{w: out, n: appendSize} // keeps track of how far in to the file we are (-offset-index, starting after what's already there with -append)
+    writer.Reset(counter)
     defer writer.Flush() // Flush the bufio buffer to the file before this script ends
 
-    // Stats - keep track of interesting stats as we read through leveldb.
-    totalAmount := 0 // total amount of satoshis
-    scriptTypeCount := map[string]int{"p2pk":0, "p2pkh":0, "p2sh":0, "p2ms":0, "p2wpkh":0, "p2wsh":0, "non-standard": 0} // count each script type
+    // Offset Index (-offset-index)
+    var index *offsetIndex
+    if *offsetIndexFile != "" {
+        index, err = newOffsetIndex(*offsetIndexFile, counter, writer)
+        if err != nil {
+            fmt.Println("Couldn't create offset index:", err)
+            exitCode = 1
+            return
+        }
+        defer func() {
+            if err := index.Close(); err != nil {
+                fmt.Println("Couldn't write offset index:", err)
+                logger.Error("error writing offset index", "file", *offsetIndexFile, "error", err.Error())
+            }
+        }()
+    }
 
 
+    // Stats - keep track of interesting stats as we read through leveldb.
+    totals := newScanTotals() // total amount, immature amount, script type and nsize counts (see stats.go)
+    ages := newAgeHistogram() // utxo age buckets (-tip-height)
+    var tree *treeSummary // count and value for each script type (-tree-summary)
+    if *treeSummaryFlag {
+        tree = newTreeSummary()
+    }
+    var opcodes *opcodeStats // opcodes used in the non-standard scripts (-script-opcode-stats)
+    if *scriptOpcodeStats {
+        opcodes = newOpcodeStats()
+    }
+    totalCoinBlocks := new(big.Int) // sum of amount * age in blocks (coindays field), too big for an int64
+    distinctTxids := 0 // number of different txids (-count-txids)
+    distinctAddresses := 0 // number of different addresses (-count-addresses)
+    setHash := muhash.New() // -muhash
+    setHashSkipped := 0 // utxos whose full script couldn't be rebuilt (so the hash won't match bitcoin core)
+    typesChecked := 0 // -verify-types
+    typesUnchecked := 0 // scripts that can't be rebuilt (e.g. uncompressed p2pk)
+    typeMismatches := map[string]int{} // "nsize type -> template type" = count
+    var lastTxid []byte // the chainstate is sorted by txid, so we only need to spot when the txid changes
+
+
+    // Work out what we need to decode from each utxo
+    needAmount := *topUTXOs > 0 || *treeSummaryFlag || *muhashFlag || fieldsSelected["coindays"] || fieldsSelected["amount"] || fieldsSelected["amount_exp"] || fieldsSelected["amount_mantissa"] || fieldsSelected["amount_btc"] || fieldsSelected["value_usd"] || fieldsSelected["sweepable"] || fieldsSelected["dust_ratio"] || *tipHeight >= 0
+    needAddress := fieldsSelected["address"] || fieldsSelected["descriptor"] // the descriptor uses the address
+    needValue := fieldsSelected["type"] || *countAddresses || *verifyTypes || *scriptOpcodeStats || fieldsSelected["reused"] || fieldsSelected["descriptor"] || fieldsSelected["sweepable"] || fieldsSelected["epoch"] || fieldsSelected["block_subsidy"] || fieldsSelected["height"] || fieldsSelected["coinbase"] || fieldsSelected["height_code"] || fieldsSelected["nsize"] || fieldsSelected["script"] || fieldsSelected["address"] || fieldsSelected["p2sh_subtype"] || fieldsSelected["pubkey_uncompressed"] || fieldsSelected["pubkey_parity"] || fieldsSelected["witness_future"] || fieldsSelected["address_payload"] || fieldsSelected["address_legacy"] || fieldsSelected["amount_compressed"] || fieldsSelected["core_serialization"] || fieldsSelected["hash_leading_zeros"] || needAmount || *sinceHeight >= 0 || filter.active() || len(hashPrefix) > 0
+
+    // CSV Headers (written before the first utxo, so that the file still has a header if every utxo gets filtered out)
+    csvheader := strings.Join(strings.Split(*fields, ","), ",") // count,txid,vout,
+    fmt.Println(csvheader)
+    if err := rows.Header(strings.Split(*fields, ",")); err != nil { // write to file
+        fmt.Println(err)
+        exitCode = 1
+        return
+    }
+
     // Declare obfuscateKey (a byte slice)
     var obfuscateKey []byte // obfuscateKey := make([]byte, 0)
 
+    // Get the obfuscate key directly before going through the utxos (so we don't depend on it being the first key we come across)
+    value, err := db.Get(append([]byte{0x0e, 0x00}, []byte("obfuscate_key")...), nil) // 0e006f6273637572656b6579
+    if *noObfuscation { // -no-obfuscation: read the values as they are, even if there is a key
+        fmt.Println("Not deobfuscating the values (-no-obfuscation).")
+        logger.Info("deobfuscation turned off")
+    } else if err == leveldb.ErrNotFound {
+        fmt.Println("No obfuscate key found, so reading the values as they are.") // chainstates from before bitcoin 0.12 aren't obfuscated
+        logger.Warn("no obfuscate key found")
+    } else {
+        if err == nil {
+            obfuscateKey, err = btcleveldb.ObfuscateKey(value) // check the size byte matches the key, because every utxo depends on it
+        }
+        if err != nil {
+            fmt.Println("Couldn't get obfuscate key:", err)
+            logger.Error("couldn't get obfuscate key", "error", err.Error())
+            exitCode = 1
+            return
+        }
+        logger.Info("obfuscate key found", "key", hex.EncodeToString(obfuscateKey))
+    }
+
+    // Which block the utxo set is at (-show-tip)
+    var tip chainTip
+    if *showTip {
+        tip, err = readChainTip(db, obfuscateKey)
+        if err != nil {
+            fmt.Println(err)
+            logger.Error("couldn't read chain tip", "error", err.Error())
+            exitCode = 1
+            return
+        }
+        logger.Info("chain tip", "best_block", tip.best, "head_blocks", tip.heads)
+    }
+
+    // Decode self-check (-verify-decode-sample)
+    var decodeCheck *decodeVerifier
+    if *verifyDecodeSample > 0 {
+        decodeCheck = newDecodeVerifier(*verifyDecodeSample, obfuscateKey, params)
+    }
+
     // Iterate over LevelDB keys
     iter := db.NewIterator(nil, nil)
     defer iter.Release()
     // err := iter.Error()
     // fmt.Println(err)
 
+    timer := newPhaseTimer(*timing, *timingSample)
+    decodedFirst := false // for logging the first utxo
+    // Forwards (smallest key first) or backwards (-reverse)
+    first, next := iter.First, iter.Next
+    if *reverse {
+        first, next = iter.Last, iter.Prev
+    }
+
+    // Read ahead on a goroutine of its own, so reading and decoding happen at the same time (-prefetch)
+    var entries entryIterator = iter
+    if *prefetch > 0 {
+        reader := newPrefetcher(*prefetch, first, next, iter)
+        defer reader.close() // (before the iterator gets released)
+        first, next = reader.next, reader.next
+        entries = reader
+    }
+
+    // Write Row - log the row, print it (-v), and write it to the file
+    fieldsList := strings.Split(*fields, ",")
+    writeRow := func(key []byte, output map[string]string) error {
+        csvline := csvLine(output, fieldsList) // Build output line from given fields
+
+        // Log
+        if !decodedFirst {
+            logger.Info("first utxo decoded", "key", hex.EncodeToString(key), "row", csvline)
+            decodedFirst = true
+        }
+        logger.Debug("utxo decoded", "key", hex.EncodeToString(key), "row", csvline)
+
+        // Print Results
+        // -------------
+        if *verbose { // -v flag
+            fmt.Println(csvline) // Print each line.
+            // 1157.76user 176.47system 30:44.64elapsed 72%CPU (0avgtext+0avgdata 55332maxresident)k
+            // 1110.76user 164.97system 29:17.17elapsed 72%CPU (0avgtext+0avgdata 55236maxresident)k (after using packages)
+        }
+
+        // Index the start of each txid (-offset-index)
+        if index != nil {
+            if err := index.record(key); err != nil {
+                return err
+            }
+        }
+
+        // Write to buffer (use bufio for faster writes)
+        if err := rows.Row(output); err != nil {
+            return err
+        }
+
+        // Flush the buffer after every row (-no-buffer), so the file is up to date if it crashes on the next one
+        if *noBuffer {
+            return writer.Flush()
+        }
+        return nil
+    }
+
+    // Parallel address encoding (-parallel-encode) - the rows get written by the pipeline instead of in the loop
+    var encoder *encodePipeline
+    if *parallelEncode > 0 && needAddress {
+        encoder = newEncodePipeline(*parallelEncode, params, fieldsSelected["descriptor"], writeRow)
+    }
+    encodeInline := encoder == nil
+
+    // Periodic summary (-summary-interval)
+    if *summaryFile == "" {
+        *summaryFile = *file + ".summary.json"
+    }
+    lastSummary := time.Now()
+
+    count := 0 // number of utxos written (the count field)
+    housekeeping := 0 // obfuscate key, best block, etc (see keys.go)
+    unexpectedKeys := map[byte]int{} // key prefix = number of keys (anything that isn't a utxo or housekeeping)
     i := 0
-    for iter.Next() {
+    currentSummary := func(finished bool) summary {
+        return summary{Entries: i, UTXOs: count, TotalAmount: totals.amount, ScriptTypes: totals.scriptTypes, DistinctTxids: distinctTxids, DistinctAddresses: distinctAddresses, BestBlock: tip.best, Finished: finished}
+    }
+    // Switch over to using less memory when it gets close to the limit (-max-memory)
+    var memory *memoryGuard
+    if *maxMemory > 0 {
+        memory = newMemoryGuard(*maxMemory, logger)
+        if exact, ok := addressSet.(*exactSet); ok {
+            memory.add("switching -count-addresses to a bloom filter", func() error {
+                bloom, err := exact.toBloom(*bloomFP)
+                addressSet = bloom
+                return err
+            })
+        }
+        if exact, ok := seenScripts.(*exactSet); ok {
+            memory.add("switching the reused field to a bloom filter", func() error {
+                bloom, err := exact.toBloom(*bloomFP)
+                seenScripts = bloom
+                return err
+            })
+        }
+        if duplicates != nil && duplicates.exact != nil {
+            memory.add("switching -detect-duplicates to a bloom filter", func() error {
+                return duplicates.toBloom(*bloomFP)
+            })
+        }
+        if sorter != nil {
+            memory.add("writing the -sort rows to disk", sorter.requestSpill)
+        }
+    }
 
-        key := iter.Key()
-        value := iter.Value()
+    // Stall watchdog (-stall-timeout)
+    var stall *watchdog
+    if *stallTimeout > 0 {
+        stall = newWatchdog(*stallTimeout, *stallAbort, &stop, logger)
+    }
 
-        // first byte in key indicates the type of key we've got for leveldb
-        prefix := key[0]
+    // Skip entries that panic while being decoded (-max-panics)
+    if *strict {
+        *maxPanics = 0
+    }
+    panics := newPanicGuard(*maxPanics, *strict, *debug, logger)
+    var trace *decodeTrace // how far the decoder has got through each entry, for reporting the ones that fail (-verbose-errors)
+    if *verboseErrors {
+        trace = newDecodeTrace(obfuscateKey)
+        panics.trace = trace
+    }
+
+    // One line of progress on stderr (-compact-progress)
+    var progress *compactProgress
+    if *compactProgressFlag {
+        progress = newCompactProgress()
+    }
+
+    // Decode a utxo entry and write it out (an error means the scan has to stop, and it has already been printed and logged)
+    decodeUTXO := func(key []byte, value []byte) error {
+        trace.reset() // -verbose-errors
+
+        timer.begin(i) // -timing
+
+        // ---
+        // Key
+        // ---
+
+        //      430000155b9869d56c66d9e86e3c01de38e3892a42b99949fe109ac034fff6583900
+        //      <><--------------------------------------------------------------><>
+        //      /                               |                                  \
+        //  type                          txid (little-endian)                      index (varint)
 
-        // obfuscateKey (first key)
-        if (prefix == 14) { // 14 = obfuscateKey
-            obfuscateKey = value
+        // txid (reversed from the little-endian byte order it's stored in) and vout (see bitcoin/utxo)
+        txid, vout, err := utxo.DecodeKey(key)
+        if err != nil {
+            return panics.malformed(key, value, err)
+        }
+        if fieldsSelected["txid"] || fieldsSelected["outpoint"] {
+            output["txid"] = hex.EncodeToString(txid[:]) // add to output results map
+        }
+        if fieldsSelected["vout"] || fieldsSelected["outpoint"] {
+            output["vout"] = fmt.Sprintf("%d",vout)
         }
 
-        // utxo entry
-        if (prefix == 67) { // 67 = 0x43 = C = "utxo"
+        // Check this outpoint hasn't come out already (-detect-duplicates)
+        if duplicates != nil {
+            duplicates.check(key)
+        }
+
+        // outpoint (txid:vout in one field, for joining dumps together)
+        if fieldsSelected["outpoint"] {
+            output["outpoint"] = output["txid"] + ":" + output["vout"]
+        }
 
-            // ---
-            // Key
-            // ---
+        // outpoint_bin (the 36 byte outpoint from a transaction input: txid in the order it's stored + vout as a uint32 little-endian)
+        if fieldsSelected["outpoint_bin"] {
+            output["outpoint_bin"] = hex.EncodeToString(outpointBytes(key))
+        }
 
-            //      430000155b9869d56c66d9e86e3c01de38e3892a42b99949fe109ac034fff6583900
-            //      <><--------------------------------------------------------------><>
-            //      /                               |                                  \
-            //  type                          txid (little-endian)                      index (varint)
+        timer.mark("key")
 
-            // txid
-            if fieldsSelected["txid"] {
-                txidLE := key[1:33] // little-endian byte order
+        // -----
+        // Value
+        // -----
 
-                // txid - reverse byte order
-                txid := make([]byte, 0) // create empty byte slice (dont want to mess with txid directly)
-                for i := len(txidLE)-1; i >= 0; i-- { // run backwards through the txid slice
-                    txid = append(txid, txidLE[i]) // append each byte to the new byte slice
-                }
-                output["txid"] = hex.EncodeToString(txid) // add to output results map
-            }
+        // Size of the value in the database (the same before and after deobfuscating)
+        if fieldsSelected["value_len"] {
+            output["value_len"] = fmt.Sprintf("%d", len(value))
+        }
+
+        amount := 0 // keep hold of the amount so we can add it to the stats if the utxo gets dumped
+        height := 0
+        coinbase := 0
+        nsize := 0
+        var script []byte         // locking script (and its type) for -parallel-encode to get the address from
+        var scriptType string
+
+        // Only deobfuscate and get data from the Value if something is needed from it (improves speed if you just want the txid:vout)
+        if needValue {
 
-            // vout
-            if fieldsSelected["vout"] {
-                index := key[33:]
+            // XOR the value with the obfuscateKey (xor each byte) to de-obfuscate the value
+            xor := btcleveldb.Deobfuscate(value, obfuscateKey)
+            timer.mark("deobfuscate")
+            trace.deobfuscated(xor)
 
-                // convert varint128 index to an integer
-                vout := btcleveldb.Varint128Decode(index)
-                output["vout"] = fmt.Sprintf("%d",vout)
+            // The value as bitcoin core serializes the Coin (before it's obfuscated)
+            if fieldsSelected["core_serialization"] {
+                output["core_serialization"] = coreSerialization(xor)
             }
 
             // -----
             // Value
             // -----
 
-            // Only deobfuscate and get data from the Value if something is needed from it (improves speed if you just want the txid:vout)
-            if fieldsSelected["type"] || fieldsSelected["height"] || fieldsSelected["coinbase"] || fieldsSelected["amount"] || fieldsSelected["nsize"] || fieldsSelected["script"] || fieldsSelected["type"] || fieldsSelected["address"] {
+            //   value: 71a9e87d62de25953e189f706bcf59263f15de1bf6c893bda9b045 <- obfuscated
+            //          b12dcefd8f872536b12dcefd8f872536b12dcefd8f872536b12dce <- extended obfuscateKey (XOR)
+            //          c0842680ed5900a38f35518de4487c108e3810e6794fb68b189d8b <- deobfuscated
+            //          <----><----><><-------------------------------------->
+            //           /      |    \                   |
+            //      varint   varint   varint          script <- P2PKH/P2SH hash160, P2PK public key, or complete script
+            //         |        |     nSize
+            //         |        |
+            //         |     amount (compressesed)
+            //         |
+            //         |
+            //  100000100001010100110
+            //  <------------------> \
+            //         height         coinbase
+
+            // Split the value up in to its parts (the same way utxo.Decode does)
+            parts, err := utxo.DecodeValue(xor)
+            trace.parts(parts)
+            if err != nil { // the value has been cut short (skipped, or stops the scan with -strict)
+                return panics.malformed(key, value, err)
+            }
+            timer.mark("varints")
+
+            // First Varint
+            // ------------
+            // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
+            // <---->
+
+            // Height (first bits)
+            height = parts.HeightCode >> 1 // right-shift to remove last bit
+
+            // Coinbase (last bit)
+            coinbase = parts.HeightCode & 1 // AND to extract right-most bit
+
+            if fieldsSelected["height"] || fieldsSelected["coinbase"] {
+                output["height"] = fmt.Sprintf("%d", height)
+                output["coinbase"] = fmt.Sprintf("%d", coinbase)
+            }
+
+            // Skip this utxo if it was created before the -since-height
+            // The chainstate is sorted by txid and not by height, so this still has to read (and deobfuscate) every utxo to find the new ones
+            if *sinceHeight >= 0 && height < *sinceHeight {
+                return nil
+            }
+
+            // Height Code (the first varint before it's split, height * 2 + coinbase)
+            if fieldsSelected["height_code"] {
+                output["height_code"] = fmt.Sprintf("%d", parts.HeightCode)
+            }
+
+            // Halving epoch and the block subsidy at the time (worked out from the height)
+            if fieldsSelected["epoch"] || fieldsSelected["block_subsidy"] {
+                output["epoch"] = fmt.Sprintf("%d", halvingEpoch(height))
+                output["block_subsidy"] = fmt.Sprintf("%d", blockSubsidy(height))
+            }
+
+            // Second Varint
+            // -------------
+            // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
+            //       <---->
 
-                // Copy the obfuscateKey ready to extend it
-                obfuscateKeyExtended := obfuscateKey[1:] // ignore the first byte, as that just tells you the size of the obfuscateKey
+            // Amount as it's stored in the chainstate (before it's decompressed)
+            if fieldsSelected["amount_compressed"] {
+                output["amount_compressed"] = fmt.Sprintf("%d", parts.CompressedAmount)
+            }
 
-                // Extend the obfuscateKey so it's the same length as the value
-                for i, k := len(obfuscateKeyExtended), 0; len(obfuscateKeyExtended) < len(value); i, k = i+1, k+1 {
-                    // append each byte of obfuscateKey to the end until it's the same length as the value
-                    obfuscateKeyExtended = append(obfuscateKeyExtended, obfuscateKeyExtended[k])
-                    // Example
-                    //   [8 175 184 95 99 240 37 253 115 181 161 4 33 81 167 111 145 131 0 233 37 232 118 180 123 120 78]
-                    //   [8 177 45 206 253 143 135 37 54]                                                                  <- obfuscate key
-                    //   [8 177 45 206 253 143 135 37 54 8 177 45 206 253 143 135 37 54 8 177 45 206 253 143 135 37 54]    <- extended
+            // Amount
+            if needAmount {
+                amount = btcleveldb.DecompressValue(parts.CompressedAmount)
+                output["amount"] = fmt.Sprintf("%d", amount)
+                output["amount_btc"] = formatBTC(amount)
+                if fieldsSelected["amount_exp"] || fieldsSelected["amount_mantissa"] { // how the amount was compressed (amount = mantissa * 10^exp)
+                    mantissa, exponent := btcleveldb.DecompressValueParts(parts.CompressedAmount)
+                    output["amount_mantissa"] = fmt.Sprintf("%d", mantissa)
+                    output["amount_exp"] = fmt.Sprintf("%d", exponent)
                 }
+                if fieldsSelected["value_usd"] && *price != "" { // (empty without a -price)
+                    output["value_usd"] = formatUSD(amount, priceCents)
+                }
+            }
 
-                // XOR the value with the obfuscateKey (xor each byte) to de-obfuscate the value
-                var xor []byte // create a byte slice to hold the xor results
-                for i := range value {
-                    result := value[i] ^ obfuscateKeyExtended[i]
-                    xor = append(xor, result)
+            // Third Varint
+            // ------------
+            // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
+            //             <>
+            //
+            // nSize - byte to indicate the type or size of script - helps with compression of the script data
+            //  - https://github.com/bitcoin/bitcoin/blob/master/src/compressor.cpp
+
+            //  0  = P2PKH <- hash160 public key
+            //  1  = P2SH  <- hash160 script
+            //  2  = P2PK 02publickey <- nsize makes up part of the public key in the actual script
+            //  3  = P2PK 03publickey
+            //  4  = P2PK 04publickey (uncompressed - but has been compressed in to leveldb) y=even
+            //  5  = P2PK 04publickey (uncompressed - but has been compressed in to leveldb) y=odd
+            //  6+ = [size of the upcoming script] (subtract 6 though to get the actual size in bytes, to account for the previous 5 script types already taken)
+            nsize = parts.NSize
+            output["nsize"] = fmt.Sprintf("%d", nsize)
+
+            // Script (remaining bytes, which include the nsize for 2, 3, 4, and 5, as it forms part of the P2PK public key)
+            // ------
+            // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
+            //               <-------------------------------------->
+            script = parts.Script
+            if fieldsSelected["script"] {
+                output["script"] = scriptField(nsize, script) // (uncompressed public keys get decompressed)
+            }
+
+            // Skip this utxo if it's not locked to an address we want (-address, -exclude-address)
+            if filter.active() && !filter.match(nsize, script) {
+                return nil
+            }
+
+            // Skip this utxo if the hash160/witness program doesn't start with the -address-prefix
+            if len(hashPrefix) > 0 && !bytes.HasPrefix(addressHash(nsize, script), hashPrefix) {
+                return nil
+            }
+
+            // Leading zero bits of the hash160/witness program (vanity or generated addresses)
+            if fieldsSelected["hash_leading_zeros"] {
+                output["hash_leading_zeros"] = hashLeadingZeros(nsize, script)
+            }
+
+            // Add to the hash of the utxo set (-muhash)
+            if *muhashFlag {
+                if full, ok := btcleveldb.DecompressScript(nsize, script); ok {
+                    setHash.Insert(serializeCoin(key[1:33], vout, height, coinbase, amount, full))
+                } else {
+                    setHashSkipped++
+                    logger.Warn("couldn't rebuild script for muhash", "key", hex.EncodeToString(key), "nsize", nsize)
                 }
+            }
+
+            // Addresses - Get address from script (if possible), and set script type (P2PK, P2PKH, P2SH, P2MS, P2WPKH, or P2WSH)
+            // ---------
+            if needAddress || fieldsSelected["type"] || fieldsSelected["p2sh_subtype"] || fieldsSelected["pubkey_uncompressed"] || fieldsSelected["pubkey_parity"] || fieldsSelected["witness_future"] || fieldsSelected["address_payload"] || fieldsSelected["address_legacy"] || fieldsSelected["sweepable"] || fieldsSelected["dust_ratio"] || fieldsSelected["reused"] || *countAddresses || *verifyTypes || *nonStandardOnly || *scriptOpcodeStats || *treeSummaryFlag {
 
-                // -----
-                // Value
-                // -----
-
-                //   value: 71a9e87d62de25953e189f706bcf59263f15de1bf6c893bda9b045 <- obfuscated
-                //          b12dcefd8f872536b12dcefd8f872536b12dcefd8f872536b12dce <- extended obfuscateKey (XOR)
-                //          c0842680ed5900a38f35518de4487c108e3810e6794fb68b189d8b <- deobfuscated
-                //          <----><----><><-------------------------------------->
-                //           /      |    \                   |
-                //      varint   varint   varint          script <- P2PKH/P2SH hash160, P2PK public key, or complete script
-                //         |        |     nSize
-                //         |        |
-                //         |     amount (compressesed)
-                //         |
-                //         |
-                //  100000100001010100110
-                //  <------------------> \
-                //         height         coinbase
-
-                offset := 0
-
-                // First Varint
-                // ------------
-                // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
-                // <---->
-                varint, bytesRead := btcleveldb.Varint128Read(xor, 0) // start reading at 0
-                offset += bytesRead
-                varintDecoded := btcleveldb.Varint128Decode(varint)
-
-                if fieldsSelected["height"] || fieldsSelected["coinbase"] {
-
-                    // Height (first bits)
-                    height := varintDecoded >> 1 // right-shift to remove last bit
-                    output["height"] = fmt.Sprintf("%d", height)
-
-                    // Coinbase (last bit)
-                    coinbase := varintDecoded & 1 // AND to extract right-most bit
-                    output["coinbase"] = fmt.Sprintf("%d", coinbase)
+                var address string // initialize address variable
+                scriptType = "non-standard" // initialize script type
+                var p2shSubtype string // only set for P2SH
+
+                // P2PKH
+                if nsize == 0 {
+                    scriptType = "p2pkh"
                 }
 
-                // Second Varint
-                // -------------
-                // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
-                //       <---->
-                varint, bytesRead = btcleveldb.Varint128Read(xor, offset) // start after last varint
-                offset += bytesRead
-                varintDecoded = btcleveldb.Varint128Decode(varint)
-
-                // Amount
-                if fieldsSelected["amount"] {
-                    amount := btcleveldb.DecompressValue(varintDecoded)
-                    output["amount"] = fmt.Sprintf("%d", amount)
-                    totalAmount += amount // add to stats
+                // P2SH
+                if nsize == 1 {
+                    scriptType = "p2sh"
+
+                    // We can't see what's inside the P2SH from the chainstate, unless we've been given the redeem script
+                    p2shSubtype = "unknown"
+                    if subtype, ok := redeemScripts[string(script)]; ok {
+                        p2shSubtype = subtype
+                    }
                 }
 
-                // Third Varint
-                // ------------
-                // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
-                //             <>
-                //
-                // nSize - byte to indicate the type or size of script - helps with compression of the script data
-                //  - https://github.com/bitcoin/bitcoin/blob/master/src/compressor.cpp
-
-                //  0  = P2PKH <- hash160 public key
-                //  1  = P2SH  <- hash160 script
-                //  2  = P2PK 02publickey <- nsize makes up part of the public key in the actual script
-                //  3  = P2PK 03publickey
-                //  4  = P2PK 04publickey (uncompressed - but has been compressed in to leveldb) y=even
-                //  5  = P2PK 04publickey (uncompressed - but has been compressed in to leveldb) y=odd
-                //  6+ = [size of the upcoming script] (subtract 6 though to get the actual size in bytes, to account for the previous 5 script types already taken)
-                varint, bytesRead = btcleveldb.Varint128Read(xor, offset) // start after last varint
-                offset += bytesRead
-                nsize := btcleveldb.Varint128Decode(varint) //
-                output["nsize"] = fmt.Sprintf("%d", nsize)
-
-                // Script (remaining bytes)
-                // ------
-                // b98276a2ec7700cbc2986ff9aed6825920aece14aa6f5382ca5580
-                //               <-------------------------------------->
-                if nsize > 1 && nsize < 6 { // either 2, 3, 4, 5
-                    // move offset back a byte if script type is 2, 3, 4, or 5 (because this forms part of the P2PK public key along with the actual script)
-                    offset--
+                // P2PK
+                if 1 < nsize && nsize < 6 { // 2, 3, 4, 5
+                    //  2 = P2PK 02publickey <- nsize makes up part of the public key in the actual script (e.g. 02publickey)
+                    //  3 = P2PK 03publickey <- y is odd/even (0x02 = even, 0x03 = odd)
+                    //  4 = P2PK 04publickey (uncompressed)  y = even <- actual script uses an uncompressed public key, but it is compressed when stored in this db
+                    //  5 = P2PK 04publickey (uncompressed)  y = odd
+
+                    // "The uncompressed pubkeys are compressed when they are added to the db. 0x04 and 0x05 are used to indicate that the key is supposed to be uncompressed and those indicate whether the y value is even or odd so that the full uncompressed key can be retrieved."
+                    //
+                    // The script field gets the full uncompressed key back for nsize 4 and 5 (scriptField), but the script
+                    // here is left the way it's stored, as everything else works from the x (and the nsize)
+
+                    scriptType = "p2pk"
                 }
 
-                script := xor[offset:]
-                if fieldsSelected["script"] {
-                    output["script"] = hex.EncodeToString(script)
+                // P2PK (full script)
+                // Bitcoin Core only compresses valid public keys, so some P2PK scripts are stored in full and need matching against the template instead
+                if nsize > 5 && btcscript.IsP2PK(script) { // <33 or 65 byte public key> OP_CHECKSIG
+                    scriptType = "p2pk"
                 }
 
-                // Addresses - Get address from script (if possible), and set script type (P2PK, P2PKH, P2SH, P2MS, P2WPKH, or P2WSH)
-                // ---------
-                if fieldsSelected["address"] || fieldsSelected["type"] {
+                // P2MS
+                if nsize > 5 && len(script) > 0 && script[len(script)-1] == 174 { // if there is a full script and if the last opcode is OP_CHECKMULTISIG (174) (0xae)
+                    scriptType = "p2ms"
+                }
 
-                    var address string // initialize address variable
-                    var scriptType string = "non-standard" // initialize script type
+                // P2WPKH
+                if nsize == 28 && script[0] == 0 && script[1] == 20 { // P2WPKH (script type is 28, which means length of script is 22 bytes)
+                    // 315,c016e8dcc608c638196ca97572e04c6c52ccb03a35824185572fe50215b80000,0,551005,3118,0,28,001427dab16cca30628d395ccd2ae417dc1fe8dfa03e
+                    // script  = 0014700d1635c4399d35061c1dabcc4632c30fedadd6
+                    scriptType = "p2wpkh"
+                }
 
-                    // P2PKH
-                    if nsize == 0 {
-                        if fieldsSelected["address"] { // only work out addresses if they're wanted
-                            if testnet == true {
-                                address = keys.Hash160ToAddress(script, []byte{0x6f}) // (m/n)address - testnet addresses have a special prefix
-                            } else {
-                                address = keys.Hash160ToAddress(script, []byte{0x00}) // 1address
-                            }
-                        }
-                        scriptType = "p2pkh"
-                        scriptTypeCount["p2pkh"] += 1
+                // P2WSH
+                if nsize == 40 && script[0] == 0 && script[1] == 32 { // P2WSH (script type is 40, which means length of script is 34 bytes)
+                    // 956,1df27448422019c12c38d21c81df5c98c32c19cf7a312e612f78bebf4df20000,1,561890,800000,0,40,00200e7a15ba23949d9c274a1d9f6c9597fa9754fc5b5d7d45fc4369eeb4935c9bfe
+                    scriptType = "p2wsh"
+                }
+
+                // P2TR
+                if nsize == 40 && btcscript.IsP2TR(script) { // P2TR (also 34 bytes, but OP_1 for witness version 1 instead of OP_0)
+                    // script = 5120a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c -> bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr
+                    scriptType = "p2tr"
+                }
+
+                // Future witness versions (OP_2 to OP_16 <2-40 bytes>) - not used yet, but they could be after a soft fork
+                witnessFuture := false
+                if nsize > 5 {
+                    if version := btcscript.WitnessVersion(script); version >= 2 {
+                        scriptType = fmt.Sprintf("witness_v%d", version)
+                        witnessFuture = true
+                    }
+                }
+                if fieldsSelected["witness_future"] {
+                    output["witness_future"] = "0"
+                    if witnessFuture {
+                        output["witness_future"] = "1"
+                    }
+                }
+
+                // Skip this utxo if its script matched one of the standard types (-non-standard-only)
+                if *nonStandardOnly && scriptType != "non-standard" {
+                    return nil
+                }
+
+                // Address and descriptor (unless -parallel-encode is working them out)
+                if encodeInline {
+                    if needAddress { // only work out addresses if they're wanted
+                        address = encodeAddress(scriptType, script, params)
+                    }
+                    if fieldsSelected["descriptor"] {
+                        output["descriptor"] = encodeDescriptor(scriptType, nsize, script, address)
                     }
+                }
 
-                    // P2SH
-                    if nsize == 1 {
-                        if fieldsSelected["address"] { // only work out addresses if they're wanted
-                            if testnet == true {
-                                address = keys.Hash160ToAddress(script, []byte{0xc4}) // 2address - testnet addresses have a special prefix
-                            } else {
-                                address = keys.Hash160ToAddress(script, []byte{0x05}) // 3address
+                // Verify Type - rebuild the full script and check the templates agree with the type we got from the nsize (-verify-types)
+                if *verifyTypes {
+                    if full, ok := btcleveldb.DecompressScript(nsize, script); ok {
+                        typesChecked++
+                        if templateType := btcscript.Type(full); templateType != scriptType {
+                            if len(typeMismatches) == 0 {
+                                fmt.Println("Type mismatch (possible decoding bug):", hex.EncodeToString(key), scriptType, "from nsize but", templateType, "from script", hex.EncodeToString(full))
                             }
+                            typeMismatches[scriptType + " -> " + templateType] += 1
+                            logger.Warn("type mismatch", "key", hex.EncodeToString(key), "nsize", nsize, "type", scriptType, "template_type", templateType, "script", hex.EncodeToString(full))
                         }
-                        scriptType = "p2sh"
-                        scriptTypeCount["p2sh"] += 1
+                    } else {
+                        typesUnchecked++
                     }
+                }
 
-                    // P2PK
-                    if 1 < nsize && nsize < 6 { // 2, 3, 4, 5
-                        //  2 = P2PK 02publickey <- nsize makes up part of the public key in the actual script (e.g. 02publickey)
-                        //  3 = P2PK 03publickey <- y is odd/even (0x02 = even, 0x03 = odd)
-                        //  4 = P2PK 04publickey (uncompressed)  y = odd?  <- actual script uses an uncompressed public key, but it is compressed when stored in this db
-                        //  5 = P2PK 04publickey (uncompressed)? y = even?
-
-                        // "The uncompressed pubkeys are compressed when they are added to the db. 0x04 and 0x05 are used to indicate that the key is supposed to be uncompressed and those indicate whether the y value is even or odd so that the full uncompressed key can be retrieved."
-                        //
-                        // if nsize is 4 or 5, you will need to uncompress the public key to get it's full form
-                        // if nsize == 4 || nsize == 5 {
-                        //     // uncompress (4 = y is even, 5 = y is odd)
-                        //     script = decompress(script)
-                        // }
-
-                        scriptType = "p2pk"
-                        scriptTypeCount["p2pk"] += 1
-                    }
+                // Opcodes in the non-standard scripts (these are always stored in full, so there's nothing to rebuild)
+                if opcodes != nil && scriptType == "non-standard" {
+                    opcodes.add(script)
+                }
+
+                // Count each utxo once under the type it ended up with (non-standard if the script type hasn't been identified and set)
+                totals.addType(scriptType)
 
-                    // P2MS
-                    if len(script) > 0 && script[len(script)-1] == 174 { // if there is a script and if the last opcode is OP_CHECKMULTISIG (174) (0xae)
-                        scriptType = "p2ms"
-                        scriptTypeCount["p2ms"] += 1
+                // Reused - has this address (or public key, or script) already been seen earlier in the chainstate?
+                if fieldsSelected["reused"] {
+                    output["reused"] = "0"
+                    if scriptType != "non-standard" && !seenScripts.add([]byte(addressFilterKey(nsize, script))) {
+                        output["reused"] = "1"
                     }
+                }
 
-                    // P2WPKH
-                    if nsize == 28 && script[0] == 0 && script[1] == 20 { // P2WPKH (script type is 28, which means length of script is 22 bytes)
-                        // 315,c016e8dcc608c638196ca97572e04c6c52ccb03a35824185572fe50215b80000,0,551005,3118,0,28,001427dab16cca30628d395ccd2ae417dc1fe8dfa03e
-                        // script  = 0014700d1635c4399d35061c1dabcc4632c30fedadd6
-                        // script  = [0 20 112 13 22 53 196 57 157 53 6 28 29 171 204 70 50 195 15 237 173 214]
-                        // version = [0]
-                        // program =      [112 13 22 53 196 57 157 53 6 28 29 171 204 70 50 195 15 237 173 214]
-                        version := script[0]
-                        program := script[2:]
-
-                        // bech32 function takes an int array and not a byte array, so convert the array to integers
-                        var programint []int // initialize empty integer array to hold the new one
-                        for _, v := range program {
-                            programint = append(programint, int(v)) // cast every value to an int
-                        }
+                // Distinct addresses - compare the raw hash/program instead of the address, so we don't have to encode them all
+                if *countAddresses && hasAddress(scriptType) && addressSet.add([]byte(addressFilterKey(nsize, script))) {
+                    distinctAddresses++
+                }
 
-                        if fieldsSelected["address"] { // only work out addresses if they're wanted
-                            if testnet == true {
-                                address, _ = bech32.SegwitAddrEncode("tb", int(version), programint) // hrp (string), version (int), program ([]int)
-                            } else {
-                                address, _ = bech32.SegwitAddrEncode("bc", int(version), programint) // hrp (string), version (int), program ([]int)
-                            }
-                        }
+                // add address and script type to results map
+                output["address"] = address
+                output["type"] = scriptType
+                output["p2sh_subtype"] = p2shSubtype
 
-                        scriptType = "p2wpkh"
-                        scriptTypeCount["p2wpkh"] += 1
-                    }
+                // Full public key for P2PK (decompressed if it's compressed)
+                if fieldsSelected["pubkey_uncompressed"] {
+                    output["pubkey_uncompressed"] = hex.EncodeToString(uncompressedPublicKey(scriptType, nsize, script))
+                }
 
-                    // P2WSH
-                    if nsize == 40 && script[0] == 0 && script[1] == 32 { // P2WSH (script type is 40, which means length of script is 34 bytes)
-                        // 956,1df27448422019c12c38d21c81df5c98c32c19cf7a312e612f78bebf4df20000,1,561890,800000,0,40,00200e7a15ba23949d9c274a1d9f6c9597fa9754fc5b5d7d45fc4369eeb4935c9bfe
-                        version := script[0]
-                        program := script[2:]
+                // Even or odd y coordinate of the P2PK public key (from the prefix or the nsize)
+                if fieldsSelected["pubkey_parity"] {
+                    output["pubkey_parity"] = pubkeyParity(scriptType, nsize, script)
+                }
 
-                        var programint []int
-                        for _, v := range program {
-                            programint = append(programint, int(v)) // cast every value to an int
-                        }
+                // Version byte + hash160 (base58) or witness version + program (segwit) behind the address
+                if fieldsSelected["address_payload"] {
+                    output["address_payload"] = hex.EncodeToString(addressPayload(scriptType, script, params))
+                }
 
-                        if fieldsSelected["address"] { // only work out addresses if they're wanted
-                            if testnet == true {
-                                address, _ = bech32.SegwitAddrEncode("tb", int(version), programint) // testnet bech32 addresses start with tb
-                            } else {
-                                address, _ = bech32.SegwitAddrEncode("bc", int(version), programint) // mainnet bech32 addresses start with bc
-                            }
-                        }
+                // The legacy (P2PKH) address with the same hash160 as a P2WPKH output (derived, not the real address)
+                if fieldsSelected["address_legacy"] {
+                    output["address_legacy"] = legacyAddress(scriptType, script, params)
+                }
 
-                        scriptType = "p2wsh"
-                        scriptTypeCount["p2wsh"] += 1
-                    }
+                // How far above (or below) the dust threshold the amount is (under 1 = dust)
+                if fieldsSelected["dust_ratio"] {
+                    output["dust_ratio"] = dustRatio(amount, nsize, script)
+                }
 
-                    // Non-Standard (if the script type hasn't been identified and set then it remains as an unknown "non-standard" script)
-                    if scriptType == "non-standard" {
-                        scriptTypeCount["non-standard"] += 1
+                // Is it worth more than it costs to spend? (-feerate)
+                if fieldsSelected["sweepable"] {
+                    if sweepable(amount, scriptType, *feerate) {
+                        output["sweepable"] = "1"
+                    } else {
+                        output["sweepable"] = "0"
                     }
+                }
 
-                    // add address and script type to results map
-                    output["address"] = address
-                    output["type"] = scriptType
+                timer.mark("addresses")
+            }
 
-                }
+        } // if field from the Value is needed (e.g. -f txid,vout,address)
 
-            } // if field from the Value is needed (e.g. -f txid,vout,address)
 
+        // -------
+        // Results
+        // -------
 
-            // -------
-            // Results
-            // -------
+        // add to stats (coinbase outputs can't be spent until they have 100 confirmations, so they count as immature until then with -tip-height)
+        totals.add(amount, nsize, needValue, coinbase, height, *tipHeight)
+        if tree != nil {
+            tree.add(scriptType, amount)
+        }
 
-            // CSV Headers
-            if i == 2 { // only print header once at the start
-                csvheader := ""
-                for _, v := range strings.Split(*fields, ",") {
-                    csvheader += v
-                    csvheader += ","
-                } // count,txid,vout,
-                csvheader = csvheader[:len(csvheader)-1] // remove trailing ,
-                fmt.Println(csvheader)
-                fmt.Fprintln(writer, csvheader) // write to file
+        // Age buckets (-tip-height)
+        if *tipHeight >= 0 {
+            ages.add(*tipHeight - height, amount)
+        }
+
+        // Coin age - amount * blocks since it was created (-tip-height)
+        // A big utxo that's been sitting there for a long time can be too big for an int64 (e.g. 10000 btc * 800000 blocks)
+        if fieldsSelected["coindays"] && *tipHeight >= 0 {
+            coinBlocks := new(big.Int).Mul(big.NewInt(int64(amount)), big.NewInt(int64(*tipHeight - height)))
+            totalCoinBlocks.Add(totalCoinBlocks, coinBlocks)
+            output["coindays"] = coinBlocks.String()
+        }
+
+        // Distinct txids - all the outputs for a transaction are next to each other in the database, so count each time the txid changes
+        if *countTxids && !bytes.Equal(key[1:33], lastTxid) {
+            distinctTxids++
+            lastTxid = append(lastTxid[:0], key[1:33]...) // copy (the iterator reuses the key's memory)
+        }
+
+        // CSV Lines
+        count++ // (only the utxos that get written, not the ones filtered out or skipped for being malformed)
+        output["count"] = fmt.Sprintf("%d",count) // convert integer to string (e.g 1 to "1")
+
+        // Show progress at intervals
+        if i % 100000 == 0 {
+            logger.Info("checkpoint", "utxos", i)
+            if !*verbose && progress == nil { // (-v prints every line instead, and -compact-progress has its own line)
+                fmt.Printf("%d utxos processed\n", i)
             }
+            // 812.18user 16.94system 12:44.04elapsed 108%CPU (0avgtext+0avgdata 55272maxresident)k
+            // 951.03user 27.91system 15:21.35elapsed 106%CPU (0avgtext+0avgdata 55896maxresident)k (after using packages)
+        }
 
-            // CSV Lines
-            output["count"] = fmt.Sprintf("%d",i-1) // convert integer to string (e.g 1 to "1")
-            csvline := "" // Build output line from given fields
-            // [ ] string builder faster?
-            for _, v := range strings.Split(*fields, ",") {
-                csvline += output[v]
-                csvline += ","
+        // Decode the utxo again for a sample of rows and check we get the same thing (-verify-decode-sample)
+        if decodeCheck.sample() {
+            if err := decodeCheck.check(key, value, output, encodeInline && needAddress); err != nil {
+                fmt.Println(err)
+                logger.Error("decode self-check failed", "error", err.Error())
+                return err
             }
-            csvline = csvline[:len(csvline)-1] // remove trailing ,
+        }
 
-            // Print Results
-            // -------------
-            if *verbose { // -v flag
-                fmt.Println(csvline) // Print each line.
-                // 1157.76user 176.47system 30:44.64elapsed 72%CPU (0avgtext+0avgdata 55332maxresident)k
-                // 1110.76user 164.97system 29:17.17elapsed 72%CPU (0avgtext+0avgdata 55236maxresident)k (after using packages)
-            } else {
-                if (i % 100000 == 0) {
-                    fmt.Printf("%d utxos processed\n", i) // Show progress at intervals.
-                }
-                // 812.18user 16.94system 12:44.04elapsed 108%CPU (0avgtext+0avgdata 55272maxresident)k
-                // 951.03user 27.91system 15:21.35elapsed 106%CPU (0avgtext+0avgdata 55896maxresident)k (after using packages)
+        // Write to File
+        // -------------
+        if encoder != nil { // the address still needs working out (-parallel-encode), so the error is from an earlier row
+            err = encoder.send(key, output, scriptType, nsize, script)
+        } else {
+            err = writeRow(key, output)
+        }
+        if err != nil {
+            fmt.Println(err)
+            logger.Error("error writing row", "error", err.Error())
+            return err
+        }
+        timer.mark("writing")
+        return nil
+    }
+
+    for ok := first(); ok; ok, i = next(), i+1 { // Increment Count
+
+        // Something has asked us to stop (e.g. ctrl-c), so leave the loop and clean up on the way out
+        if stop.requested() != 0 {
+            break
+        }
+
+        // Let the watchdog know we're still going (every 100 entries is often enough, and saves calling time.Now() for every one)
+        if stall != nil && i % 100 == 0 {
+            stall.touch()
+        }
+
+        // Update the progress line (-compact-progress)
+        if progress != nil && i % 10000 == 0 {
+            progress.update(i)
+        }
+
+        // Check the memory isn't getting close to the limit (-max-memory)
+        if memory != nil && i % 10000 == 0 {
+            if err := memory.check(); err != nil {
+                fmt.Println(err)
+                logger.Error("memory fallback failed", "error", err.Error())
+                exitCode = 1
+                return
             }
+        }
 
-            // Write to File
-            // -------------
-            // Write to buffer (use bufio for faster writes)
-            fmt.Fprintln(writer, csvline)
+        key := entries.Key()
+        value := entries.Value()
+
+        // first byte in key indicates the type of key we've got for leveldb
+        prefix := key[0]
 
+        // utxo entry
+        if (prefix == 67) { // 67 = 0x43 = C = "utxo"
+            // (a panic from a malformed entry gets caught, logged, and the entry skipped, see panics.go)
+            if err := panics.run(decodeUTXO, key, value); err != nil {
+                exitCode = 1
+                return
+            }
+        } else if _, ok := housekeepingKeys[prefix]; ok { // obfuscate key, best block, ...
+            housekeeping++
+        } else { // something we don't know about
+            unexpectedKeys[prefix]++
+            if unexpectedKeys[prefix] == 1 { // log the first one of each
+                logger.Warn("unexpected key prefix", "prefix", fmt.Sprintf("%02x", prefix), "key", hex.EncodeToString(key))
+            }
+            if *debug {
+                fmt.Printf("Unexpected key prefix %02x: %x\n", prefix, key)
+            }
         }
 
-        // Increment Count
-        i++
+        // Summary File - only look at the clock every 1000 entries so it doesn't slow the loop down
+        if *summaryInterval > 0 && i % 1000 == 0 && time.Since(lastSummary) >= *summaryInterval {
+            if err := writeSummary(*summaryFile, currentSummary(false)); err != nil {
+                fmt.Println("Couldn't write summary file:", err)
+                logger.Error("error writing summary file", "file", *summaryFile, "error", err.Error())
+            }
+            lastSummary = time.Now()
+        }
+
+    }
 
+    if stall != nil {
+        stall.stop()
+    }
+    if progress != nil {
+        progress.finish(i)
+    }
+
+    // Check the iterator didn't stop early because of an error
+    readErr := entries.Error()
+    if readErr != nil {
+        fmt.Println("Error reading LevelDB:", readErr)
+        if isTooManyFilesError(readErr) {
+            fmt.Println(tooManyFilesHelp)
+        }
+        logger.Error("error reading db", "error", readErr.Error())
+        exitCode = 1 // (the stats still get shown, but the output is missing whatever came after the error)
+    }
+
+    // Wait for the rows still being encoded
+    if encoder != nil {
+        if err := encoder.close(); err != nil {
+            fmt.Println(err)
+            logger.Error("error writing row", "error", err.Error())
+            exitCode = 1
+            return
+        }
+    }
+
+    // Stopped early, so the output isn't complete (the deferred clean up removes the -sort temp files, flushes what has
+    // been written, and leaves the -atomic .tmp file)
+    if code := stop.requested(); code != 0 {
+        fmt.Printf("Stopped after %d entries, so the output is incomplete.\n", i)
+        logger.Error("stopped early", "entries", i, "exit_code", code)
+        exitCode = code
+        return
+    }
+
+    // Write anything the output format still has buffered (e.g. the last arrow record batch)
+    if err := rows.Close(); err != nil {
+        fmt.Println(err)
+        logger.Error("error writing rows", "error", err.Error())
+        exitCode = 1
+        return
+    }
+
+    // Move the finished output in to place (-atomic), but not if the iterator stopped early (it's left as the .tmp file)
+    if pending != nil && readErr == nil {
+        if err := pending.commit(writer); err != nil {
+            fmt.Println(err)
+            logger.Error("error writing output", "file", *file, "error", err.Error())
+            exitCode = 1
+            return
+        }
+        logger.Info("output renamed", "from", pending.tmp, "to", *file)
     }
 
     // Final Progress Report
     // ---------------------
     // fmt.Printf("%d utxos saved to: %s\n", i, *file)
+    human := humanFormat(*humanFlag) // thousands separators (-human)
     fmt.Println()
-    fmt.Printf("Total UTXOs: %d\n", i)
+    fmt.Printf("Total UTXOs: %s\n", human.count(count)) // (not i, that's every key in the database)
+    for prefix, n := range unexpectedKeys {
+        fmt.Printf("Unexpected Keys: %s starting with %02x (skipped, use -debug to see them)\n", human.count(n), prefix)
+    }
+    if panics.count > 0 {
+        fmt.Printf("Panicked: %s entries (skipped, use -log to see the keys)\n", human.count(panics.count))
+    }
+    if panics.malformedCount > 0 {
+        fmt.Printf("Malformed: %s entries (skipped, use -log to see the keys)\n", human.count(panics.malformedCount))
+    }
+    if *showTip {
+        if tip.best != "" {
+            fmt.Printf("Best Block:  %s\n", tip.best)
+        } else {
+            fmt.Println("Best Block:  not found (the chainstate doesn't say which block it's at)")
+        }
+        if len(tip.heads) > 0 { // (the utxo set is somewhere in between the two)
+            fmt.Printf("Head Blocks: %s (bitcoind stopped in the middle of a flush, so the utxos are a mix of these blocks)\n", strings.Join(tip.heads, ", "))
+        }
+    }
+    if duplicates != nil {
+        duplicates.print(human)
+    }
+    if *countTxids {
+        fmt.Printf("Distinct TXIDs: %s\n", human.count(distinctTxids))
+    }
+    if *countAddresses {
+        fmt.Printf("Distinct Addresses: %s (%s)\n", human.count(distinctAddresses), addressSet.describe())
+    }
+
+    // Can only show total btc amount if we have requested to get the amount for each entry with the -f fields flag (or -tip-height)
+    if needAmount {
+        fmt.Printf("Total BTC:   %s\n", human.number(formatBTC(totals.amount))) // convert satoshis to BTC (8 decimal places)
+        if *price != "" {
+            fmt.Printf("Total USD:   %s (at %s USD/BTC)\n", human.number(formatUSD(totals.amount, priceCents)), *price)
+        }
+    }
 
-    // Can only show total btc amount if we have requested to get the amount for each entry with the -f fields flag
-    if fieldsSelected["amount"] {
-        fmt.Printf("Total BTC:   %.8f\n", float64(totalAmount) / float64(100000000)) // convert satoshis to BTC (float with 8 decimal places)
+    // Spendable BTC leaves out the coinbase outputs that haven't matured yet (only know this if we've been given the -tip-height)
+    if *tipHeight >= 0 {
+        fmt.Printf("Spendable BTC: %s (%s in immature coinbase outputs)\n", human.number(formatBTC(totals.amount - totals.immature)), human.number(formatBTC(totals.immature)))
+        if fieldsSelected["coindays"] {
+            fmt.Printf("Coin Blocks: %s (satoshis * blocks)\n", human.number(totalCoinBlocks.String()))
+        }
+        ages.print(totals.amount, human)
     }
 
     // Can only show script type stats if we have requested to get the script type for each entry with the -f fields flag
     if fieldsSelected["type"] {
         fmt.Println("Script Types:")
-        for k, v := range scriptTypeCount {
-            fmt.Printf(" %-12s %d\n", k, v) // %-12s = left-justify padding
+        for k, v := range totals.scriptTypes {
+            fmt.Printf(" %-12s %s\n", k, human.count(v)) // %-12s = left-justify padding
+        }
+    }
+
+    // How the scripts were compressed in the chainstate (the nsize is decoded along with the script type)
+    if fieldsSelected["type"] || fieldsSelected["nsize"] {
+        totals.nsizes.print(human)
+    }
+
+    // What the utxo set is made of (-tree-summary)
+    if tree != nil {
+        tree.print(human)
+    }
+
+    // What's in the non-standard scripts (-script-opcode-stats)
+    if opcodes != nil {
+        opcodes.print(human)
+    }
+
+    // Chain hash (-chain-hash) - the row_hash of the last row
+    if chainHasher != nil {
+        fmt.Printf("Chain Hash:  %s\n", chainHasher.final())
+        logger.Info("chain hash", "hash", chainHasher.final())
+    }
+
+    // UTXO set hash (-muhash) - shown reversed, like bitcoin core shows it
+    if *muhashFlag {
+        hash := setHash.Finalize()
+        for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+            hash[i], hash[j] = hash[j], hash[i]
+        }
+        fmt.Printf("MuHash:      %x\n", hash)
+        if setHashSkipped > 0 {
+            fmt.Printf("(%d utxos couldn't be added to the hash, so it won't match bitcoin core)\n", setHashSkipped)
         }
+        logger.Info("muhash", "hash", hex.EncodeToString(hash[:]), "skipped", setHashSkipped)
     }
 
+    // Type check (-verify-types)
+    if *verifyTypes {
+        mismatches := 0
+        for _, v := range typeMismatches {
+            mismatches += v
+        }
+        fmt.Printf("Type Check: %s checked, %s mismatches (%s couldn't be checked)\n", human.count(typesChecked), human.count(mismatches), human.count(typesUnchecked))
+        for k, v := range typeMismatches {
+            fmt.Printf(" %-28s %s\n", k, human.count(v))
+        }
+    }
+
+    // Decode self-check (-verify-decode-sample) - we'd have stopped already if any didn't match
+    if decodeCheck != nil {
+        fmt.Printf("Decode Check: %s utxos decoded again, all matched\n", human.count(decodeCheck.checked))
+    }
+
+    // Timing
+    timer.report()
+
+    // Last summary, so the file ends up with the final numbers
+    if *summaryInterval > 0 {
+        if err := writeSummary(*summaryFile, currentSummary(true)); err != nil {
+            fmt.Println("Couldn't write summary file:", err)
+            logger.Error("error writing summary file", "file", *summaryFile, "error", err.Error())
+        }
+    }
+
+    logger.Info("finished", "entries", i, "utxos", count, "housekeeping_keys", housekeeping, "distinct_txids", distinctTxids, "distinct_addresses", distinctAddresses, "total_amount", totals.amount, "immature_amount", totals.immature, "script_types", totals.scriptTypes)
+
 }
@@ -0,0 +1,110 @@
+package main
+
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcleveldb"
+import "github.com/in3rsha/bitcoin-utxo-dump/bitcoin/btcscript"
+
+import "encoding/hex"
+import "fmt"
+import "math/rand" // picking the sample
+import "strconv"
+
+// Decode Self-Check (-verify-decode-sample)
+// -----------------------------------------
+// The main loop reuses buffers and maps to go faster (the iterator's key and value, the output map, the txid buffer),
+// and a mistake there would quietly put one utxo's data in another utxo's row. So for a random sample of entries we
+// decode the key and value again from fresh copies, twice, with a plain decoder that doesn't reuse anything, and check
+// the two decodes agree with each other and with the row the main loop is about to write.
+type decodeVerifier struct {
+    every   int // check one in every n entries (on average)
+    checked int
+    params  networkParams
+    key     []byte // obfuscate key
+}
+
+// the fields the plain decoder works out (any others in the row aren't checked)
+var verifiedFields = []string{"txid", "vout", "height", "coinbase", "amount", "nsize", "script", "address"}
+
+func newDecodeVerifier(every int, obfuscateKey []byte, params networkParams) *decodeVerifier {
+    return &decodeVerifier{every: every, key: obfuscateKey, params: params}
+}
+
+// sample picks whether to check this entry
+func (d *decodeVerifier) sample() bool {
+    return d != nil && rand.Intn(d.every) == 0
+}
+
+// check decodes the entry twice and compares it with the row (skipping the address if it hasn't been worked out yet)
+func (d *decodeVerifier) check(key []byte, value []byte, output map[string]string, withAddress bool) error {
+    d.checked++
+    first := d.decode(append([]byte{}, key...), append([]byte{}, value...))
+    second := d.decode(append([]byte{}, key...), append([]byte{}, value...))
+
+    for _, field := range verifiedFields {
+        if first[field] != second[field] {
+            return fmt.Errorf("decode mismatch for %x: %s is %q the first time and %q the second time", key, field, first[field], second[field])
+        }
+        got, ok := output[field]
+        if !ok || (field == "address" && !withAddress) {
+            continue // not one of the -f fields
+        }
+        if got != first[field] {
+            return fmt.Errorf("decode mismatch for %x: %s is %q in the row but %q when decoded again", key, field, got, first[field])
+        }
+    }
+    return nil
+}
+
+// decode is a plain decoder for a utxo entry that doesn't reuse any buffers (kept simple instead of fast)
+func (d *decodeVerifier) decode(key []byte, value []byte) map[string]string {
+    fields := map[string]string{}
+
+    // key = 43 + txid (little-endian) + vout (varint)
+    txid := make([]byte, 32)
+    for i := 0; i < 32; i++ {
+        txid[i] = key[32-i]
+    }
+    fields["txid"] = hex.EncodeToString(txid)
+    fields["vout"] = strconv.Itoa(btcleveldb.Varint128Decode(key[33:]))
+
+    // value = varint(height << 1 | coinbase) + varint(compressed amount) + varint(nsize) + script
+    // (the errors can be ignored, a value with a varint that's been cut short would already have been skipped)
+    xor := btcleveldb.Deobfuscate(value, d.key)
+    code, n, _ := btcleveldb.Varint128Read(xor, 0)
+    offset := n
+    heightCode := btcleveldb.Varint128Decode(code)
+    fields["height"] = strconv.Itoa(heightCode >> 1)
+    fields["coinbase"] = strconv.Itoa(heightCode & 1)
+
+    compressed, n, _ := btcleveldb.Varint128Read(xor, offset)
+    offset += n
+    fields["amount"] = strconv.Itoa(btcleveldb.DecompressValue(btcleveldb.Varint128Decode(compressed)))
+
+    nsizeVarint, n, _ := btcleveldb.Varint128Read(xor, offset)
+    offset += n
+    nsize := btcleveldb.Varint128Decode(nsizeVarint)
+    fields["nsize"] = strconv.Itoa(nsize)
+    if nsize > 1 && nsize < 6 {
+        offset-- // the nsize is the first byte of the public key
+    }
+    script := xor[offset:]
+    fields["script"] = scriptField(nsize, script)
+
+    scriptType := ""
+    switch {
+    case nsize == 0:
+        scriptType = "p2pkh"
+    case nsize == 1:
+        scriptType = "p2sh"
+    case nsize == 28 && btcscript.IsP2WPKH(script):
+        scriptType = "p2wpkh"
+    case nsize == 40 && btcscript.IsP2WSH(script):
+        scriptType = "p2wsh"
+    case nsize == 40 && btcscript.IsP2TR(script):
+        scriptType = "p2tr"
+    case nsize > 5 && btcscript.WitnessVersion(script) >= 2:
+        scriptType = "witness_v" // (any future version gets encoded the same way)
+    }
+    fields["address"] = encodeAddress(scriptType, script, d.params)
+
+    return fields
+}
@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+import "strconv"
+
+// Vout Hex (-vout-hex)
+// --------------------
+// Writes the vout as 8 hex digits (the 4 bytes of a uint32, most significant first) instead of a decimal number:
+//
+//   0 -> 00000000, 1 -> 00000001, 5504 -> 00001580
+//
+// They're all the same width, so they sort in the right order as plain strings. This is only how the number is written
+// (it isn't the little-endian bytes from a transaction, the outpoint_bin field has those).
+//
+// This goes in between any -sort and the -format writer, so everything before it still gets the vout as a number (the
+// -chain-hash is after it though, so the row_hash is a hash of the vout that actually gets written). The vout goes back
+// to the number once the row has been written, as the output map gets reused for the next utxo.
+type voutHexWriter struct {
+    out rowWriter
+}
+
+func (v *voutHexWriter) Header(fields []string) error {
+    return v.out.Header(fields)
+}
+
+func (v *voutHexWriter) Row(output map[string]string) error {
+    vout, ok := output["vout"]
+    if !ok {
+        return v.out.Row(output)
+    }
+    n, err := strconv.ParseUint(vout, 10, 32)
+    if err != nil {
+        return fmt.Errorf("-vout-hex: bad vout %q", vout)
+    }
+    output["vout"] = fmt.Sprintf("%08x", n)
+    err = v.out.Row(output)
+    output["vout"] = vout
+    return err
+}
+
+func (v *voutHexWriter) Close() error {
+    return v.out.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+import "log/slog"
+import "os"
+import "sync/atomic" // the main loop and the watchdog goroutine both use the timestamp
+import "time"
+
+// Stall Watchdog (-stall-timeout)
+// -------------------------------
+// In a cron job or CI a scan that has hung (e.g. LevelDB waiting on a dead disk) is worse than one that's slow, because
+// nothing ever tells you about it. The main loop stores the time it last processed an entry, and a goroutine checks it
+// every so often. If nothing has been processed for -stall-timeout it prints (and logs) a warning, and with -stall-abort
+// it stops the scan with a non-zero status so the job fails instead of hanging forever.
+//
+// The stop goes through the main loop (see stop.go), so the -sort temp files get removed and the rows written so far get
+// flushed on the way out. But if the loop really is stuck (e.g. in a read that never comes back) it never gets to see it,
+// so if it still hasn't stopped after another -stall-timeout, it exits from here without cleaning up.
+type watchdog struct {
+    last    atomic.Int64 // unix nanoseconds of the last progress
+    stopped chan struct{}
+}
+
+// exit code when -stall-abort stops the scan
+const stallExitCode = 3
+
+func newWatchdog(timeout time.Duration, abort bool, stop *scanStop, logger *slog.Logger) *watchdog {
+    w := &watchdog{stopped: make(chan struct{})}
+    w.touch()
+
+    // check a few times per timeout, so we notice a stall not long after it happens
+    interval := timeout / 4
+    if interval < 10*time.Millisecond {
+        interval = 10 * time.Millisecond
+    }
+
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        warned := false // only warn once per stall (not on every tick)
+        var aborted time.Time // when we asked the main loop to stop (-stall-abort)
+        for {
+            select {
+            case <-w.stopped:
+                return
+            case <-ticker.C:
+                stalled := time.Since(time.Unix(0, w.last.Load()))
+                if !aborted.IsZero() && time.Since(aborted) >= timeout { // (the loop never got to the stop)
+                    fmt.Println("Still stuck after -stall-abort, exiting without cleaning up.")
+                    logger.Error("stalled, exiting", "seconds", int(stalled.Seconds()))
+                    os.Exit(stallExitCode)
+                }
+                if stalled < timeout {
+                    warned = false
+                    continue
+                }
+                if warned {
+                    continue
+                }
+                warned = true
+                fmt.Printf("Warning: nothing has been processed for %s (-stall-timeout %s)\n", stalled.Round(time.Second), timeout)
+                logger.Warn("stalled", "seconds", int(stalled.Seconds()), "abort", abort)
+                if abort && aborted.IsZero() {
+                    fmt.Println("Stopping because of -stall-abort.")
+                    stop.request(stallExitCode)
+                    aborted = time.Now()
+                }
+            }
+        }
+    }()
+
+    return w
+}
+
+// touch records that we're still making progress
+func (w *watchdog) touch() {
+    w.last.Store(time.Now().UnixNano())
+}
+
+// stop the watchdog (e.g. once the loop has finished, so writing the final stats doesn't count as a stall)
+func (w *watchdog) stop() {
+    close(w.stopped)
+}
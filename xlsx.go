@@ -0,0 +1,128 @@
+package main
+
+import "archive/zip"   // an xlsx file is a zip of xml files
+import "bufio"
+import "encoding/xml" // escaping strings
+import "fmt"
+import "io"
+import "strings"
+
+// Excel (-format xlsx)
+// -----
+// A minimal .xlsx workbook with one sheet, for small filtered dumps (e.g. the utxos for one wallet with -address) that
+// people want to open straight in Excel. The int fields are written as number cells and everything else as text cells.
+//
+// The sheet gets streamed in to the zip as we go, and the other (fixed) parts of the workbook get added at the end.
+// Excel can't open a sheet with more than 1,048,576 rows, so it stops with an error if the dump gets bigger than
+// -xlsx-max-rows (which can't be set any higher than that).
+const xlsxRowLimit = 1048576 - 1 // rows in an excel sheet (minus the header)
+
+type xlsxWriter struct {
+    w       *bufio.Writer
+    maxRows int
+    zip     *zip.Writer
+    sheet   io.Writer
+    fields  []string
+    rows    int
+}
+
+func (x *xlsxWriter) Header(fields []string) error {
+    if x.maxRows <= 0 || x.maxRows > xlsxRowLimit {
+        x.maxRows = xlsxRowLimit
+    }
+    x.fields = fields
+    x.zip = zip.NewWriter(x.w)
+
+    sheet, err := x.zip.Create("xl/worksheets/sheet1.xml")
+    if err != nil {
+        return err
+    }
+    x.sheet = sheet
+    if _, err := io.WriteString(x.sheet, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+        return err
+    }
+
+    // header row (all text)
+    header := map[string]string{}
+    for _, v := range fields {
+        header[v] = v
+    }
+    return x.writeRow(1, header, false)
+}
+
+func (x *xlsxWriter) Row(output map[string]string) error {
+    if x.rows >= x.maxRows {
+        return fmt.Errorf("-format xlsx can only write %d rows (-xlsx-max-rows). It's meant for small dumps, so use -address or another filter to get fewer utxos, or use -format csv", x.maxRows)
+    }
+    x.rows++
+    return x.writeRow(x.rows+1, output, true)
+}
+
+// writeRow writes a <row> of cells (r is the row number, starting at 1)
+func (x *xlsxWriter) writeRow(r int, output map[string]string, typed bool) error {
+    var row strings.Builder
+    fmt.Fprintf(&row, `<row r="%d">`, r)
+    for i, v := range x.fields {
+        ref := fmt.Sprintf("%s%d", xlsxColumn(i), r) // e.g. A1
+        if typed && fieldTypes[v] == "int" && output[v] != "" {
+            fmt.Fprintf(&row, `<c r="%s"><v>%s</v></c>`, ref, output[v])
+        } else {
+            fmt.Fprintf(&row, `<c r="%s" t="inlineStr"><is><t>`, ref)
+            xml.EscapeText(&row, []byte(output[v]))
+            row.WriteString(`</t></is></c>`)
+        }
+    }
+    row.WriteString(`</row>`)
+    _, err := io.WriteString(x.sheet, row.String())
+    return err
+}
+
+func (x *xlsxWriter) Close() error {
+    if x.zip == nil {
+        return nil
+    }
+    if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+        return err
+    }
+
+    // the rest of the workbook
+    parts := []struct {
+        name    string
+        content string
+    }{
+        {"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+            `<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+            `<Default Extension="xml" ContentType="application/xml"/>` +
+            `<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+            `<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+            `</Types>`},
+        {"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+            `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+            `</Relationships>`},
+        {"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+            `<sheets><sheet name="utxos" sheetId="1" r:id="rId1"/></sheets>` +
+            `</workbook>`},
+        {"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+            `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+            `</Relationships>`},
+    }
+    for _, part := range parts {
+        w, err := x.zip.Create(part.name)
+        if err != nil {
+            return err
+        }
+        if _, err := io.WriteString(w, xml.Header+part.content); err != nil {
+            return err
+        }
+    }
+    return x.zip.Close() // writes the zip directory (doesn't close the file)
+}
+
+// xlsxColumn returns the letters for a column number (0 = A, 25 = Z, 26 = AA)
+func xlsxColumn(i int) string {
+    column := ""
+    for i++; i > 0; i = (i - 1) / 26 {
+        column = string(rune('A'+(i-1)%26)) + column
+    }
+    return column
+}